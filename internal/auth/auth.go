@@ -1,49 +1,121 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/s3err"
+)
+
+const (
+	signV2Algorithm = "AWS"
+	signV4Algorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// amzDateFormat is the layout of X-Amz-Date and dateStampFormat that
+	// of the date in a credential scope
+	amzDateFormat   = "20060102T150405Z"
+	dateStampFormat = "20060102"
+
+	// maxPresignExpiry is the longest validity S3 allows for a presigned URL
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	// maxClockSkew is how far the signing time of a request may be from the
+	// server clock, limiting how long a captured request can be replayed
+	maxClockSkew = 15 * time.Minute
 )
 
 type Authenticator struct {
-	config *config.Config
+	config      *config.Config
+	credentials *credentialStore
+	policies    PolicySource
+	now         func() time.Time
 }
 
 func New(config *config.Config) *Authenticator {
-	return &Authenticator{config: config}
+	return &Authenticator{
+		config:      config,
+		credentials: newCredentialStore(config.Auth),
+		now:         time.Now,
+	}
 }
 
 func (a *Authenticator) Authenticate(r *http.Request) error {
+	_, err := a.Identify(r)
+	return err
+}
+
+// Identify authenticates r and returns the identity it was signed by.
+func (a *Authenticator) Identify(r *http.Request) (*Identity, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
+		query := r.URL.Query()
+		if query.Has("X-Amz-Algorithm") {
+			log.Printf("DEBUG: Processing presigned URL\n")
+			return a.validatePresignedV4(r)
+		}
+		if query.Has("AWSAccessKeyId") {
+			log.Printf("DEBUG: Processing signature V2 presigned URL\n")
+			if !a.config.Auth.SignatureV2 {
+				return nil, ErrSignatureV2Disabled
+			}
+			return a.validatePresignedV2(r)
+		}
 		log.Printf("DEBUG: Missing authorization header\n")
-		return fmt.Errorf("missing authorization header")
+		return nil, ErrMissingAuthHeader
 	}
 
-	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256") {
+	if strings.HasPrefix(authHeader, signV2Algorithm+" ") {
+		log.Printf("DEBUG: Processing AWS signature V2 authorization\n")
+		if !a.config.Auth.SignatureV2 {
+			return nil, ErrSignatureV2Disabled
+		}
+		return a.validateV2Signature(r, authHeader)
+	}
+
+	if !strings.HasPrefix(authHeader, signV4Algorithm) {
 		log.Printf("DEBUG: Unsupported authorization method: %s\n", authHeader)
-		return fmt.Errorf("unsupported authorization method")
+		return nil, ErrUnsupportedAuthMethod
 	}
 
 	log.Printf("DEBUG: Processing AWS4-HMAC-SHA256 authorization\n")
 	return a.validateV4Signature(r, authHeader)
 }
 
-func (a *Authenticator) validateV4Signature(r *http.Request, authHeader string) error {
+// lookupCredential returns the credential of accessKey, which is a
+// temporary one if r carries a session token.
+func (a *Authenticator) lookupCredential(r *http.Request, accessKey string) (credential, error) {
+	if token := securityToken(r); token != "" {
+		return a.lookupSession(accessKey, token)
+	}
+
+	cred, ok := a.credentials.lookup(accessKey)
+	if !ok {
+		log.Printf("DEBUG: Unknown access key: %s\n", accessKey)
+		return credential{}, ErrInvalidAccessKey
+	}
+	return cred, nil
+}
+
+func (a *Authenticator) validateV4Signature(r *http.Request, authHeader string) (*Identity, error) {
 	// Expected format: AWS4-HMAC-SHA256 Credential=..., SignedHeaders=..., Signature=...
 	parts := strings.SplitN(authHeader, " ", 2)
 	if len(parts) != 2 {
 		log.Printf("DEBUG: Invalid authorization header format\n")
-		return fmt.Errorf("invalid authorization header format")
+		return nil, ErrMalformedAuthHeader
 	}
 
 	// Skip the "AWS4-HMAC-SHA256" part and parse the rest
@@ -69,112 +141,341 @@ func (a *Authenticator) validateV4Signature(r *http.Request, authHeader string)
 
 	if credentialPart == "" || signaturePart == "" || signedHeadersPart == "" {
 		log.Printf("DEBUG: Missing required authorization components\n")
-		return fmt.Errorf("missing required authorization components")
+		return nil, ErrMissingAuthComponents
+	}
+
+	credParts, err := splitCredential(credentialPart)
+	if err != nil {
+		return nil, err
 	}
 
-	credParts := strings.Split(credentialPart, "/")
-	if len(credParts) != 5 {
-		log.Printf("DEBUG: Invalid credential format, expected 5 parts, got %d\n", len(credParts))
-		return fmt.Errorf("invalid credential format")
+	signedAt, err := requestTime(r)
+	if err != nil {
+		return nil, err
+	}
+	if credParts[1] != signedAt.Format(dateStampFormat) {
+		log.Printf("DEBUG: Credential date %s does not match request date %s\n", credParts[1], signedAt.Format(amzDateFormat))
+		return nil, ErrCredentialDateMismatch
 	}
 
-	accessKey := credParts[0]
-	if accessKey != a.config.Auth.AccessKey {
-		log.Printf("DEBUG: Access key mismatch. Expected: %s, Got: %s\n", a.config.Auth.AccessKey, accessKey)
-		return fmt.Errorf("invalid access key")
+	cred, err := a.lookupCredential(r, credParts[0])
+	if err != nil {
+		return nil, err
 	}
 
-	expectedSignature, err := a.calculateSignature(r, credentialPart, signedHeadersPart)
+	payloadHash, err := requestPayloadHash(r, credParts[3])
 	if err != nil {
-		log.Printf("DEBUG: Failed to calculate signature: %v\n", err)
-		return fmt.Errorf("failed to calculate signature: %w", err)
+		return nil, err
 	}
+	canonicalRequest := a.canonicalRequest(r, signedHeadersPart, canonicalQuery(r.URL.RawQuery, ""), payloadHash)
+	log.Printf("DEBUG: Canonical request:\n%s", canonicalRequest)
 
-	if signaturePart != expectedSignature {
+	// With only a Date header, its time is signed in the X-Amz-Date format
+	scope := newSigningScope(cred.secretKey, signedAt.Format(amzDateFormat), credParts)
+	expectedSignature := scope.sign(signV4Algorithm, sha256Hash(canonicalRequest))
+	if !hmac.Equal([]byte(signaturePart), []byte(expectedSignature)) {
 		log.Printf("DEBUG: Signature mismatch. Expected: %s, Got: %s\n", expectedSignature, signaturePart)
-		return fmt.Errorf("signature mismatch")
+		return nil, ErrSignatureMismatch
+	}
+	if err := a.checkClockSkew(signedAt); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(payloadHash, "STREAMING-") {
+		if err := decodeChunkedBody(r, payloadHash, scope, expectedSignature); err != nil {
+			return nil, err
+		}
 	}
 
 	log.Printf("DEBUG: Authentication successful\n")
-	return nil
+	return &cred.identity, nil
 }
 
-func (a *Authenticator) calculateSignature(r *http.Request, credential, signedHeaders string) (string, error) {
-	canonicalRequest := a.CreateCanonicalRequest(r, signedHeaders)
+// validatePresignedV4 authenticates a request signed in the query string,
+// as produced by "aws s3 presign" and the SDK presigners. The payload of a
+// presigned request is never signed.
+func (a *Authenticator) validatePresignedV4(r *http.Request) (*Identity, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		log.Printf("DEBUG: Unsupported presign algorithm: %s\n", query.Get("X-Amz-Algorithm"))
+		return nil, ErrUnsupportedAuthMethod
+	}
+
+	credentialPart := query.Get("X-Amz-Credential")
+	amzDate := query.Get("X-Amz-Date")
+	expiresStr := query.Get("X-Amz-Expires")
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	signature := query.Get("X-Amz-Signature")
+	if credentialPart == "" || amzDate == "" || expiresStr == "" || signedHeaders == "" || signature == "" {
+		log.Printf("DEBUG: Missing required presigned URL parameters\n")
+		return nil, ErrMissingPresignParams
+	}
+
+	credParts, err := splitCredential(credentialPart)
+	if err != nil {
+		return nil, err
+	}
+
+	signedAt, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || credParts[1] != signedAt.Format(dateStampFormat) {
+		return nil, ErrInvalidPresignDate
+	}
+	expires, err := strconv.Atoi(expiresStr)
+	if err != nil || expires < 1 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, ErrInvalidPresignExpiry
+	}
+	if a.now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		log.Printf("DEBUG: Presigned URL expired at %s\n", signedAt.Add(time.Duration(expires)*time.Second))
+		return nil, ErrPresignExpired
+	}
+	if signedAt.After(a.now().Add(maxClockSkew)) {
+		log.Printf("DEBUG: Presigned URL is not valid until %s\n", signedAt)
+		return nil, ErrPresignNotYetValid
+	}
+
+	cred, err := a.lookupCredential(r, credParts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	canonicalRequest := a.canonicalRequest(r, signedHeaders, canonicalQuery(r.URL.RawQuery, "X-Amz-Signature"), unsignedPayload)
 	log.Printf("DEBUG: Canonical request:\n%s", canonicalRequest)
 
+	expectedSignature := newSigningScope(cred.secretKey, amzDate, credParts).sign(signV4Algorithm, sha256Hash(canonicalRequest))
+	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+		log.Printf("DEBUG: Signature mismatch. Expected: %s, Got: %s\n", expectedSignature, signature)
+		return nil, ErrSignatureMismatch
+	}
+
+	log.Printf("DEBUG: Authentication successful\n")
+	return &cred.identity, nil
+}
+
+// maxSignedBodySize bounds the bodies hashed by requestPayloadHash.
+const maxSignedBodySize = 1 << 20
+
+// requestPayloadHash returns the payload hash r was signed with. S3 clients
+// send it in X-Amz-Content-Sha256; clients of other services, such as STS,
+// sign the hash of their small form-encoded bodies without sending it.
+func requestPayloadHash(r *http.Request, service string) (string, error) {
+	if payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash != "" {
+		return payloadHash, nil
+	}
+	if service == "s3" {
+		return unsignedPayload, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxSignedBodySize {
+		return "", ErrRequestBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return hex.EncodeToString(sha256Sum(body)), nil
+}
+
+// requestTimeLayouts are the accepted formats of X-Amz-Date and Date.
+// Signature version 2 clients send X-Amz-Date as an HTTP date, and many
+// send Date with a numeric zone.
+var requestTimeLayouts = []string{amzDateFormat, http.TimeFormat, time.RFC1123Z, time.RFC1123, time.RFC850, time.ANSIC}
+
+// requestTime returns when r was signed, taken from X-Amz-Date or, in its
+// absence, the Date header.
+func requestTime(r *http.Request) (time.Time, error) {
+	date := r.Header.Get("X-Amz-Date")
+	if date == "" {
+		date = r.Header.Get("Date")
+	}
+	if date == "" {
+		return time.Time{}, ErrMissingDate
+	}
+
+	for _, layout := range requestTimeLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	log.Printf("DEBUG: Invalid request date: %s\n", date)
+	return time.Time{}, ErrMissingDate
+}
+
+// checkClockSkew rejects requests signed more than maxClockSkew away from
+// the server clock.
+func (a *Authenticator) checkClockSkew(signedAt time.Time) error {
+	skew := a.now().Sub(signedAt)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		log.Printf("DEBUG: Request time %s is too skewed from server time %s\n", signedAt, a.now().UTC())
+		return ErrRequestTimeTooSkewed
+	}
+	return nil
+}
+
+// signingScope holds what every signature of one request is derived from:
+// the request time, the credential scope and the signing key.
+type signingScope struct {
+	amzDate string
+	scope   string
+	key     []byte
+}
+
+// splitCredential splits a credential into the access key and the parts of
+// its scope: date, region, service and the aws4_request terminator.
+func splitCredential(credential string) ([]string, error) {
 	credParts := strings.Split(credential, "/")
+	if len(credParts) != 5 || credParts[4] != "aws4_request" {
+		log.Printf("DEBUG: Invalid credential format: %s\n", credential)
+		return nil, ErrInvalidCredential
+	}
+	return credParts, nil
+}
+
+// newSigningScope derives the signing scope for credParts (access key,
+// date, region, service, terminator).
+func newSigningScope(secretKey, amzDate string, credParts []string) *signingScope {
 	dateStamp := credParts[1]
 	region := credParts[2]
 	service := credParts[3]
 
-	algorithm := "AWS4-HMAC-SHA256"
-	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service)
+	return &signingScope{
+		amzDate: amzDate,
+		scope:   fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service),
+		key:     getSigningKey(secretKey, dateStamp, region, service),
+	}
+}
 
-	amzDate := r.Header.Get("X-Amz-Date")
+// sign returns the hex signature of the string to sign made of algorithm,
+// the request time, the credential scope and the given trailing lines.
+func (s *signingScope) sign(algorithm string, lines ...string) string {
+	stringToSign := strings.Join(append([]string{algorithm, s.amzDate, s.scope}, lines...), "\n")
+	log.Printf("DEBUG: String to sign:\n%s", stringToSign)
 
-	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%s",
-		algorithm,
-		amzDate,
-		credentialScope,
-		sha256Hash(canonicalRequest))
+	return hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+}
 
-	log.Printf("DEBUG: String to sign:\n%s", stringToSign)
+func (a *Authenticator) CreateCanonicalRequest(r *http.Request, signedHeaders string) string {
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
 
-	signingKey := a.getSigningKey(a.config.Auth.SecretKey, dateStamp, region, service)
-	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	return a.canonicalRequest(r, signedHeaders, canonicalQuery(r.URL.RawQuery, ""), payloadHash)
+}
 
-	return signature, nil
+// canonicalQuery sorts and re-encodes a raw query string, leaving out the
+// parameter named exclude. Parameters without a value, such as ?uploads,
+// are signed with an empty one.
+func canonicalQuery(rawQuery, exclude string) string {
+	type param struct{ key, value string }
+
+	var params []param
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key = unescapeQuery(key)
+		if key == exclude {
+			continue
+		}
+		params = append(params, param{uriEncode(key, true), uriEncode(unescapeQuery(value), true)})
+	}
+
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.key + "=" + p.value
+	}
+	return strings.Join(parts, "&")
 }
 
-func (a *Authenticator) CreateCanonicalRequest(r *http.Request, signedHeaders string) string {
-	method := r.Method
-	uri := r.URL.Path
-	if uri == "" {
-		uri = "/"
-	}
-
-	query := r.URL.RawQuery
-	if query != "" {
-		values, _ := url.ParseQuery(query)
-		var keys []string
-		for k := range values {
-			keys = append(keys, k)
+// unescapeQuery decodes a query component the way handlers see it through
+// r.URL.Query(), so the signature covers the values they act on. Invalid
+// escapes are signed as sent.
+func unescapeQuery(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// canonicalURI returns the path of r encoded once per segment, as S3
+// signs it. Segments are decoded from the escaped path rather than taken
+// from r.URL.Path so that an encoded slash in a key stays encoded.
+func canonicalURI(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
 		}
-		sort.Strings(keys)
+		segments[i] = uriEncode(segment, true)
+	}
+	return strings.Join(segments, "/")
+}
 
-		var parts []string
-		for _, k := range keys {
-			for _, v := range values[k] {
-				parts = append(parts, fmt.Sprintf("%s=%s", url.QueryEscape(k), url.QueryEscape(v)))
-			}
+// uriEncode percent-encodes every byte of s except the RFC 3986 unreserved
+// characters, and '/' unless encodeSlash is set, with upper-case hex digits
+// as SigV4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~',
+			c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
 		}
-		query = strings.Join(parts, "&")
 	}
+	return b.String()
+}
 
+func (a *Authenticator) canonicalRequest(r *http.Request, signedHeaders, query, payloadHash string) string {
 	headerNames := strings.Split(signedHeaders, ";")
 	sort.Strings(headerNames)
 
 	var canonicalHeaders []string
 	for _, name := range headerNames {
-		var value string
-		if strings.ToLower(name) == "host" {
-			// Use r.Host instead of r.Header.Get("Host")
-			value = r.Host
+		name = strings.ToLower(name)
+
+		var values []string
+		if name == "host" {
+			// Go moves the Host header out of r.Header
+			values = []string{r.Host}
 		} else {
-			value = r.Header.Get(name)
+			values = r.Header.Values(name)
 		}
-		canonicalHeaders = append(canonicalHeaders, fmt.Sprintf("%s:%s", strings.ToLower(name), strings.TrimSpace(value)))
-	}
 
-	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
-	if payloadHash == "" {
-		payloadHash = "UNSIGNED-PAYLOAD"
+		// Repeated headers are joined with commas, and runs of whitespace
+		// within a value, including folded lines, collapse to one space
+		folded := make([]string, len(values))
+		for i, value := range values {
+			folded[i] = strings.Join(strings.Fields(value), " ")
+		}
+		canonicalHeaders = append(canonicalHeaders, name+":"+strings.Join(folded, ","))
 	}
 
 	canonicalRequest := fmt.Sprintf("%s\n%s\n%s\n%s\n\n%s\n%s",
-		method,
-		uri,
+		r.Method,
+		canonicalURI(r),
 		query,
 		strings.Join(canonicalHeaders, "\n"),
 		signedHeaders,
@@ -183,7 +484,7 @@ func (a *Authenticator) CreateCanonicalRequest(r *http.Request, signedHeaders st
 	return canonicalRequest
 }
 
-func (a *Authenticator) getSigningKey(secretKey, dateStamp, region, service string) []byte {
+func getSigningKey(secretKey, dateStamp, region, service string) []byte {
 	kDate := hmacSHA256([]byte("AWS4"+secretKey), dateStamp)
 	kRegion := hmacSHA256(kDate, region)
 	kService := hmacSHA256(kRegion, service)
@@ -206,12 +507,23 @@ func sha256Hash(data string) string {
 func (a *Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("DEBUG: AuthMiddleware called for %s %s", r.Method, r.URL.Path)
-		if err := a.Authenticate(r); err != nil {
+		identity, err := a.Identify(r)
+		if err != nil {
 			log.Printf("DEBUG: Authentication failed: %v", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			s3err.Write(w, r, apiErrorFor(err))
 			return
 		}
-		log.Printf("DEBUG: Authentication successful for %s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
+		log.Printf("AUDIT: user=%s access_key=%s %s %s", identity.Name, identity.AccessKey, r.Method, r.URL.Path)
+
+		authorize := a.newAuthorizer(r, identity)
+		if action, bucket, key := requestAction(r); action != "" {
+			if err := authorize(action, bucket, key); err != nil {
+				s3err.Write(w, r, apiErrorFor(err))
+				return
+			}
+		}
+
+		ctx := context.WithValue(WithIdentity(r.Context(), identity), authorizerKey{}, authorize)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
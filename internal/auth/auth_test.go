@@ -1,12 +1,17 @@
 package auth
 
 import (
+	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
 )
 
 func TestAuthenticator(t *testing.T) {
@@ -79,6 +84,21 @@ func TestAuthenticator(t *testing.T) {
 		}
 	})
 
+	t.Run("InvalidCredentialScope", func(t *testing.T) {
+		for _, credential := range []string{
+			"test-access-key/20230101/us-east-1/s3/aws4",
+			"test-access-key/20230101/us-east-1/s3/aws4_request/extra",
+			"test-access-key/20230101/s3/aws4_request",
+		} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credential+", SignedHeaders=host, Signature=test")
+			req.Header.Set("X-Amz-Date", "20230101T000000Z")
+			if err := auth.Authenticate(req); err != ErrInvalidCredential {
+				t.Errorf("%s: expected ErrInvalidCredential, got %v", credential, err)
+			}
+		}
+	})
+
 	t.Run("InvalidAccessKey", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/", nil)
 		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=wrong-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=test")
@@ -116,8 +136,33 @@ func TestAuthMiddleware(t *testing.T) {
 
 		middleware.ServeHTTP(w, req)
 
-		if w.Code != http.StatusUnauthorized {
-			t.Errorf("Expected status 401, got %d", w.Code)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+
+		var result s3err.ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.Code != "AccessDenied" {
+			t.Errorf("Expected code 'AccessDenied', got '%s'", result.Code)
+		}
+	})
+
+	t.Run("SignatureMismatch", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=test-access-key/20230101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=bad")
+		req.Header.Set("X-Amz-Date", "20230101T000000Z")
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		var result s3err.ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.Code != "SignatureDoesNotMatch" {
+			t.Errorf("Expected code 'SignatureDoesNotMatch', got '%s'", result.Code)
 		}
 	})
 
@@ -130,8 +175,8 @@ func TestAuthMiddleware(t *testing.T) {
 		middleware.ServeHTTP(w, req)
 
 		// Should be unauthorized without proper signature
-		if w.Code != http.StatusUnauthorized {
-			t.Errorf("Expected status 401, got %d", w.Code)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
 		}
 	})
 }
@@ -171,3 +216,181 @@ func TestCreateCanonicalRequest(t *testing.T) {
 		t.Errorf("Canonical request mismatch.\nExpected:\n%s\nGot:\n%s", expected, canonical)
 	}
 }
+
+func TestPresignedURL(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey: "test-access-key",
+			SecretKey: "test-secret-key",
+		},
+	}
+
+	auth := New(cfg)
+	signer := v4.NewSigner(credentials.NewStaticCredentials(cfg.Auth.AccessKey, cfg.Auth.SecretKey, ""))
+	signedAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	presign := func(t *testing.T, method, target string, expires time.Duration) *http.Request {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", expires, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		return httptest.NewRequest(method, req.URL.String(), nil)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt.Add(10 * time.Minute) }
+		for _, method := range []string{"GET", "PUT"} {
+			req := presign(t, method, "http://localhost:9000/bucket/photos/cat.jpg?versionId=v1", time.Hour)
+			if err := auth.Authenticate(req); err != nil {
+				t.Errorf("%s: expected presigned request to authenticate, got %v", method, err)
+			}
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt.Add(2 * time.Hour) }
+		req := presign(t, "GET", "http://localhost:9000/bucket/object", time.Hour)
+		if err := auth.Authenticate(req); err != ErrPresignExpired {
+			t.Errorf("Expected ErrPresignExpired, got %v", err)
+		}
+	})
+
+	t.Run("ExpiresTooLong", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := presign(t, "GET", "http://localhost:9000/bucket/object", 8*24*time.Hour)
+		if err := auth.Authenticate(req); err != ErrInvalidPresignExpiry {
+			t.Errorf("Expected ErrInvalidPresignExpiry, got %v", err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := presign(t, "GET", "http://localhost:9000/bucket/object", time.Hour)
+		req.URL.Path = "/bucket/other"
+		if err := auth.Authenticate(req); err != ErrSignatureMismatch {
+			t.Errorf("Expected ErrSignatureMismatch for another key, got %v", err)
+		}
+
+		req = presign(t, "GET", "http://localhost:9000/bucket/object", time.Hour)
+		req.Method = "DELETE"
+		if err := auth.Authenticate(req); err != ErrSignatureMismatch {
+			t.Errorf("Expected ErrSignatureMismatch for another method, got %v", err)
+		}
+	})
+
+	t.Run("InvalidCredentialScope", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := presign(t, "GET", "http://localhost:9000/bucket/object", time.Hour)
+		query := req.URL.Query()
+		query.Set("X-Amz-Credential", strings.TrimSuffix(query.Get("X-Amz-Credential"), "/aws4_request")+"/aws4")
+		req.URL.RawQuery = query.Encode()
+		if err := auth.Authenticate(req); err != ErrInvalidCredential {
+			t.Errorf("Expected ErrInvalidCredential, got %v", err)
+		}
+	})
+
+	t.Run("MissingParameters", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bucket/object?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=20230101T000000Z", nil)
+		w := httptest.NewRecorder()
+		auth.AuthMiddleware(http.NotFoundHandler()).ServeHTTP(w, req)
+
+		var result s3err.ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if w.Code != http.StatusBadRequest || result.Code != "AuthorizationQueryParametersError" {
+			t.Errorf("Expected AuthorizationQueryParametersError, got %d %s", w.Code, result.Code)
+		}
+	})
+}
+
+func TestRequestTime(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey: "test-access-key",
+			SecretKey: "test-secret-key",
+		},
+	}
+
+	auth := New(cfg)
+	signer := v4.NewSigner(credentials.NewStaticCredentials(cfg.Auth.AccessKey, cfg.Auth.SecretKey, ""))
+	signedAt := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	sign := func(t *testing.T) *http.Request {
+		t.Helper()
+		req := httptest.NewRequest("GET", "http://localhost:9000/bucket/object", nil)
+		if _, err := signer.Sign(req, nil, "s3", "us-east-1", signedAt); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		return req
+	}
+
+	t.Run("WithinSkew", func(t *testing.T) {
+		for _, offset := range []time.Duration{-14 * time.Minute, 0, 14 * time.Minute} {
+			auth.now = func() time.Time { return signedAt.Add(offset) }
+			if err := auth.Authenticate(sign(t)); err != nil {
+				t.Errorf("Offset %s: expected request to authenticate, got %v", offset, err)
+			}
+		}
+	})
+
+	t.Run("Replayed", func(t *testing.T) {
+		for _, offset := range []time.Duration{-16 * time.Minute, 16 * time.Minute, 24 * time.Hour} {
+			auth.now = func() time.Time { return signedAt.Add(offset) }
+			err := auth.Authenticate(sign(t))
+			if err != ErrRequestTimeTooSkewed {
+				t.Errorf("Offset %s: expected ErrRequestTimeTooSkewed, got %v", offset, err)
+			}
+			if apiErr := apiErrorFor(err); apiErr.Code != "RequestTimeTooSkewed" || apiErr.StatusCode != http.StatusForbidden {
+				t.Errorf("Offset %s: expected RequestTimeTooSkewed, got %+v", offset, apiErr)
+			}
+		}
+	})
+
+	t.Run("ScopeDateMismatch", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := sign(t)
+		req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "/20230101/", "/20230102/", 1))
+		if err := auth.Authenticate(req); err != ErrCredentialDateMismatch {
+			t.Errorf("Expected ErrCredentialDateMismatch, got %v", err)
+		}
+	})
+
+	t.Run("DateHeaderFallback", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := httptest.NewRequest("GET", "http://localhost:9000/bucket/object", nil)
+		req.Header.Set("Date", signedAt.Format(http.TimeFormat))
+
+		// The SDK always sends X-Amz-Date, so sign by hand: the Date header
+		// is signed as if it were X-Amz-Date
+		credParts := []string{cfg.Auth.AccessKey, "20230101", "us-east-1", "s3", "aws4_request"}
+		signature := newSigningScope(cfg.Auth.SecretKey, "20230101T120000Z", credParts).
+			sign(signV4Algorithm, sha256Hash(auth.CreateCanonicalRequest(req, "date;host")))
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+strings.Join(credParts, "/")+", SignedHeaders=date;host, Signature="+signature)
+
+		if err := auth.Authenticate(req); err != nil {
+			t.Errorf("Expected request with a Date header to authenticate, got %v", err)
+		}
+	})
+
+	t.Run("MissingDate", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt }
+		req := sign(t)
+		req.Header.Del("X-Amz-Date")
+		if err := auth.Authenticate(req); err != ErrMissingDate {
+			t.Errorf("Expected ErrMissingDate, got %v", err)
+		}
+	})
+
+	t.Run("PresignedNotYetValid", func(t *testing.T) {
+		auth.now = func() time.Time { return signedAt.Add(-time.Hour) }
+		req := httptest.NewRequest("GET", "http://localhost:9000/bucket/object", nil)
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", time.Hour, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		if err := auth.Authenticate(httptest.NewRequest("GET", req.URL.String(), nil)); err != ErrPresignNotYetValid {
+			t.Errorf("Expected ErrPresignNotYetValid, got %v", err)
+		}
+	})
+}
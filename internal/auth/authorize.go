@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/alexerm/porterfs/internal/policy"
+)
+
+// PolicySource returns the policy of bucket, or nil when it has none.
+type PolicySource func(ctx context.Context, bucket string) (*policy.Policy, error)
+
+// SetPolicySource enables bucket policy enforcement. Requests by the root
+// user and requests to buckets without a policy are always allowed; other
+// requests need a statement allowing them and none denying them. Session
+// policies of temporary credentials apply regardless.
+func (a *Authenticator) SetPolicySource(src PolicySource) {
+	a.policies = src
+}
+
+// authorizer checks one action of an authenticated request.
+type authorizer func(action, bucket, key string) error
+
+type authorizerKey struct{}
+
+type peerAddrKey struct{}
+
+// PeerAddress records the address of the connection a request arrived on.
+// It must run before middleware such as RealIP rewrites RemoteAddr from
+// client-supplied headers, so that aws:SourceIp cannot be spoofed.
+func PeerAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Authorized reports whether the request ctx belongs to may perform action
+// on bucket and key. Handlers use it for the objects a request names in its
+// body or headers rather than its path, such as the keys of a DeleteObjects
+// request or a copy source. Contexts without an authenticated request are
+// always authorized.
+func Authorized(ctx context.Context, action, bucket, key string) bool {
+	authorize, ok := ctx.Value(authorizerKey{}).(authorizer)
+	return !ok || authorize(action, bucket, key) == nil
+}
+
+// newAuthorizer returns the authorizer for requests like r by identity.
+func (a *Authenticator) newAuthorizer(r *http.Request, identity *Identity) authorizer {
+	checkBucket := a.policies != nil && !identity.Root
+	if !checkBucket && len(identity.Policies) == 0 {
+		return func(action, bucket, key string) error { return nil }
+	}
+
+	conditions := requestConditions(r, identity)
+	ctx := r.Context()
+	return func(action, bucket, key string) error {
+		req := &policy.Request{
+			User:       identity.Name,
+			Action:     action,
+			Bucket:     bucket,
+			Key:        key,
+			Conditions: conditions,
+		}
+
+		// Session policies can only narrow what their user may do
+		for _, p := range identity.Policies {
+			if p.Evaluate(req) != policy.Allow {
+				log.Printf("DEBUG: Session policy of %s denies %s on %s/%s", identity.AccessKey, action, bucket, key)
+				return ErrPolicyDenied
+			}
+		}
+		if !checkBucket {
+			return nil
+		}
+
+		p, err := a.policies(ctx, bucket)
+		if err != nil {
+			log.Printf("ERROR: Failed to load policy of bucket %s: %v", bucket, err)
+			return err
+		}
+		if p == nil {
+			return nil
+		}
+
+		if p.Evaluate(req) != policy.Allow {
+			log.Printf("DEBUG: Policy of bucket %s denies %s on %q to %s", bucket, action, key, identity.Name)
+			return ErrPolicyDenied
+		}
+		return nil
+	}
+}
+
+// requestConditions returns the policy condition keys of r.
+func requestConditions(r *http.Request, identity *Identity) map[string]string {
+	conditions := map[string]string{
+		policy.KeyUsername:        identity.Name,
+		policy.KeySecureTransport: "false",
+	}
+	if r.TLS != nil {
+		conditions[policy.KeySecureTransport] = "true"
+	}
+
+	// Forwarding headers are set by the client, so only the connection's
+	// own address is trusted
+	ip, ok := r.Context().Value(peerAddrKey{}).(string)
+	if !ok {
+		ip = r.RemoteAddr
+	}
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	conditions[policy.KeySourceIP] = ip
+
+	query := r.URL.Query()
+	for param, key := range map[string]string{
+		"prefix":    policy.KeyPrefix,
+		"delimiter": policy.KeyDelimiter,
+		"versionId": policy.KeyVersionID,
+	} {
+		if query.Has(param) {
+			conditions[key] = query.Get(param)
+		}
+	}
+	return conditions
+}
+
+// requestAction returns the S3 action r performs and the bucket and key it
+// addresses. The action is empty for requests that are not checked against
+// a bucket policy here: ListBuckets, which addresses no bucket, and bucket
+// POSTs, which are DeleteObjects requests whose keys the handler checks one
+// by one.
+func requestAction(r *http.Request) (action, bucket, key string) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		return "", "", ""
+	}
+
+	query := r.URL.Query()
+	if key == "" {
+		return bucketAction(r.Method, query.Has), bucket, ""
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case query.Has("uploadId"):
+			return "s3:ListMultipartUploadParts", bucket, key
+		case query.Get("versionId") != "":
+			return "s3:GetObjectVersion", bucket, key
+		}
+		return "s3:GetObject", bucket, key
+	case http.MethodDelete:
+		switch {
+		case query.Get("uploadId") != "":
+			return "s3:AbortMultipartUpload", bucket, key
+		case query.Get("versionId") != "":
+			return "s3:DeleteObjectVersion", bucket, key
+		}
+		return "s3:DeleteObject", bucket, key
+	}
+	// PUT and POST upload objects, copies and multipart uploads
+	return "s3:PutObject", bucket, key
+}
+
+func bucketAction(method string, has func(string) bool) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case has("uploads"):
+			return "s3:ListBucketMultipartUploads"
+		case has("versioning"):
+			return "s3:GetBucketVersioning"
+		case has("lifecycle"):
+			return "s3:GetLifecycleConfiguration"
+		case has("policy"):
+			return "s3:GetBucketPolicy"
+		case has("versions"):
+			return "s3:ListBucketVersions"
+		}
+		return "s3:ListBucket"
+	case http.MethodPut:
+		switch {
+		case has("versioning"):
+			return "s3:PutBucketVersioning"
+		case has("lifecycle"):
+			return "s3:PutLifecycleConfiguration"
+		case has("policy"):
+			return "s3:PutBucketPolicy"
+		}
+		return "s3:CreateBucket"
+	case http.MethodDelete:
+		switch {
+		case has("lifecycle"):
+			// S3 has no separate action for deleting a lifecycle
+			return "s3:PutLifecycleConfiguration"
+		case has("policy"):
+			return "s3:DeleteBucketPolicy"
+		}
+		return "s3:DeleteBucket"
+	}
+	return ""
+}
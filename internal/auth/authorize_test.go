@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/policy"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+func TestBucketPolicyEnforcement(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey: "root-access-key",
+			SecretKey: "root-secret-key",
+			Users: []config.UserConfig{
+				{Name: "consumer", AccessKey: "consumer-access-key", SecretKey: "consumer-secret-key"},
+				{Name: "producer", AccessKey: "producer-access-key", SecretKey: "producer-secret-key"},
+			},
+		},
+	}
+	auth := New(cfg)
+
+	dataPolicy, err := policy.Parse([]byte(`{
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Principal": {"AWS": "consumer"},
+				"Action": ["s3:GetObject", "s3:ListBucket"],
+				"Resource": ["arn:aws:s3:::data", "arn:aws:s3:::data/*"]
+			},
+			{
+				"Effect": "Allow",
+				"Principal": {"AWS": "producer"},
+				"Action": ["s3:PutObject", "s3:DeleteObject"],
+				"Resource": "arn:aws:s3:::data/incoming/*",
+				"Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}
+			}
+		]
+	}`), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.SetPolicySource(func(ctx context.Context, bucket string) (*policy.Policy, error) {
+		if bucket == "data" {
+			return dataPolicy, nil
+		}
+		return nil, nil
+	})
+
+	var deletable bool
+	middleware := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deletable = Authorized(r.Context(), "s3:DeleteObject", "data", "incoming/old.csv")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	signedAt := time.Now()
+	do := func(method, path, accessKey, secretKey string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, "http://localhost:9000"+path, nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", time.Hour, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		req = httptest.NewRequest(method, req.URL.String(), nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name, method, path, user string
+		want                     int
+	}{
+		{"ConsumerReads", "GET", "/data/report.csv", "consumer", http.StatusOK},
+		{"ConsumerLists", "GET", "/data", "consumer", http.StatusOK},
+		{"ConsumerCannotWrite", "PUT", "/data/incoming/new.csv", "consumer", http.StatusForbidden},
+		{"ConsumerCannotChangePolicy", "PUT", "/data?policy", "consumer", http.StatusForbidden},
+		{"ProducerWrites", "PUT", "/data/incoming/new.csv", "producer", http.StatusOK},
+		{"ProducerCannotRead", "GET", "/data/incoming/new.csv", "producer", http.StatusForbidden},
+		{"ProducerOutsidePrefix", "PUT", "/data/report.csv", "producer", http.StatusForbidden},
+		{"RootIgnoresPolicy", "DELETE", "/data?policy", "root", http.StatusOK},
+		{"BucketWithoutPolicy", "PUT", "/scratch/file", "consumer", http.StatusOK},
+		{"ListBuckets", "GET", "/", "producer", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := cfg.Auth.Users[0]
+			switch tt.user {
+			case "producer":
+				user = cfg.Auth.Users[1]
+			case "root":
+				user = config.UserConfig{AccessKey: cfg.Auth.AccessKey, SecretKey: cfg.Auth.SecretKey}
+			}
+			if code := do(tt.method, tt.path, user.AccessKey, user.SecretKey); code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, code)
+			}
+		})
+	}
+
+	t.Run("ForwardedSourceIp", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "http://localhost:9000/data/incoming/new.csv", nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials("producer-access-key", "producer-secret-key", ""))
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", time.Hour, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		req = httptest.NewRequest("PUT", req.URL.String(), nil)
+		req.RemoteAddr = "203.0.113.9:4567"
+		req.Header.Set("X-Forwarded-For", "10.1.2.3")
+		w := httptest.NewRecorder()
+		PeerAddress(chimiddleware.RealIP(middleware)).ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected a forwarded address to be ignored, got status %d", w.Code)
+		}
+	})
+
+	t.Run("PerKeyChecks", func(t *testing.T) {
+		do("POST", "/data?delete", "producer-access-key", "producer-secret-key")
+		if !deletable {
+			t.Error("Expected producer to be allowed to delete under incoming/")
+		}
+		do("POST", "/data?delete", "consumer-access-key", "consumer-secret-key")
+		if deletable {
+			t.Error("Expected consumer to be denied deletes")
+		}
+	})
+}
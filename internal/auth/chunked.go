@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+)
+
+// Payload hashes that announce an aws-chunked body.
+const (
+	streamingPayload              = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedPayloadTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	chunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	trailerAlgorithm = "AWS4-HMAC-SHA256-TRAILER"
+
+	// emptySHA256 is the hex SHA-256 of no data, part of every chunk's
+	// string to sign.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxChunkLineLength bounds chunk header and trailer lines.
+	maxChunkLineLength = 4096
+)
+
+// trailerChecksums are the x-amz-checksum-* trailers verified against the
+// decoded body.
+var trailerChecksums = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"x-amz-checksum-crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"x-amz-checksum-sha1":   sha1.New,
+	"x-amz-checksum-sha256": sha256.New,
+}
+
+// decodeChunkedBody replaces the aws-chunked body of r with a reader that
+// yields the decoded object data, verifying each chunk signature against
+// the chain started by seedSignature. Content-Length is rewritten to the
+// decoded length so handlers see the size of the object itself.
+func decodeChunkedBody(r *http.Request, payloadHash string, scope *signingScope, seedSignature string) error {
+	reader := &chunkedReader{
+		reader:        bufio.NewReader(r.Body),
+		prevSignature: seedSignature,
+		chunkHash:     sha256.New(),
+	}
+
+	switch payloadHash {
+	case streamingPayload:
+		reader.scope = scope
+	case streamingPayloadTrailer:
+		reader.scope = scope
+		reader.signedTrailer = true
+	case streamingUnsignedPayloadTrail:
+	default:
+		log.Printf("DEBUG: Unsupported streaming payload: %s\n", payloadHash)
+		return ErrUnsupportedAuthMethod
+	}
+
+	decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || decodedLength < 0 {
+		return ErrMissingDecodedLength
+	}
+
+	if trailer := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Amz-Trailer"))); trailer != "" {
+		newHash, ok := trailerChecksums[trailer]
+		if !ok {
+			return ErrUnsupportedTrailer
+		}
+		reader.checksumName = trailer
+		reader.checksum = newHash()
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, r.Body}
+	r.ContentLength = decodedLength
+	r.Header.Set("Content-Length", strconv.FormatInt(decodedLength, 10))
+
+	// aws-chunked describes the transfer, not the stored object
+	var encodings []string
+	for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" && encoding != "aws-chunked" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if len(encodings) > 0 {
+		r.Header.Set("Content-Encoding", strings.Join(encodings, ","))
+	} else {
+		r.Header.Del("Content-Encoding")
+	}
+
+	return nil
+}
+
+// chunkedReader decodes an aws-chunked body. Chunks are streamed rather than
+// buffered, so a bad chunk signature surfaces as a read error at the end of
+// the chunk; storage then discards the partially staged write.
+type chunkedReader struct {
+	reader        *bufio.Reader
+	scope         *signingScope // nil for unsigned payloads
+	signedTrailer bool
+	prevSignature string
+
+	chunkSignature string
+	chunkHash      hash.Hash
+	remaining      int64
+	started        bool
+
+	checksumName string
+	checksum     hash.Hash
+
+	err error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	for c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	c.chunkHash.Write(p[:n])
+	if c.checksum != nil {
+		c.checksum.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		err = s3err.ErrIncompleteBody
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk finishes the current chunk and reads the header of the next
+// one. It returns io.EOF once the final chunk and trailers are consumed.
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if crlf, err := c.readLine(); err != nil || crlf != "" {
+			return s3err.ErrIncompleteBody
+		}
+		if err := c.verifyChunk(); err != nil {
+			return err
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return s3err.ErrIncompleteBody
+	}
+
+	sizeStr, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || size < 0 {
+		return s3err.ErrIncompleteBody
+	}
+	if c.scope != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok || signature == "" {
+			return s3err.ErrSignatureDoesNotMatch
+		}
+		c.chunkSignature = signature
+	}
+	c.chunkHash.Reset()
+	c.remaining = size
+
+	if size > 0 {
+		return nil
+	}
+
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	if err := c.readTrailers(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// verifyChunk checks the signature of the chunk just read, which chains on
+// the signature of the previous chunk.
+func (c *chunkedReader) verifyChunk() error {
+	if c.scope == nil {
+		return nil
+	}
+
+	expected := c.scope.sign(chunkAlgorithm, c.prevSignature, emptySHA256, hex.EncodeToString(c.chunkHash.Sum(nil)))
+	if !hmac.Equal([]byte(c.chunkSignature), []byte(expected)) {
+		log.Printf("DEBUG: Chunk signature mismatch. Expected: %s, Got: %s\n", expected, c.chunkSignature)
+		return s3err.ErrSignatureDoesNotMatch
+	}
+	c.prevSignature = expected
+	return nil
+}
+
+// readTrailers consumes the trailing headers after the final chunk up to
+// the terminating empty line, verifying the checksum trailer and, for
+// signed trailers, x-amz-trailer-signature.
+func (c *chunkedReader) readTrailers() error {
+	var trailers bytes.Buffer
+	var trailerSignature, checksum string
+
+	for {
+		line, err := c.readLine()
+		if err == io.EOF && line == "" && trailers.Len() == 0 && c.checksum == nil {
+			// Some clients omit the final CRLF when there are no trailers
+			break
+		}
+		if err != nil {
+			return s3err.ErrIncompleteBody
+		}
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return s3err.ErrIncompleteBody
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		if name == "x-amz-trailer-signature" {
+			trailerSignature = value
+			continue
+		}
+		trailers.WriteString(name + ":" + value + "\n")
+		if name == c.checksumName {
+			checksum = value
+		}
+	}
+
+	if c.signedTrailer {
+		expected := c.scope.sign(trailerAlgorithm, c.prevSignature, hex.EncodeToString(sha256Sum(trailers.Bytes())))
+		if !hmac.Equal([]byte(trailerSignature), []byte(expected)) {
+			log.Printf("DEBUG: Trailer signature mismatch. Expected: %s, Got: %s\n", expected, trailerSignature)
+			return s3err.ErrSignatureDoesNotMatch
+		}
+	}
+
+	if c.checksum != nil {
+		if checksum != base64.StdEncoding.EncodeToString(c.checksum.Sum(nil)) {
+			return s3err.ErrBadDigest
+		}
+	}
+
+	return nil
+}
+
+// readLine returns the next CRLF-terminated line without its terminator.
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		fragment, isPrefix, err := c.reader.ReadLine()
+		line = append(line, fragment...)
+		if err != nil {
+			return string(line), err
+		}
+		if len(line) > maxChunkLineLength {
+			return "", s3err.ErrIncompleteBody
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/policy"
+)
+
+// RootUser is the name of the root access key pair in AuthConfig.
+const RootUser = config.RootUser
+
+// Identity is the user a request was authenticated as. Root is set only for
+// the root access key pair and its temporary credentials. Temporary
+// credentials act as the user or web identity role they were issued to,
+// until Expiration and only as far as every one of their session Policies
+// allows.
+type Identity struct {
+	Name       string
+	AccessKey  string
+	Root       bool
+	Expiration time.Time
+	Policies   []*policy.Policy
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity AuthMiddleware attached to a
+// request context, or nil for unauthenticated requests.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+type credential struct {
+	identity  Identity
+	secretKey string
+}
+
+// credentialStore maps access keys to identities. Keys from the config are
+// fixed; keys from the users file are reloaded when its modification time
+// or size changes, and the last good set is kept if a reload fails. Users
+// in the file cannot take the name of a web identity role.
+type credentialStore struct {
+	static map[string]credential
+	file   string
+	roles  map[string]bool
+
+	mu       sync.RWMutex
+	fromFile map[string]credential
+	modTime  time.Time
+	size     int64
+}
+
+func newCredentialStore(cfg config.AuthConfig) *credentialStore {
+	s := &credentialStore{
+		static: make(map[string]credential),
+		file:   cfg.UsersFile,
+		roles:  make(map[string]bool),
+	}
+	for _, role := range cfg.OIDC.Roles {
+		s.roles[role.Name] = true
+	}
+
+	if cfg.AccessKey != "" {
+		s.static[cfg.AccessKey] = credential{
+			identity:  Identity{Name: RootUser, AccessKey: cfg.AccessKey, Root: true},
+			secretKey: cfg.SecretKey,
+		}
+	}
+	for _, user := range cfg.Users {
+		s.static[user.AccessKey] = credential{
+			identity:  Identity{Name: user.Name, AccessKey: user.AccessKey},
+			secretKey: user.SecretKey,
+		}
+	}
+
+	s.reload()
+	return s
+}
+
+// lookup returns the credential for accessKey.
+func (s *credentialStore) lookup(accessKey string) (credential, bool) {
+	if cred, ok := s.static[accessKey]; ok {
+		return cred, true
+	}
+
+	s.reload()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cred, ok := s.fromFile[accessKey]
+	return cred, ok
+}
+
+// reload re-reads the users file if it changed since it was last read.
+func (s *credentialStore) reload() {
+	if s.file == "" {
+		return
+	}
+
+	stat, err := os.Stat(s.file)
+	if err != nil {
+		log.Printf("ERROR: Failed to stat users file %s: %v", s.file, err)
+		return
+	}
+
+	s.mu.RLock()
+	unchanged := stat.ModTime().Equal(s.modTime) && stat.Size() == s.size
+	s.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	users, err := config.LoadUsers(s.file)
+	if err != nil {
+		log.Printf("ERROR: Failed to load users file %s: %v", s.file, err)
+		// Keep the previous users until the file changes again
+		s.mu.Lock()
+		s.modTime = stat.ModTime()
+		s.size = stat.Size()
+		s.mu.Unlock()
+		return
+	}
+
+	fromFile := make(map[string]credential, len(users))
+	for _, user := range users {
+		if _, ok := s.static[user.AccessKey]; ok {
+			log.Printf("WARN: Ignoring user %s in %s: access key is already configured", user.Name, s.file)
+			continue
+		}
+		if s.roles[user.Name] {
+			log.Printf("WARN: Ignoring user %s in %s: name is already a web identity role", user.Name, s.file)
+			continue
+		}
+		fromFile[user.AccessKey] = credential{
+			identity:  Identity{Name: user.Name, AccessKey: user.AccessKey},
+			secretKey: user.SecretKey,
+		}
+	}
+
+	s.mu.Lock()
+	s.fromFile = fromFile
+	s.modTime = stat.ModTime()
+	s.size = stat.Size()
+	s.mu.Unlock()
+
+	log.Printf("INFO: Loaded %d users from %s", len(fromFile), s.file)
+}
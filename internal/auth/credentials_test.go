@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+func TestMultipleUsers(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-users-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	usersFile := filepath.Join(tmpDir, "users.yaml")
+	writeUsers := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(usersFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Make the change visible on filesystems with coarse timestamps
+		if err := os.Chtimes(usersFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUsers(`
+users:
+  - name: ci
+    access_key: ci-access-key
+    secret_key: ci-secret-key
+`, time.Now().Add(-time.Hour))
+
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey: "root-access-key",
+			SecretKey: "root-secret-key",
+			Users: []config.UserConfig{
+				{Name: "alice", AccessKey: "alice-access-key", SecretKey: "alice-secret-key"},
+			},
+			UsersFile: usersFile,
+			OIDC: config.OIDCConfig{
+				Roles: []config.OIDCRoleConfig{{Name: "deploy-role"}},
+			},
+		},
+	}
+	auth := New(cfg)
+
+	signedAt := time.Now()
+	identify := func(accessKey, secretKey string) (*Identity, error) {
+		req := httptest.NewRequest("GET", "http://localhost:9000/bucket/object", nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", time.Hour, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		return auth.Identify(httptest.NewRequest("GET", req.URL.String(), nil))
+	}
+
+	tests := []struct {
+		accessKey, secretKey, user string
+	}{
+		{"root-access-key", "root-secret-key", RootUser},
+		{"alice-access-key", "alice-secret-key", "alice"},
+		{"ci-access-key", "ci-secret-key", "ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			identity, err := identify(tt.accessKey, tt.secretKey)
+			if err != nil {
+				t.Fatalf("Expected %s to authenticate, got %v", tt.user, err)
+			}
+			if identity.Name != tt.user || identity.AccessKey != tt.accessKey {
+				t.Errorf("Expected identity %s, got %+v", tt.user, identity)
+			}
+			if identity.Root != (tt.accessKey == "root-access-key") {
+				t.Errorf("Expected only the root key pair to be root, got %+v", identity)
+			}
+		})
+	}
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		if _, err := identify("alice-access-key", "root-secret-key"); err != ErrSignatureMismatch {
+			t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+		}
+	})
+
+	t.Run("UsersFileReload", func(t *testing.T) {
+		writeUsers(`
+users:
+  - name: deploy
+    access_key: deploy-access-key
+    secret_key: deploy-secret-key
+`, time.Now())
+
+		if identity, err := identify("deploy-access-key", "deploy-secret-key"); err != nil || identity.Name != "deploy" {
+			t.Errorf("Expected the added user to authenticate, got %+v %v", identity, err)
+		}
+		if _, err := identify("ci-access-key", "ci-secret-key"); err != ErrInvalidAccessKey {
+			t.Errorf("Expected the removed user to be rejected, got %v", err)
+		}
+
+		// A broken file keeps the previous users
+		writeUsers("users: [", time.Now().Add(time.Minute))
+		if _, err := identify("deploy-access-key", "deploy-secret-key"); err != nil {
+			t.Errorf("Expected the previous users to be kept, got %v", err)
+		}
+	})
+
+	t.Run("ReservedNames", func(t *testing.T) {
+		writeUsers(`
+users:
+  - name: deploy-role
+    access_key: role-access-key
+    secret_key: role-secret-key
+  - name: deploy
+    access_key: deploy-access-key
+    secret_key: deploy-secret-key
+`, time.Now().Add(2*time.Minute))
+		if _, err := identify("role-access-key", "role-secret-key"); err != ErrInvalidAccessKey {
+			t.Errorf("Expected a user named like a role to be rejected, got %v", err)
+		}
+		if _, err := identify("deploy-access-key", "deploy-secret-key"); err != nil {
+			t.Errorf("Expected the other users to be loaded, got %v", err)
+		}
+
+		writeUsers(`
+users:
+  - name: root
+    access_key: fake-root-access-key
+    secret_key: fake-root-secret-key
+`, time.Now().Add(3*time.Minute))
+		if _, err := identify("fake-root-access-key", "fake-root-secret-key"); err != ErrInvalidAccessKey {
+			t.Errorf("Expected a user named root to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("IdentityInContext", func(t *testing.T) {
+		var got *Identity
+		handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = IdentityFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "http://localhost:9000/bucket/object", nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials("alice-access-key", "alice-secret-key", ""))
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", time.Hour, signedAt); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", req.URL.String(), nil))
+
+		if got == nil || got.Name != "alice" {
+			t.Errorf("Expected alice in the request context, got %+v", got)
+		}
+	})
+}
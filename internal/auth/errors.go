@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+)
+
+var (
+	ErrMissingAuthHeader     = errors.New("missing authorization header")
+	ErrUnsupportedAuthMethod = errors.New("unsupported authorization method")
+	ErrMalformedAuthHeader   = errors.New("invalid authorization header format")
+	ErrMissingAuthComponents = errors.New("missing required authorization components")
+	ErrInvalidCredential     = errors.New("invalid credential format")
+	ErrInvalidAccessKey      = errors.New("invalid access key")
+	ErrSignatureMismatch     = errors.New("signature mismatch")
+
+	ErrMissingDate            = errors.New("missing or invalid X-Amz-Date or Date header")
+	ErrCredentialDateMismatch = errors.New("credential scope date does not match the request date")
+	ErrRequestTimeTooSkewed   = errors.New("request time is too far from the server time")
+
+	ErrMissingPresignParams = errors.New("missing required presigned URL query parameters")
+	ErrInvalidPresignDate   = errors.New("invalid X-Amz-Date in presigned URL")
+	ErrInvalidPresignExpiry = errors.New("X-Amz-Expires must be between 1 and 604800 seconds")
+	ErrPresignExpired       = errors.New("presigned URL has expired")
+	ErrPresignNotYetValid   = errors.New("presigned URL is not valid yet")
+	ErrInvalidExpires       = errors.New("invalid Expires in presigned URL")
+
+	ErrSignatureV2Disabled = errors.New("signature version 2 is disabled")
+
+	ErrMissingDecodedLength = errors.New("missing or invalid x-amz-decoded-content-length")
+	ErrUnsupportedTrailer   = errors.New("unsupported x-amz-trailer")
+
+	ErrPolicyDenied = errors.New("access denied by bucket policy")
+
+	ErrInvalidSessionToken = errors.New("invalid session token")
+	ErrExpiredSessionToken = errors.New("session token has expired")
+	ErrSessionChaining     = errors.New("temporary credentials cannot request further sessions")
+	ErrRequestBodyTooLarge = errors.New("signed request body is too large")
+	ErrSessionsDisabled    = errors.New("temporary credentials are disabled: no session key is set")
+)
+
+// authErrors maps authentication failures to the S3 errors they surface as.
+var authErrors = map[error]s3err.APIError{
+	ErrMissingAuthHeader:     s3err.ErrMissingSecurityHeader,
+	ErrUnsupportedAuthMethod: s3err.ErrInvalidArgument,
+	ErrMalformedAuthHeader:   s3err.ErrAuthorizationHeaderMalformed,
+	ErrMissingAuthComponents: s3err.ErrAuthorizationHeaderMalformed,
+	ErrInvalidCredential:     s3err.ErrAuthorizationHeaderMalformed,
+	ErrInvalidAccessKey:      s3err.ErrInvalidAccessKeyID,
+	ErrSignatureMismatch:     s3err.ErrSignatureDoesNotMatch,
+
+	ErrMissingDate:            s3err.ErrMissingDateHeader,
+	ErrCredentialDateMismatch: s3err.ErrAuthorizationHeaderMalformed,
+	ErrRequestTimeTooSkewed:   s3err.ErrRequestTimeTooSkewed,
+
+	ErrMissingPresignParams: s3err.ErrAuthorizationQueryParametersError,
+	ErrInvalidPresignDate:   s3err.ErrAuthorizationQueryParametersError,
+	ErrInvalidPresignExpiry: s3err.ErrAuthorizationQueryParametersError,
+	ErrPresignExpired:       s3err.ErrExpiredPresignRequest,
+	ErrPresignNotYetValid:   s3err.ErrPresignNotYetValid,
+	ErrInvalidExpires:       s3err.ErrAuthorizationQueryParametersError,
+	ErrSignatureV2Disabled:  s3err.ErrUnsupportedSignatureVersion,
+	ErrMissingDecodedLength: s3err.ErrMissingContentLength,
+	ErrUnsupportedTrailer:   s3err.ErrNotImplemented,
+	ErrPolicyDenied:         s3err.ErrAccessDenied,
+	ErrInvalidSessionToken:  s3err.ErrInvalidToken,
+	ErrExpiredSessionToken:  s3err.ErrExpiredToken,
+	ErrSessionChaining:      s3err.ErrAccessDenied,
+	ErrRequestBodyTooLarge:  s3err.ErrInvalidArgument,
+	ErrSessionsDisabled:     s3err.ErrInvalidToken,
+}
+
+// apiErrorFor returns the S3 error for an authentication failure. Anything
+// unrecognised is reported as AccessDenied.
+func apiErrorFor(err error) s3err.APIError {
+	for target, apiErr := range authErrors {
+		if errors.Is(err, target) {
+			return apiErr
+		}
+	}
+	return s3err.ErrAccessDenied
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/policy"
+)
+
+// sessionAccessKeyPrefix starts the access keys of temporary credentials,
+// as it does on AWS.
+const sessionAccessKeyPrefix = "ASIA"
+
+// SessionCredentials are temporary credentials issued by IssueSession.
+type SessionCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expiration   time.Time
+}
+
+// sessionClaims is the signed content of a session token. Sessions are not
+// stored: the token carries everything needed to validate them, and the
+// secret key is derived from the access key. Sessions act either as the
+// user of ParentAccessKey or, for web identities, as Role.
+type sessionClaims struct {
+	AccessKey       string `json:"ak"`
+	ParentAccessKey string `json:"pak,omitempty"`
+	Role            string `json:"role,omitempty"`
+	Expiration      int64  `json:"exp"`
+	Policy          string `json:"pol,omitempty"`
+}
+
+// IssueSession returns temporary credentials acting as parent for duration.
+// A non-empty sessionPolicy, which must be valid for
+// policy.ParseSessionPolicy, further limits what they may do.
+func (a *Authenticator) IssueSession(parent *Identity, duration time.Duration, sessionPolicy string) (*SessionCredentials, error) {
+	if !parent.Expiration.IsZero() {
+		return nil, ErrSessionChaining
+	}
+	return a.issueSession(sessionClaims{ParentAccessKey: parent.AccessKey}, duration, sessionPolicy)
+}
+
+// IssueWebIdentitySession returns temporary credentials for a web identity
+// that assumed role. They are limited by the role's policy, as it is
+// configured when they are used, and by sessionPolicy if it is not empty.
+func (a *Authenticator) IssueWebIdentitySession(role *config.OIDCRoleConfig, duration time.Duration, sessionPolicy string) (*SessionCredentials, error) {
+	return a.issueSession(sessionClaims{Role: role.Name}, duration, sessionPolicy)
+}
+
+func (a *Authenticator) issueSession(claims sessionClaims, duration time.Duration, sessionPolicy string) (*SessionCredentials, error) {
+	if a.config.Auth.SessionKey == "" {
+		return nil, ErrSessionsDisabled
+	}
+
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	claims.AccessKey = sessionAccessKeyPrefix + base32.StdEncoding.EncodeToString(random)
+	claims.Expiration = a.now().Add(duration).Unix()
+	claims.Policy = sessionPolicy
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return &SessionCredentials{
+		AccessKey:    claims.AccessKey,
+		SecretKey:    a.sessionSecret(claims.AccessKey),
+		SessionToken: encoded + "." + a.sessionMAC("token", encoded),
+		Expiration:   time.Unix(claims.Expiration, 0).UTC(),
+	}, nil
+}
+
+// lookupSession returns the credential of a temporary access key from its
+// session token. Sessions end when they expire or when the key pair of the
+// user or the web identity role they were issued to is removed.
+func (a *Authenticator) lookupSession(accessKey, token string) (credential, error) {
+	if a.config.Auth.SessionKey == "" {
+		log.Printf("DEBUG: Rejecting session token for %s: no session key is set\n", accessKey)
+		return credential{}, ErrSessionsDisabled
+	}
+
+	encoded, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(a.sessionMAC("token", encoded))) {
+		log.Printf("DEBUG: Invalid session token for access key %s\n", accessKey)
+		return credential{}, ErrInvalidSessionToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return credential{}, ErrInvalidSessionToken
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.AccessKey != accessKey {
+		return credential{}, ErrInvalidSessionToken
+	}
+
+	expiration := time.Unix(claims.Expiration, 0).UTC()
+	if !a.now().Before(expiration) {
+		log.Printf("DEBUG: Session %s expired at %s\n", accessKey, expiration)
+		return credential{}, ErrExpiredSessionToken
+	}
+
+	identity := Identity{AccessKey: accessKey, Expiration: expiration}
+	policies := []string{claims.Policy}
+	if claims.Role != "" {
+		role, ok := a.config.Auth.OIDC.Role(claims.Role)
+		if !ok {
+			log.Printf("DEBUG: Session %s belongs to unknown role %s\n", accessKey, claims.Role)
+			return credential{}, ErrInvalidSessionToken
+		}
+		identity.Name = role.Name
+		policies = append(policies, role.Policy)
+	} else {
+		parent, ok := a.credentials.lookup(claims.ParentAccessKey)
+		if !ok || !parent.identity.Expiration.IsZero() {
+			log.Printf("DEBUG: Session %s belongs to unknown access key %s\n", accessKey, claims.ParentAccessKey)
+			return credential{}, ErrInvalidSessionToken
+		}
+		identity.Name = parent.identity.Name
+		identity.Root = parent.identity.Root
+	}
+
+	for _, doc := range policies {
+		if doc == "" {
+			continue
+		}
+		p, err := policy.ParseSessionPolicy([]byte(doc))
+		if err != nil {
+			log.Printf("ERROR: Invalid session policy for %s: %v\n", accessKey, err)
+			return credential{}, ErrInvalidSessionToken
+		}
+		identity.Policies = append(identity.Policies, p)
+	}
+
+	return credential{identity: identity, secretKey: a.sessionSecret(accessKey)}, nil
+}
+
+// sessionSecret derives the secret key of a temporary access key.
+func (a *Authenticator) sessionSecret(accessKey string) string {
+	return a.sessionMAC("secret", accessKey)
+}
+
+// sessionMAC authenticates data for purpose with the session key, so
+// changing the key revokes every session.
+func (a *Authenticator) sessionMAC(purpose, data string) string {
+	mac := hmac.New(sha256.New, []byte(a.config.Auth.SessionKey))
+	mac.Write([]byte(purpose + "\n" + data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// LoadSessionKey returns the session key stored at path, creating a random
+// one there first if the file does not exist.
+func LoadSessionKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if len(key) < config.MinSessionKeyLength {
+			return "", fmt.Errorf("session key in %s is shorter than %d characters", path, config.MinSessionKeyLength)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(random)
+
+	// O_EXCL keeps a concurrently created key rather than replacing it
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return LoadSessionKey(path)
+		}
+		return "", err
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	log.Printf("INFO: Created session key %s", path)
+	return key, nil
+}
+
+// securityToken returns the session token r was signed with, if any.
+func securityToken(r *http.Request) string {
+	if token := r.Header.Get("X-Amz-Security-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("X-Amz-Security-Token")
+}
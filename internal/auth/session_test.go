@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const testSessionKey = "0123456789abcdef0123456789abcdef"
+
+func TestSessionCredentials(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey:  "root-access-key",
+			SecretKey:  "root-secret-key",
+			SessionKey: testSessionKey,
+			Users: []config.UserConfig{
+				{Name: "ci", AccessKey: "ci-access-key", SecretKey: "ci-secret-key"},
+			},
+		},
+	}
+	auth := New(cfg)
+	issuedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return issuedAt }
+
+	parent := &Identity{Name: "ci", AccessKey: "ci-access-key"}
+	session, err := auth.IssueSession(parent, time.Hour, "")
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+	if !strings.HasPrefix(session.AccessKey, sessionAccessKeyPrefix) {
+		t.Errorf("Expected access key starting with %s, got %s", sessionAccessKeyPrefix, session.AccessKey)
+	}
+	if want := issuedAt.Add(time.Hour); !session.Expiration.Equal(want) {
+		t.Errorf("Expected expiration %s, got %s", want, session.Expiration)
+	}
+
+	signed := func(method, path, secretKey, token string) *http.Request {
+		t.Helper()
+		req := httptest.NewRequest(method, "http://localhost:9000"+path, nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials(session.AccessKey, secretKey, token))
+		if _, err := signer.Sign(req, nil, "s3", "us-east-1", auth.now()); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		return req
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		identity, err := auth.Identify(signed("GET", "/data/file", session.SecretKey, session.SessionToken))
+		if err != nil {
+			t.Fatalf("Expected session credentials to authenticate, got %v", err)
+		}
+		if identity.Name != "ci" || identity.AccessKey != session.AccessKey {
+			t.Errorf("Expected user ci with key %s, got %s with key %s", session.AccessKey, identity.Name, identity.AccessKey)
+		}
+		if !identity.Expiration.Equal(session.Expiration) {
+			t.Errorf("Expected identity expiring at %s, got %s", session.Expiration, identity.Expiration)
+		}
+	})
+
+	t.Run("Presigned", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost:9000/data/file", nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials(session.AccessKey, session.SecretKey, session.SessionToken))
+		if _, err := signer.Presign(req, nil, "s3", "us-east-1", 15*time.Minute, auth.now()); err != nil {
+			t.Fatalf("Presign failed: %v", err)
+		}
+		if _, err := auth.Identify(httptest.NewRequest("GET", req.URL.String(), nil)); err != nil {
+			t.Errorf("Expected presigned session URL to authenticate, got %v", err)
+		}
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		if _, err := auth.Identify(signed("GET", "/data/file", session.SecretKey, "")); !errors.Is(err, ErrInvalidAccessKey) {
+			t.Errorf("Expected ErrInvalidAccessKey, got %v", err)
+		}
+	})
+
+	t.Run("TamperedToken", func(t *testing.T) {
+		token := session.SessionToken[:10] + "x" + session.SessionToken[11:]
+		if token == session.SessionToken {
+			token = session.SessionToken[:10] + "y" + session.SessionToken[11:]
+		}
+		if _, err := auth.Identify(signed("GET", "/data/file", session.SecretKey, token)); !errors.Is(err, ErrInvalidSessionToken) {
+			t.Errorf("Expected ErrInvalidSessionToken, got %v", err)
+		}
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		if _, err := auth.Identify(signed("GET", "/data/file", "guessed-secret", session.SessionToken)); !errors.Is(err, ErrSignatureMismatch) {
+			t.Errorf("Expected ErrSignatureMismatch, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		auth.now = func() time.Time { return issuedAt.Add(2 * time.Hour) }
+		defer func() { auth.now = func() time.Time { return issuedAt } }()
+
+		_, err := auth.Identify(signed("GET", "/data/file", session.SecretKey, session.SessionToken))
+		if !errors.Is(err, ErrExpiredSessionToken) {
+			t.Fatalf("Expected ErrExpiredSessionToken, got %v", err)
+		}
+		if apiErr := apiErrorFor(err); apiErr.Code != "ExpiredToken" {
+			t.Errorf("Expected ExpiredToken, got %s", apiErr.Code)
+		}
+	})
+
+	t.Run("NoChaining", func(t *testing.T) {
+		identity, err := auth.Identify(signed("GET", "/", session.SecretKey, session.SessionToken))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.IssueSession(identity, time.Hour, ""); !errors.Is(err, ErrSessionChaining) {
+			t.Errorf("Expected ErrSessionChaining, got %v", err)
+		}
+	})
+
+	t.Run("SignedFormBody", func(t *testing.T) {
+		// STS clients sign the hash of their body without sending it
+		body := "Action=GetSessionToken&Version=2011-06-15"
+		req := httptest.NewRequest("POST", "http://localhost:9000/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		signer := v4.NewSigner(credentials.NewStaticCredentials("ci-access-key", "ci-secret-key", ""))
+		if _, err := signer.Sign(req, strings.NewReader(body), "sts", "us-east-1", auth.now()); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		req.Header.Del("X-Amz-Content-Sha256")
+
+		if _, err := auth.Identify(req); err != nil {
+			t.Fatalf("Expected signed STS request to authenticate, got %v", err)
+		}
+		if err := req.ParseForm(); err != nil || req.Form.Get("Action") != "GetSessionToken" {
+			t.Errorf("Expected the body to remain readable, got %q (%v)", req.Form.Get("Action"), err)
+		}
+	})
+}
+
+func TestSessionPolicy(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey:  "root-access-key",
+			SecretKey:  "root-secret-key",
+			SessionKey: testSessionKey,
+		},
+	}
+	auth := New(cfg)
+
+	root := &Identity{Name: RootUser, AccessKey: "root-access-key", Root: true}
+	session, err := auth.IssueSession(root, time.Hour, `{
+		"Statement": [{"Effect": "Allow", "Action": ["s3:GetObject", "s3:ListBucket"], "Resource": ["arn:aws:s3:::data", "arn:aws:s3:::data/*"]}]
+	}`)
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+
+	middleware := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name, method, path string
+		want               int
+	}{
+		{"ReadAllowed", "GET", "/data/file", http.StatusOK},
+		{"ListAllowed", "GET", "/data", http.StatusOK},
+		{"WriteDenied", "PUT", "/data/file", http.StatusForbidden},
+		{"OtherBucketDenied", "GET", "/private/file", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://localhost:9000"+tt.path, nil)
+			signer := v4.NewSigner(credentials.NewStaticCredentials(session.AccessKey, session.SecretKey, session.SessionToken))
+			if _, err := signer.Sign(req, nil, "s3", "us-east-1", time.Now()); err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestWebIdentitySession(t *testing.T) {
+	role := config.OIDCRoleConfig{
+		Name:   "deploy",
+		Claims: map[string]string{"sub": "repo:acme/*"},
+		Policy: `{"Statement": [{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::releases/*"}]}`,
+	}
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey:  "root-access-key",
+			SecretKey:  "root-secret-key",
+			SessionKey: testSessionKey,
+			OIDC:       config.OIDCConfig{Issuer: "https://issuer.example.com", Roles: []config.OIDCRoleConfig{role}},
+		},
+	}
+	auth := New(cfg)
+
+	session, err := auth.IssueWebIdentitySession(&role, time.Hour, "")
+	if err != nil {
+		t.Fatalf("IssueWebIdentitySession failed: %v", err)
+	}
+	identify := func() (*Identity, error) {
+		req := httptest.NewRequest("PUT", "http://localhost:9000/releases/app.tar.gz", nil)
+		signer := v4.NewSigner(credentials.NewStaticCredentials(session.AccessKey, session.SecretKey, session.SessionToken))
+		if _, err := signer.Sign(req, nil, "s3", "us-east-1", time.Now()); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		return auth.Identify(req)
+	}
+
+	identity, err := identify()
+	if err != nil {
+		t.Fatalf("Expected web identity session to authenticate, got %v", err)
+	}
+	if identity.Name != "deploy" || len(identity.Policies) != 1 {
+		t.Errorf("Expected role deploy with its policy, got %s with %d policies", identity.Name, len(identity.Policies))
+	}
+
+	// Removing the role ends its sessions
+	cfg.Auth.OIDC.Roles = nil
+	if _, err := identify(); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Errorf("Expected ErrInvalidSessionToken, got %v", err)
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	t.Run("NoKey", func(t *testing.T) {
+		// Without a session key, tokens built from public values must not
+		// be accepted
+		cfg := &config.Config{
+			Auth: config.AuthConfig{
+				Users: []config.UserConfig{{Name: "alice", AccessKey: "AKALICE", SecretKey: "alice-secret"}},
+			},
+		}
+		auth := New(cfg)
+
+		if _, err := auth.IssueSession(&Identity{Name: "alice", AccessKey: "AKALICE"}, time.Hour, ""); !errors.Is(err, ErrSessionsDisabled) {
+			t.Errorf("Expected ErrSessionsDisabled, got %v", err)
+		}
+
+		claims := base64.RawURLEncoding.EncodeToString([]byte(`{"ak":"ASIAFORGED","pak":"AKALICE","exp":4102444800}`))
+		token := claims + "." + auth.sessionMAC("token", claims)
+		if _, err := auth.lookupSession("ASIAFORGED", token); !errors.Is(err, ErrSessionsDisabled) {
+			t.Errorf("Expected forged token to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "porter-session-key-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		path := filepath.Join(tmpDir, "session-key")
+		key, err := LoadSessionKey(path)
+		if err != nil {
+			t.Fatalf("LoadSessionKey failed: %v", err)
+		}
+		if len(key) < config.MinSessionKeyLength {
+			t.Errorf("Expected a key of at least %d characters, got %q", config.MinSessionKeyLength, key)
+		}
+		if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0600 {
+			t.Errorf("Expected key file with mode 0600, got %v (%v)", stat.Mode(), err)
+		}
+
+		again, err := LoadSessionKey(path)
+		if err != nil || again != key {
+			t.Errorf("Expected the stored key %q to be reused, got %q (%v)", key, again, err)
+		}
+	})
+}
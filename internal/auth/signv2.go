@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signV2SubResources are the query parameters that are part of the
+// resource signed with signature version 2. All other parameters are left
+// out of the string to sign.
+var signV2SubResources = map[string]bool{
+	"acl":                          true,
+	"cors":                         true,
+	"delete":                       true,
+	"encryption":                   true,
+	"lifecycle":                    true,
+	"location":                     true,
+	"logging":                      true,
+	"notification":                 true,
+	"partNumber":                   true,
+	"policy":                       true,
+	"requestPayment":               true,
+	"response-cache-control":       true,
+	"response-content-disposition": true,
+	"response-content-encoding":    true,
+	"response-content-language":    true,
+	"response-content-type":        true,
+	"response-expires":             true,
+	"restore":                      true,
+	"tagging":                      true,
+	"torrent":                      true,
+	"uploadId":                     true,
+	"uploads":                      true,
+	"versionId":                    true,
+	"versioning":                   true,
+	"versions":                     true,
+	"website":                      true,
+}
+
+// validateV2Signature authenticates a request signed with the legacy
+// "AWS AccessKeyId:Signature" Authorization header.
+func (a *Authenticator) validateV2Signature(r *http.Request, authHeader string) (*Identity, error) {
+	accessKey, signature, ok := strings.Cut(strings.TrimPrefix(authHeader, signV2Algorithm+" "), ":")
+	if !ok || accessKey == "" || signature == "" {
+		log.Printf("DEBUG: Invalid signature V2 authorization header\n")
+		return nil, ErrMalformedAuthHeader
+	}
+
+	cred, err := a.lookupCredential(r, accessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// x-amz-date takes the place of Date and is signed with the other
+	// x-amz-* headers
+	date := r.Header.Get("Date")
+	if r.Header.Get("X-Amz-Date") != "" {
+		date = ""
+	}
+
+	if !checkSignatureV2(cred.secretKey, stringToSignV2(r, date), signature) {
+		return nil, ErrSignatureMismatch
+	}
+
+	signedAt, err := requestTime(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkClockSkew(signedAt); err != nil {
+		return nil, err
+	}
+
+	log.Printf("DEBUG: Authentication successful\n")
+	return &cred.identity, nil
+}
+
+// validatePresignedV2 authenticates a request signed with the legacy
+// AWSAccessKeyId, Expires and Signature query parameters.
+func (a *Authenticator) validatePresignedV2(r *http.Request) (*Identity, error) {
+	query := r.URL.Query()
+	accessKey := query.Get("AWSAccessKeyId")
+	expiresStr := query.Get("Expires")
+	signature := query.Get("Signature")
+	if accessKey == "" || expiresStr == "" || signature == "" {
+		log.Printf("DEBUG: Missing required presigned URL parameters\n")
+		return nil, ErrMissingPresignParams
+	}
+
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidExpires
+	}
+	if a.now().After(time.Unix(expires, 0)) {
+		log.Printf("DEBUG: Presigned URL expired at %s\n", time.Unix(expires, 0))
+		return nil, ErrPresignExpired
+	}
+
+	cred, err := a.lookupCredential(r, accessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !checkSignatureV2(cred.secretKey, stringToSignV2(r, expiresStr), signature) {
+		return nil, ErrSignatureMismatch
+	}
+
+	log.Printf("DEBUG: Authentication successful\n")
+	return &cred.identity, nil
+}
+
+func checkSignatureV2(secretKey, stringToSign, signature string) bool {
+	log.Printf("DEBUG: String to sign:\n%s", stringToSign)
+
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	mac.Write([]byte(stringToSign))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		log.Printf("DEBUG: Signature mismatch. Expected: %s, Got: %s\n", expected, signature)
+		return false
+	}
+	return true
+}
+
+// stringToSignV2 returns the signature version 2 string to sign of r. date
+// is the Date header, or Expires for presigned URLs.
+func stringToSignV2(r *http.Request, date string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(r.Header.Get("Content-MD5") + "\n")
+	b.WriteString(r.Header.Get("Content-Type") + "\n")
+	b.WriteString(date + "\n")
+
+	var amzHeaders []string
+	for name := range r.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			amzHeaders = append(amzHeaders, lower)
+		}
+	}
+	sort.Strings(amzHeaders)
+	for _, name := range amzHeaders {
+		var values []string
+		for _, value := range r.Header.Values(name) {
+			values = append(values, strings.TrimSpace(value))
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	b.WriteString(r.URL.EscapedPath())
+
+	query := r.URL.Query()
+	var subResources []string
+	for name := range query {
+		if signV2SubResources[name] {
+			subResources = append(subResources, name)
+		}
+	}
+	sort.Strings(subResources)
+	for i, name := range subResources {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(name)
+		if value := query.Get(name); value != "" {
+			b.WriteString("=" + value)
+		}
+	}
+
+	return b.String()
+}
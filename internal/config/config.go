@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/alexerm/porterfs/internal/policy"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,15 +29,93 @@ type TLSConfig struct {
 }
 
 type StorageConfig struct {
-	RootPath string `yaml:"root_path"`
-	MaxSize  int64  `yaml:"max_size_bytes"`
+	RootPath  string          `yaml:"root_path"`
+	MaxSize   int64           `yaml:"max_size_bytes"`
+	Multipart MultipartConfig `yaml:"multipart"`
 }
 
+// MultipartConfig controls the janitor that aborts abandoned multipart
+// uploads. Uploads older than Expiry are aborted on every CleanupInterval;
+// a zero Expiry leaves only the buckets' lifecycle rules in effect.
+type MultipartConfig struct {
+	Expiry          time.Duration `yaml:"expiry"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// AuthConfig lists the credentials PorterFS accepts. AccessKey and
+// SecretKey are the root credentials; Users and the users in UsersFile add
+// further identities. UsersFile is re-read whenever it changes.
+// SignatureV2 additionally accepts the legacy AWS Signature Version 2 for
+// clients that cannot sign with Version 4. SessionKey signs the tokens of
+// temporary credentials; without one they cannot be issued or used. OIDC
+// lets holders of tokens from an OpenID Connect provider obtain temporary
+// credentials.
 type AuthConfig struct {
+	AccessKey   string       `yaml:"access_key"`
+	SecretKey   string       `yaml:"secret_key"`
+	Users       []UserConfig `yaml:"users"`
+	UsersFile   string       `yaml:"users_file"`
+	SignatureV2 bool         `yaml:"signature_v2"`
+	SessionKey  string       `yaml:"session_key"`
+	OIDC        OIDCConfig   `yaml:"oidc"`
+}
+
+// UserConfig is a named identity and its access key pair.
+type UserConfig struct {
+	Name      string `yaml:"name"`
 	AccessKey string `yaml:"access_key"`
 	SecretKey string `yaml:"secret_key"`
 }
 
+// OIDCConfig enables AssumeRoleWithWebIdentity for the ID tokens of one
+// OpenID Connect provider. Tokens must be issued by Issuer for Audience and
+// signed by a key in the JWKS read from JWKSFile or fetched from JWKSURL.
+// OIDC is disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer   string           `yaml:"issuer"`
+	Audience string           `yaml:"audience"`
+	JWKSFile string           `yaml:"jwks_file"`
+	JWKSURL  string           `yaml:"jwks_url"`
+	Roles    []OIDCRoleConfig `yaml:"roles"`
+}
+
+// OIDCRoleConfig is a role web identities may assume. A token qualifies if
+// each claim named in Claims matches its pattern, in which * and ? are
+// wildcards. Sessions of the role act as a user with the role's Name and
+// may do no more than its session Policy allows.
+type OIDCRoleConfig struct {
+	Name   string            `yaml:"name"`
+	Claims map[string]string `yaml:"claims"`
+	Policy string            `yaml:"policy"`
+}
+
+// Enabled reports whether an OpenID Connect provider is configured.
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// Role returns the role called name.
+func (c OIDCConfig) Role(name string) (*OIDCRoleConfig, bool) {
+	for i := range c.Roles {
+		if c.Roles[i].Name == name {
+			return &c.Roles[i], true
+		}
+	}
+	return nil, false
+}
+
+// MinSessionKeyLength is the shortest AuthConfig.SessionKey accepted.
+const MinSessionKeyLength = 32
+
+// RootUser is the name of the root credentials, which no other user or role
+// may take.
+const RootUser = "root"
+
+// UsersFile is the format of AuthConfig.UsersFile.
+type UsersFile struct {
+	Users []UserConfig `yaml:"users"`
+}
+
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
@@ -57,6 +138,43 @@ func Load(filename string) (*Config, error) {
 	return &cfg, nil
 }
 
+// LoadUsers reads and validates a users file.
+func LoadUsers(filename string) ([]UserConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file UsersFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if err := validateUsers(file.Users); err != nil {
+		return nil, err
+	}
+
+	return file.Users, nil
+}
+
+// validateUsers requires every user to have a name other than RootUser and
+// a key pair, and access keys to be unique.
+func validateUsers(users []UserConfig) error {
+	seen := make(map[string]bool)
+	for i, user := range users {
+		if user.Name == "" || user.AccessKey == "" || user.SecretKey == "" {
+			return fmt.Errorf("user %d: name, access_key and secret_key are required", i+1)
+		}
+		if user.Name == RootUser {
+			return fmt.Errorf("user %s: name is reserved for the root credentials", user.Name)
+		}
+		if seen[user.AccessKey] {
+			return fmt.Errorf("user %s: duplicate access_key", user.Name)
+		}
+		seen[user.AccessKey] = true
+	}
+	return nil
+}
+
 func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
@@ -68,6 +186,10 @@ func DefaultConfig() *Config {
 		Storage: StorageConfig{
 			RootPath: "./data",
 			MaxSize:  100 * 1024 * 1024 * 1024, // 100GB
+			Multipart: MultipartConfig{
+				Expiry:          7 * 24 * time.Hour,
+				CleanupInterval: time.Hour,
+			},
 		},
 		Auth: AuthConfig{
 			AccessKey: "porterfs",
@@ -85,6 +207,27 @@ func (c *Config) Validate() error {
 		c.Storage.RootPath = "./data"
 	}
 
+	if c.Storage.Multipart.CleanupInterval <= 0 {
+		c.Storage.Multipart.CleanupInterval = time.Hour
+	}
+
+	if err := validateUsers(c.Auth.Users); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	for _, user := range c.Auth.Users {
+		if user.AccessKey == c.Auth.AccessKey {
+			return fmt.Errorf("auth: user %s: access_key is already the root access key", user.Name)
+		}
+	}
+
+	if c.Auth.SessionKey != "" && len(c.Auth.SessionKey) < MinSessionKeyLength {
+		return fmt.Errorf("auth: session_key must be at least %d characters", MinSessionKeyLength)
+	}
+
+	if err := c.Auth.validateOIDC(); err != nil {
+		return fmt.Errorf("auth: oidc: %w", err)
+	}
+
 	if err := os.MkdirAll(c.Storage.RootPath, 0755); err != nil {
 		return err
 	}
@@ -97,3 +240,40 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validateOIDC requires an enabled provider to have an audience and exactly
+// one key source, and its roles to have unique names that are not also user
+// names and valid session policies.
+func (c *AuthConfig) validateOIDC() error {
+	if !c.OIDC.Enabled() {
+		return nil
+	}
+	if c.OIDC.Audience == "" {
+		return fmt.Errorf("audience is required")
+	}
+	if (c.OIDC.JWKSFile == "") == (c.OIDC.JWKSURL == "") {
+		return fmt.Errorf("exactly one of jwks_file and jwks_url is required")
+	}
+
+	names := map[string]bool{RootUser: true}
+	for _, user := range c.Users {
+		names[user.Name] = true
+	}
+	for i, role := range c.OIDC.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("role %d: name is required", i+1)
+		}
+		if names[role.Name] {
+			return fmt.Errorf("role %s: name is already used by a user or role", role.Name)
+		}
+		names[role.Name] = true
+
+		if len(role.Claims) == 0 {
+			return fmt.Errorf("role %s: at least one claim is required", role.Name)
+		}
+		if _, err := policy.ParseSessionPolicy([]byte(role.Policy)); err != nil {
+			return fmt.Errorf("role %s: %w", role.Name, err)
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -63,6 +64,9 @@ server:
 storage:
   root_path: "/custom/path"
   max_size_bytes: 1000000
+  multipart:
+    expiry: 48h
+    cleanup_interval: 10m
 
 auth:
   access_key: "custom-access"
@@ -95,6 +99,14 @@ logging:
 			t.Errorf("Expected root path '/custom/path', got '%s'", cfg.Storage.RootPath)
 		}
 
+		if cfg.Storage.Multipart.Expiry != 48*time.Hour {
+			t.Errorf("Expected multipart expiry 48h, got %v", cfg.Storage.Multipart.Expiry)
+		}
+
+		if cfg.Storage.Multipart.CleanupInterval != 10*time.Minute {
+			t.Errorf("Expected cleanup interval 10m, got %v", cfg.Storage.Multipart.CleanupInterval)
+		}
+
 		if cfg.Auth.AccessKey != "custom-access" {
 			t.Errorf("Expected access key 'custom-access', got '%s'", cfg.Auth.AccessKey)
 		}
@@ -151,3 +163,149 @@ func TestConfigValidate(t *testing.T) {
 		t.Error("Storage path was not made absolute during validation")
 	}
 }
+
+func TestAuthUsers(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-users-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Run("ConfigUsers", func(t *testing.T) {
+		configContent := `
+auth:
+  access_key: "root"
+  secret_key: "root-secret"
+  users_file: "/etc/porter/users.yaml"
+  users:
+    - name: alice
+      access_key: alice-key
+      secret_key: alice-secret
+`
+		configFile := filepath.Join(tmpDir, "users-config.yaml")
+		if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := Load(configFile)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if len(cfg.Auth.Users) != 1 || cfg.Auth.Users[0].Name != "alice" || cfg.Auth.Users[0].SecretKey != "alice-secret" {
+			t.Errorf("Expected user alice, got %+v", cfg.Auth.Users)
+		}
+		if cfg.Auth.UsersFile != "/etc/porter/users.yaml" {
+			t.Errorf("Expected users file '/etc/porter/users.yaml', got '%s'", cfg.Auth.UsersFile)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			users []UserConfig
+		}{
+			{"MissingSecret", []UserConfig{{Name: "a", AccessKey: "a-key"}}},
+			{"Duplicate", []UserConfig{{Name: "a", AccessKey: "key", SecretKey: "s"}, {Name: "b", AccessKey: "key", SecretKey: "s"}}},
+			{"RootKey", []UserConfig{{Name: "a", AccessKey: "root", SecretKey: "s"}}},
+			{"NamedRoot", []UserConfig{{Name: "root", AccessKey: "a-key", SecretKey: "s"}}},
+		}
+		for _, tt := range tests {
+			cfg := &Config{
+				Storage: StorageConfig{RootPath: filepath.Join(tmpDir, "data")},
+				Auth:    AuthConfig{AccessKey: "root", SecretKey: "root-secret", Users: tt.users},
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("%s: expected validation error", tt.name)
+			}
+		}
+	})
+
+	t.Run("LoadUsers", func(t *testing.T) {
+		usersFile := filepath.Join(tmpDir, "users.yaml")
+		content := `
+users:
+  - name: ci
+    access_key: ci-key
+    secret_key: ci-secret
+`
+		if err := os.WriteFile(usersFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		users, err := LoadUsers(usersFile)
+		if err != nil {
+			t.Fatalf("Failed to load users: %v", err)
+		}
+		if len(users) != 1 || users[0].AccessKey != "ci-key" {
+			t.Errorf("Expected user ci, got %+v", users)
+		}
+	})
+}
+
+func TestOIDCConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-oidc-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configContent := `
+auth:
+  access_key: "root"
+  secret_key: "root-secret"
+  oidc:
+    issuer: "https://token.actions.githubusercontent.com"
+    audience: "porterfs"
+    jwks_url: "https://token.actions.githubusercontent.com/.well-known/jwks"
+    roles:
+      - name: deploy
+        claims:
+          sub: "repo:acme/*:ref:refs/heads/main"
+        policy: |
+          {"Statement": [{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::releases/*"}]}
+`
+	configFile := filepath.Join(tmpDir, "oidc-config.yaml")
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg.Storage.RootPath = filepath.Join(tmpDir, "data")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	role, ok := cfg.Auth.OIDC.Role("deploy")
+	if !ok || role.Claims["sub"] != "repo:acme/*:ref:refs/heads/main" {
+		t.Errorf("Expected role deploy, got %+v", role)
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		validPolicy := `{"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::data/*"}]}`
+		tests := []struct {
+			name string
+			oidc OIDCConfig
+		}{
+			{"MissingAudience", OIDCConfig{Issuer: "https://issuer", JWKSFile: "jwks.json"}},
+			{"MissingKeys", OIDCConfig{Issuer: "https://issuer", Audience: "porterfs"}},
+			{"BothKeySources", OIDCConfig{Issuer: "https://issuer", Audience: "porterfs", JWKSFile: "jwks.json", JWKSURL: "https://issuer/jwks"}},
+			{"RoleWithoutClaims", OIDCConfig{Issuer: "https://issuer", Audience: "porterfs", JWKSFile: "jwks.json",
+				Roles: []OIDCRoleConfig{{Name: "ci", Policy: validPolicy}}}},
+			{"RoleNamedRoot", OIDCConfig{Issuer: "https://issuer", Audience: "porterfs", JWKSFile: "jwks.json",
+				Roles: []OIDCRoleConfig{{Name: "root", Claims: map[string]string{"sub": "x"}, Policy: validPolicy}}}},
+			{"InvalidPolicy", OIDCConfig{Issuer: "https://issuer", Audience: "porterfs", JWKSFile: "jwks.json",
+				Roles: []OIDCRoleConfig{{Name: "ci", Claims: map[string]string{"sub": "x"}, Policy: `{"Statement": []}`}}}},
+		}
+		for _, tt := range tests {
+			cfg := &Config{
+				Storage: StorageConfig{RootPath: filepath.Join(tmpDir, "data")},
+				Auth:    AuthConfig{AccessKey: "root", SecretKey: "root-secret", OIDC: tt.oidc},
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("%s: expected validation error", tt.name)
+			}
+		}
+	})
+}
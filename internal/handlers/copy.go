@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/auth"
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type CopyPartResult struct {
+	XMLName      xml.Name  `xml:"CopyPartResult"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// copySourceFromRequest parses the x-amz-copy-source header, which has the
+// form [/]bucket/key[?versionId=id] with the key URL-encoded, along with
+// the x-amz-copy-source-if-* conditions and the part copy range.
+func copySourceFromRequest(r *http.Request) (storage.CopySource, bool) {
+	header := r.Header.Get("x-amz-copy-source")
+	path, rawQuery, _ := strings.Cut(header, "?")
+
+	path, err := url.PathUnescape(path)
+	if err != nil {
+		return storage.CopySource{}, false
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return storage.CopySource{}, false
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return storage.CopySource{}, false
+	}
+
+	src := storage.CopySource{
+		Bucket:    bucket,
+		Key:       key,
+		VersionID: query.Get("versionId"),
+		Range:     r.Header.Get("x-amz-copy-source-range"),
+		Conditions: storage.CopyConditions{
+			IfMatch:     r.Header.Get("x-amz-copy-source-if-match"),
+			IfNoneMatch: r.Header.Get("x-amz-copy-source-if-none-match"),
+		},
+	}
+
+	// Unparseable dates are ignored, as in S3
+	if t, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-modified-since")); err == nil {
+		src.Conditions.IfModifiedSince = t
+	}
+	if t, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-unmodified-since")); err == nil {
+		src.Conditions.IfUnmodifiedSince = t
+	}
+
+	return src, true
+}
+
+// validCopySource is validObject for the copy source of r.
+func validCopySource(w http.ResponseWriter, r *http.Request) (storage.CopySource, bool) {
+	src, ok := copySourceFromRequest(r)
+	if !ok {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
+		return src, false
+	}
+	if !validObject(w, r, src.Bucket, src.Key) {
+		return src, false
+	}
+
+	// The source is not part of the path AuthMiddleware authorized
+	action := "s3:GetObject"
+	if src.VersionID != "" {
+		action = "s3:GetObjectVersion"
+	}
+	if !auth.Authorized(r.Context(), action, src.Bucket, src.Key) {
+		s3err.Write(w, r, s3err.ErrAccessDenied)
+		return src, false
+	}
+	return src, true
+}
+
+func (h *Handler) CopyObject(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	object := ObjectKey(r)
+
+	if !validObject(w, r, bucket, object) {
+		return
+	}
+
+	src, ok := validCopySource(w, r)
+	if !ok {
+		return
+	}
+
+	var meta *storage.ObjectMetadata
+	switch r.Header.Get("x-amz-metadata-directive") {
+	case "", "COPY":
+		if src.Bucket == bucket && src.Key == object && src.VersionID == "" {
+			s3err.Write(w, r, s3err.ErrCopyToItself)
+			return
+		}
+	case "REPLACE":
+		replaced := objectMetadataFromRequest(r)
+		meta = &replaced
+	default:
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
+		return
+	}
+
+	info, err := h.storage.CopyObject(r.Context(), src, bucket, object, meta)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if src.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", src.VersionID)
+	}
+	setVersionHeaders(w, info)
+
+	result := CopyObjectResult{
+		ETag:         quoteETag(info.ETag),
+		LastModified: info.LastModified,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) UploadPartCopy(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	object := ObjectKey(r)
+	uploadID := r.URL.Query().Get("uploadId")
+	partNumberStr := r.URL.Query().Get("partNumber")
+
+	if !validObject(w, r, bucket, object) {
+		return
+	}
+
+	if uploadID == "" || partNumberStr == "" {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
+		return
+	}
+
+	partNumber, err := strconv.Atoi(partNumberStr)
+	if err != nil {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
+		return
+	}
+
+	src, ok := validCopySource(w, r)
+	if !ok {
+		return
+	}
+
+	etag, err := h.storage.UploadPartCopy(r.Context(), src, bucket, object, uploadID, partNumber)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if src.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", src.VersionID)
+	}
+
+	result := CopyPartResult{
+		ETag:         quoteETag(etag),
+		LastModified: time.Now().UTC(),
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
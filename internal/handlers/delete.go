@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+
+	"github.com/alexerm/porterfs/internal/auth"
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxDeleteObjects is the S3 limit on keys per DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// maxDeleteBodySize bounds the request body: 1000 maximum-length keys with
+// version IDs and XML framing fit well within it.
+const maxDeleteBodySize = 2 << 20
+
+type DeleteObjectsRequest struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+type ObjectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+type DeleteObjectsResult struct {
+	XMLName xml.Name       `xml:"DeleteResult"`
+	Deleted []DeletedEntry `xml:"Deleted"`
+	Errors  []DeleteError  `xml:"Error"`
+}
+
+type DeletedEntry struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type DeleteError struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+func (h *Handler) DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	body, err := readPayload(r, maxDeleteBodySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body) > maxDeleteBodySize {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			s3err.Write(w, r, s3err.ErrInvalidDigest)
+			return
+		}
+		if sum := md5.Sum(body); string(sum[:]) != string(expected) {
+			s3err.Write(w, r, s3err.ErrBadDigest)
+			return
+		}
+	}
+
+	var req DeleteObjectsRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	// Keys are authorized one by one, as a bucket policy may grant
+	// deletion under some prefixes only
+	results := make([]storage.DeleteResult, len(req.Objects))
+	var objects []storage.ObjectIdentifier
+	var allowed []int
+	for i, object := range req.Objects {
+		action := "s3:DeleteObject"
+		if object.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		if !auth.Authorized(r.Context(), action, bucket, object.Key) {
+			results[i].Err = s3err.ErrAccessDenied
+			continue
+		}
+		objects = append(objects, storage.ObjectIdentifier{
+			Key:       object.Key,
+			VersionID: object.VersionID,
+		})
+		allowed = append(allowed, i)
+	}
+
+	if len(objects) > 0 {
+		deleted, err := h.storage.DeleteObjects(r.Context(), bucket, objects)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for i, res := range deleted {
+			results[allowed[i]] = res
+		}
+	}
+
+	var result DeleteObjectsResult
+	for i, res := range results {
+		object := req.Objects[i]
+		if res.Err != nil {
+			apiErr := apiErrorFor(res.Err)
+			result.Errors = append(result.Errors, DeleteError{
+				Key:       object.Key,
+				VersionID: object.VersionID,
+				Code:      apiErr.Code,
+				Message:   apiErr.Message,
+			})
+			continue
+		}
+		if req.Quiet {
+			continue
+		}
+
+		entry := DeletedEntry{
+			Key:          object.Key,
+			VersionID:    object.VersionID,
+			DeleteMarker: res.Info.DeleteMarker,
+		}
+		if res.Info.DeleteMarker {
+			entry.DeleteMarkerVersionID = versionIDString(res.Info.VersionID)
+		}
+		result.Deleted = append(result.Deleted, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+)
+
+// storageErrors maps storage sentinel errors to the S3 errors they surface as.
+var storageErrors = map[error]s3err.APIError{
+	storage.ErrNotFound:          s3err.ErrNoSuchKey,
+	storage.ErrBucketNotFound:    s3err.ErrNoSuchBucket,
+	storage.ErrBucketNotEmpty:    s3err.ErrBucketNotEmpty,
+	storage.ErrInvalidBucketName: s3err.ErrInvalidBucketName,
+	storage.ErrInvalidObjectName: s3err.ErrInvalidObjectName,
+	storage.ErrKeyTooLong:        s3err.ErrKeyTooLong,
+	storage.ErrInvalidRange:      s3err.ErrInvalidRange,
+	storage.ErrIncompleteBody:    s3err.ErrIncompleteBody,
+	storage.ErrInvalidDigest:     s3err.ErrInvalidDigest,
+	storage.ErrBadDigest:         s3err.ErrBadDigest,
+	storage.ErrNoSuchUpload:      s3err.ErrNoSuchUpload,
+
+	storage.ErrNoSuchVersion:           s3err.ErrNoSuchVersion,
+	storage.ErrInvalidVersionID:        s3err.ErrInvalidVersionID,
+	storage.ErrDeleteMarker:            s3err.ErrMethodNotAllowed,
+	storage.ErrInvalidVersioningStatus: s3err.ErrIllegalVersioningConfiguration,
+	storage.ErrPreconditionFailed:      s3err.ErrPreconditionFailed,
+
+	storage.ErrNoSuchLifecycleConfiguration: s3err.ErrNoSuchLifecycleConfiguration,
+	storage.ErrInvalidLifecycleRule:         s3err.ErrInvalidArgument,
+
+	storage.ErrNoSuchBucketPolicy: s3err.ErrNoSuchBucketPolicy,
+	storage.ErrMalformedPolicy:    s3err.ErrMalformedPolicy,
+
+	storage.ErrInvalidPartNumber: s3err.ErrInvalidPartNumber,
+	storage.ErrInvalidPart:       s3err.ErrInvalidPart,
+	storage.ErrInvalidPartOrder:  s3err.ErrInvalidPartOrder,
+	storage.ErrEntityTooSmall:    s3err.ErrEntityTooSmall,
+}
+
+// apiErrorFor returns the S3 error for err, falling back to InternalError.
+func apiErrorFor(err error) s3err.APIError {
+	var apiErr s3err.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for target, apiErr := range storageErrors {
+		if errors.Is(err, target) {
+			return apiErr
+		}
+	}
+	return s3err.ErrInternalError
+}
+
+// writeError renders err as an S3 XML error. Unexpected errors are logged
+// since the client only sees a generic InternalError.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := apiErrorFor(err)
+	if apiErr.StatusCode == http.StatusInternalServerError {
+		log.Printf("ERROR: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	s3err.Write(w, r, apiErr)
+}
+
+// validBucket writes an InvalidBucketName error and returns false if the
+// bucket name does not follow the S3 naming rules.
+func validBucket(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	if err := storage.ValidateBucketName(bucket); err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// validObject is validBucket for a bucket and object key pair.
+func validObject(w http.ResponseWriter, r *http.Request, bucket, key string) bool {
+	if !validBucket(w, r, bucket) {
+		return false
+	}
+	if err := storage.ValidateObjectKey(key); err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	return true
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/xml"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/s3err"
 	"github.com/alexerm/porterfs/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
@@ -45,15 +49,22 @@ type Bucket struct {
 }
 
 type ListObjectsV2Result struct {
-	XMLName               xml.Name `xml:"ListBucketResult"`
-	Name                  string   `xml:"Name"`
-	Prefix                string   `xml:"Prefix"`
-	KeyCount              int      `xml:"KeyCount"`
-	MaxKeys               int      `xml:"MaxKeys"`
-	IsTruncated           bool     `xml:"IsTruncated"`
-	ContinuationToken     string   `xml:"ContinuationToken,omitempty"`
-	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
-	Contents              []Object `xml:"Contents"`
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	Contents              []Object       `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
 }
 
 type Object struct {
@@ -67,7 +78,7 @@ type Object struct {
 func (h *Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
 	buckets, err := h.storage.ListBuckets(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -94,14 +105,13 @@ func (h *Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) CreateBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	if bucket == "" {
-		http.Error(w, "bucket name required", http.StatusBadRequest)
+	if !validBucket(w, r, bucket) {
 		return
 	}
 
 	err := h.storage.CreateBucket(r.Context(), bucket)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -110,14 +120,13 @@ func (h *Handler) CreateBucket(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	if bucket == "" {
-		http.Error(w, "bucket name required", http.StatusBadRequest)
+	if !validBucket(w, r, bucket) {
 		return
 	}
 
 	err := h.storage.DeleteBucket(r.Context(), bucket)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -126,8 +135,7 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	if bucket == "" {
-		http.Error(w, "bucket name required", http.StatusBadRequest)
+	if !validBucket(w, r, bucket) {
 		return
 	}
 
@@ -146,6 +154,19 @@ func (h *Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket s
 	prefix := query.Get("prefix")
 	delimiter := query.Get("delimiter")
 	maxKeysStr := query.Get("max-keys")
+	continuationToken := query.Get("continuation-token")
+	startAfter := query.Get("start-after")
+
+	// The continuation token takes precedence over start-after
+	cursor := startAfter
+	if continuationToken != "" {
+		decoded, err := decodeContinuationToken(continuationToken)
+		if err != nil {
+			s3err.Write(w, r, s3err.ErrInvalidArgument)
+			return
+		}
+		cursor = decoded
+	}
 
 	maxKeys := 1000
 	if maxKeysStr != "" {
@@ -154,26 +175,33 @@ func (h *Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket s
 		}
 	}
 
-	objects, isTruncated, err := h.storage.ListObjects(r.Context(), bucket, prefix, delimiter, maxKeys)
+	listing, err := h.storage.ListObjects(r.Context(), bucket, prefix, delimiter, cursor, maxKeys)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	result := ListObjectsV2Result{
-		Name:        bucket,
-		Prefix:      prefix,
-		KeyCount:    len(objects),
-		MaxKeys:     maxKeys,
-		IsTruncated: isTruncated,
-		Contents:    make([]Object, len(objects)),
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		KeyCount:          len(listing.Objects) + len(listing.CommonPrefixes),
+		MaxKeys:           maxKeys,
+		IsTruncated:       listing.IsTruncated,
+		ContinuationToken: continuationToken,
+		StartAfter:        startAfter,
+		Contents:          make([]Object, len(listing.Objects)),
+		CommonPrefixes:    commonPrefixes(listing.CommonPrefixes),
+	}
+	if listing.IsTruncated {
+		result.NextContinuationToken = encodeContinuationToken(listing.NextMarker)
 	}
 
-	for i, obj := range objects {
+	for i, obj := range listing.Objects {
 		result.Contents[i] = Object{
 			Key:          obj.Key,
 			LastModified: obj.LastModified,
-			ETag:         obj.ETag,
+			ETag:         quoteETag(obj.ETag),
 			Size:         obj.Size,
 			StorageClass: "STANDARD",
 		}
@@ -188,6 +216,7 @@ func (h *Handler) listObjectsV1(w http.ResponseWriter, r *http.Request, bucket s
 	prefix := query.Get("prefix")
 	delimiter := query.Get("delimiter")
 	maxKeysStr := query.Get("max-keys")
+	marker := query.Get("marker")
 
 	maxKeys := 1000
 	if maxKeysStr != "" {
@@ -196,34 +225,42 @@ func (h *Handler) listObjectsV1(w http.ResponseWriter, r *http.Request, bucket s
 		}
 	}
 
-	objects, isTruncated, err := h.storage.ListObjects(r.Context(), bucket, prefix, delimiter, maxKeys)
+	listing, err := h.storage.ListObjects(r.Context(), bucket, prefix, delimiter, marker, maxKeys)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	type ListBucketResult struct {
-		XMLName     xml.Name `xml:"ListBucketResult"`
-		Name        string   `xml:"Name"`
-		Prefix      string   `xml:"Prefix"`
-		MaxKeys     int      `xml:"MaxKeys"`
-		IsTruncated bool     `xml:"IsTruncated"`
-		Contents    []Object `xml:"Contents"`
+		XMLName        xml.Name       `xml:"ListBucketResult"`
+		Name           string         `xml:"Name"`
+		Prefix         string         `xml:"Prefix"`
+		Marker         string         `xml:"Marker"`
+		NextMarker     string         `xml:"NextMarker,omitempty"`
+		Delimiter      string         `xml:"Delimiter,omitempty"`
+		MaxKeys        int            `xml:"MaxKeys"`
+		IsTruncated    bool           `xml:"IsTruncated"`
+		Contents       []Object       `xml:"Contents"`
+		CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
 	}
 
 	result := ListBucketResult{
-		Name:        bucket,
-		Prefix:      prefix,
-		MaxKeys:     maxKeys,
-		IsTruncated: isTruncated,
-		Contents:    make([]Object, len(objects)),
+		Name:           bucket,
+		Prefix:         prefix,
+		Marker:         marker,
+		NextMarker:     listing.NextMarker,
+		Delimiter:      delimiter,
+		MaxKeys:        maxKeys,
+		IsTruncated:    listing.IsTruncated,
+		Contents:       make([]Object, len(listing.Objects)),
+		CommonPrefixes: commonPrefixes(listing.CommonPrefixes),
 	}
 
-	for i, obj := range objects {
+	for i, obj := range listing.Objects {
 		result.Contents[i] = Object{
 			Key:          obj.Key,
 			LastModified: obj.LastModified,
-			ETag:         obj.ETag,
+			ETag:         quoteETag(obj.ETag),
 			Size:         obj.Size,
 			StorageClass: "STANDARD",
 		}
@@ -234,29 +271,24 @@ func (h *Handler) listObjectsV1(w http.ResponseWriter, r *http.Request, bucket s
 }
 func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 
-	if bucket == "" || object == "" {
-		http.Error(w, "bucket and object required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
 		return
 	}
 
 	rangeHeader := r.Header.Get("Range")
+	versionID := r.URL.Query().Get("versionId")
 
-	reader, info, err := h.storage.GetObject(r.Context(), bucket, object, rangeHeader)
+	reader, info, err := h.storage.GetObject(r.Context(), bucket, object, versionID, rangeHeader)
 	if err != nil {
-		if err == storage.ErrNotFound {
-			http.Error(w, "Object not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeReadError(w, r, info, err)
 		return
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Type", info.ContentType)
-	w.Header().Set("ETag", info.ETag)
-	w.Header().Set("Last-Modified", info.LastModified.Format(http.TimeFormat))
+	setObjectHeaders(w, info)
+	setVersionHeaders(w, info)
 	w.Header().Set("Accept-Ranges", "bytes")
 
 	// Handle range requests
@@ -271,18 +303,12 @@ func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 }
 func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 
-	if bucket == "" || object == "" {
-		http.Error(w, "bucket and object required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
 		return
 	}
 
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-
 	contentLengthStr := r.Header.Get("Content-Length")
 	contentLength := int64(-1)
 	if contentLengthStr != "" {
@@ -291,56 +317,185 @@ func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err := h.storage.PutObject(r.Context(), bucket, object, r.Body, contentLength, contentType)
+	// Content-MD5 is stored with the object and verified by storage
+	body, err := verifiedPayload(r, false)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	info, err := h.storage.PutObject(r.Context(), bucket, object, body, contentLength, objectMetadataFromRequest(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(info.ETag))
+	setVersionHeaders(w, info)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 
-	if bucket == "" || object == "" {
-		http.Error(w, "bucket and object required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
 		return
 	}
 
-	err := h.storage.DeleteObject(r.Context(), bucket, object)
+	info, err := h.storage.DeleteObject(r.Context(), bucket, object, r.URL.Query().Get("versionId"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	setVersionHeaders(w, info)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 
-	if bucket == "" || object == "" {
-		http.Error(w, "bucket and object required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
 		return
 	}
 
-	info, err := h.storage.HeadObject(r.Context(), bucket, object)
+	info, err := h.storage.HeadObject(r.Context(), bucket, object, r.URL.Query().Get("versionId"))
 	if err != nil {
-		if err == storage.ErrNotFound {
-			http.Error(w, "Object not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeReadError(w, r, info, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", info.ContentType)
+	setObjectHeaders(w, info)
+	setVersionHeaders(w, info)
 	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
-	w.Header().Set("ETag", info.ETag)
-	w.Header().Set("Last-Modified", info.LastModified.Format(http.TimeFormat))
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeReadError renders a failed GetObject or HeadObject. When the version
+// read is a delete marker, storage returns its info with the error and the
+// marker is reported in the headers.
+func writeReadError(w http.ResponseWriter, r *http.Request, info *storage.ObjectInfo, err error) {
+	if info != nil && info.DeleteMarker {
+		setVersionHeaders(w, info)
+	}
+	writeError(w, r, err)
+}
+
+// Continuation tokens are opaque to clients; they wrap the key the next
+// page starts after.
+func encodeContinuationToken(marker string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(marker))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+	marker, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	return string(marker), nil
+}
+
+// ObjectKey returns the object key captured by the route's trailing
+// wildcard. chi matches against the raw path when it carries escapes that
+// differ from the default encoding, so the key is unescaped in that case.
+func ObjectKey(r *http.Request) string {
+	key := chi.URLParam(r, "*")
+	if r.URL.RawPath != "" {
+		if unescaped, err := url.PathUnescape(key); err == nil {
+			return unescaped
+		}
+	}
+	return key
+}
+
+func commonPrefixes(prefixes []string) []CommonPrefix {
+	result := make([]CommonPrefix, len(prefixes))
+	for i, prefix := range prefixes {
+		result[i] = CommonPrefix{Prefix: prefix}
+	}
+	return result
+}
+
+// quoteETag wraps an ETag in double quotes as S3 clients expect.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, "\"") {
+		return etag
+	}
+	return "\"" + etag + "\""
+}
+
+// checksumHeaders maps the x-amz-checksum-* request headers to the
+// algorithm names used in storage.ObjectMetadata.Checksums.
+var checksumHeaders = map[string]string{
+	"X-Amz-Checksum-Crc32":  "CRC32",
+	"X-Amz-Checksum-Crc32c": "CRC32C",
+	"X-Amz-Checksum-Sha1":   "SHA1",
+	"X-Amz-Checksum-Sha256": "SHA256",
+}
+
+// objectMetadataFromRequest collects the standard and x-amz-meta-* headers
+// that S3 persists alongside an object.
+func objectMetadataFromRequest(r *http.Request) storage.ObjectMetadata {
+	meta := storage.ObjectMetadata{
+		ContentType:        r.Header.Get("Content-Type"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = "application/octet-stream"
+	}
+
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
+			if meta.UserMetadata == nil {
+				meta.UserMetadata = make(map[string]string)
+			}
+			meta.UserMetadata[strings.TrimPrefix(lower, "x-amz-meta-")] = strings.Join(values, ",")
+		}
+	}
+
+	if md5 := r.Header.Get("Content-MD5"); md5 != "" {
+		meta.Checksums = map[string]string{"MD5": md5}
+	}
+	for header, algorithm := range checksumHeaders {
+		if value := r.Header.Get(header); value != "" {
+			if meta.Checksums == nil {
+				meta.Checksums = make(map[string]string)
+			}
+			meta.Checksums[algorithm] = value
+		}
+	}
+
+	return meta
+}
+
+// setObjectHeaders writes the stored object metadata as response headers.
+func setObjectHeaders(w http.ResponseWriter, info *storage.ObjectInfo) {
+	header := w.Header()
+	header.Set("Content-Type", info.ContentType)
+	header.Set("ETag", quoteETag(info.ETag))
+	header.Set("Last-Modified", info.LastModified.Format(http.TimeFormat))
+
+	if info.ContentEncoding != "" {
+		header.Set("Content-Encoding", info.ContentEncoding)
+	}
+	if info.ContentDisposition != "" {
+		header.Set("Content-Disposition", info.ContentDisposition)
+	}
+	if info.CacheControl != "" {
+		header.Set("Cache-Control", info.CacheControl)
+	}
+	for name, value := range info.UserMetadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	for name, algorithm := range checksumHeaders {
+		if value, ok := info.Checksums[algorithm]; ok {
+			header.Set(name, value)
+		}
+	}
+}
@@ -3,21 +3,39 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/s3err"
 	"github.com/alexerm/porterfs/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
 
 type mockStorage struct {
-	buckets []string
-	objects map[string][]storage.ObjectInfo
+	buckets        []string
+	objects        map[string][]storage.ObjectInfo
+	lastMeta       storage.ObjectMetadata
+	lastKey        string
+	lastStartAfter string
+	versioning     string
+	lifecycle      []storage.LifecycleRule
+	policy         []byte
+	lastCopySource storage.CopySource
+	lastCopyMeta   *storage.ObjectMetadata
+
+	lastKeyMarker      string
+	lastUploadIDMarker string
+	lastMaxUploads     int
 }
 
 func newMockStorage() *mockStorage {
@@ -55,11 +73,68 @@ func (m *mockStorage) ListBuckets(ctx context.Context) ([]string, error) {
 	return m.buckets, nil
 }
 
-func (m *mockStorage) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, contentType string) error {
+func (m *mockStorage) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, meta storage.ObjectMetadata) (*storage.ObjectInfo, error) {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, err
+	}
+	m.lastMeta = meta
+	m.lastKey = key
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  meta.ContentType,
+		ETag:         "abc123",
+		UserMetadata: meta.UserMetadata,
+	}, nil
+}
+
+func (m *mockStorage) PutBucketVersioning(ctx context.Context, bucket, status string) error {
+	m.versioning = status
+	return nil
+}
+
+func (m *mockStorage) GetBucketVersioning(ctx context.Context, bucket string) (string, error) {
+	return m.versioning, nil
+}
+
+func (m *mockStorage) PutBucketLifecycle(ctx context.Context, bucket string, rules []storage.LifecycleRule) error {
+	m.lifecycle = rules
+	return nil
+}
+
+func (m *mockStorage) GetBucketLifecycle(ctx context.Context, bucket string) ([]storage.LifecycleRule, error) {
+	if len(m.lifecycle) == 0 {
+		return nil, storage.ErrNoSuchLifecycleConfiguration
+	}
+	return m.lifecycle, nil
+}
+
+func (m *mockStorage) DeleteBucketLifecycle(ctx context.Context, bucket string) error {
+	m.lifecycle = nil
+	return nil
+}
+
+func (m *mockStorage) PutBucketPolicy(ctx context.Context, bucket string, policy []byte) error {
+	m.policy = policy
 	return nil
 }
 
-func (m *mockStorage) GetObject(ctx context.Context, bucket, key string, rangeHeader string) (io.ReadCloser, *storage.ObjectInfo, error) {
+func (m *mockStorage) GetBucketPolicy(ctx context.Context, bucket string) ([]byte, error) {
+	if len(m.policy) == 0 {
+		return nil, storage.ErrNoSuchBucketPolicy
+	}
+	return m.policy, nil
+}
+
+func (m *mockStorage) DeleteBucketPolicy(ctx context.Context, bucket string) error {
+	m.policy = nil
+	return nil
+}
+
+func (m *mockStorage) GetObject(ctx context.Context, bucket, key, versionID, rangeHeader string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	if marker, err := m.deleteMarker(key, versionID); err != nil {
+		return nil, marker, err
+	}
 	return io.NopCloser(strings.NewReader("test content")), &storage.ObjectInfo{
 		Key:         key,
 		Size:        12,
@@ -68,44 +143,157 @@ func (m *mockStorage) GetObject(ctx context.Context, bucket, key string, rangeHe
 	}, nil
 }
 
-func (m *mockStorage) DeleteObject(ctx context.Context, bucket, key string) error {
-	return nil
+func (m *mockStorage) DeleteObject(ctx context.Context, bucket, key, versionID string) (*storage.ObjectInfo, error) {
+	if versionID == "" && m.versioning == storage.VersioningEnabled {
+		return &storage.ObjectInfo{Key: key, VersionID: "marker1", DeleteMarker: true}, nil
+	}
+	return &storage.ObjectInfo{Key: key, VersionID: versionID}, nil
 }
 
-func (m *mockStorage) HeadObject(ctx context.Context, bucket, key string) (*storage.ObjectInfo, error) {
+// deleteMarker fails reads of deleted.txt, which is hidden behind the
+// delete marker marker1.
+func (m *mockStorage) deleteMarker(key, versionID string) (*storage.ObjectInfo, error) {
+	if key != "deleted.txt" {
+		return nil, nil
+	}
+	marker := &storage.ObjectInfo{Key: key, VersionID: "marker1", DeleteMarker: true}
+	switch versionID {
+	case "":
+		return marker, storage.ErrNotFound
+	case marker.VersionID:
+		return marker, storage.ErrDeleteMarker
+	}
+	return nil, nil
+}
+
+func (m *mockStorage) DeleteObjects(ctx context.Context, bucket string, objects []storage.ObjectIdentifier) ([]storage.DeleteResult, error) {
+	results := make([]storage.DeleteResult, len(objects))
+	for i, object := range objects {
+		if object.Key == "locked.txt" {
+			results[i].Err = storage.ErrInvalidObjectName
+			continue
+		}
+		results[i].Info, results[i].Err = m.DeleteObject(ctx, bucket, object.Key, object.VersionID)
+	}
+	return results, nil
+}
+
+func (m *mockStorage) HeadObject(ctx context.Context, bucket, key, versionID string) (*storage.ObjectInfo, error) {
+	if versionID == "missing" {
+		return nil, storage.ErrNoSuchVersion
+	}
+	if marker, err := m.deleteMarker(key, versionID); err != nil {
+		return marker, err
+	}
 	return &storage.ObjectInfo{
-		Key:         key,
-		Size:        12,
-		ContentType: "text/plain",
-		ETag:        "abc123",
+		Key:                key,
+		Size:               12,
+		ContentType:        "text/plain",
+		ContentDisposition: "attachment",
+		ETag:               "abc123",
+		UserMetadata:       map[string]string{"owner": "porter"},
 	}, nil
 }
 
-func (m *mockStorage) ListObjects(ctx context.Context, bucket, prefix, delimiter string, maxKeys int) ([]storage.ObjectInfo, bool, error) {
+func (m *mockStorage) ListObjects(ctx context.Context, bucket, prefix, delimiter, startAfter string, maxKeys int) (*storage.ListObjectsResult, error) {
+	m.lastStartAfter = startAfter
+	result := &storage.ListObjectsResult{}
 	if objects, exists := m.objects[bucket]; exists {
-		return objects, false, nil
+		result.Objects = objects
+	}
+	if len(result.Objects) > maxKeys {
+		result.Objects = result.Objects[:maxKeys]
+		result.IsTruncated = true
+		result.NextMarker = result.Objects[maxKeys-1].Key
+	}
+	if delimiter != "" {
+		result.CommonPrefixes = []string{prefix + "photos" + delimiter}
 	}
-	return []storage.ObjectInfo{}, false, nil
+	return result, nil
 }
 
-func (m *mockStorage) InitMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
+func (m *mockStorage) ListObjectVersions(ctx context.Context, bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*storage.ListObjectVersionsResult, error) {
+	return &storage.ListObjectVersionsResult{
+		Versions: []storage.ObjectVersion{
+			{ObjectInfo: storage.ObjectInfo{Key: "doc.txt", VersionID: "v2", DeleteMarker: true}, IsLatest: true},
+			{ObjectInfo: storage.ObjectInfo{Key: "doc.txt", VersionID: "v1", ETag: "abc123", Size: 12}},
+			{ObjectInfo: storage.ObjectInfo{Key: "doc.txt", ETag: "def456", Size: 3}},
+		},
+	}, nil
+}
+
+func (m *mockStorage) CopyObject(ctx context.Context, src storage.CopySource, bucket, key string, meta *storage.ObjectMetadata) (*storage.ObjectInfo, error) {
+	m.lastCopySource = src
+	m.lastCopyMeta = meta
+	return &storage.ObjectInfo{Key: key, ETag: "abc123"}, nil
+}
+
+func (m *mockStorage) UploadPartCopy(ctx context.Context, src storage.CopySource, bucket, key, uploadID string, partNumber int) (string, error) {
+	m.lastCopySource = src
+	return "def456", nil
+}
+
+func (m *mockStorage) InitMultipartUpload(ctx context.Context, bucket, key string, meta storage.ObjectMetadata) (string, error) {
 	return "", nil
 }
 
 func (m *mockStorage) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return "", err
+	}
 	return "", nil
 }
 
-func (m *mockStorage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []storage.Part) error {
-	return nil
+func (m *mockStorage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []storage.Part) (*storage.ObjectInfo, error) {
+	if len(parts) > 1 && parts[0].PartNumber > parts[1].PartNumber {
+		return nil, storage.ErrInvalidPartOrder
+	}
+	return &storage.ObjectInfo{Key: key, ETag: "0123456789abcdef0123456789abcdef-2"}, nil
 }
 
 func (m *mockStorage) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
 	return nil
 }
 
-func (m *mockStorage) ListMultipartUploads(ctx context.Context, bucket string) ([]storage.MultipartUpload, error) {
-	return []storage.MultipartUpload{}, nil
+func (m *mockStorage) ListParts(ctx context.Context, bucket, key, uploadID string, partNumberMarker, maxParts int) (*storage.ListPartsResult, error) {
+	if uploadID == "missing" {
+		return nil, storage.ErrNoSuchUpload
+	}
+
+	result := &storage.ListPartsResult{}
+	for n := partNumberMarker + 1; n <= 3; n++ {
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			result.NextPartNumberMarker = n - 1
+			break
+		}
+		result.Parts = append(result.Parts, storage.PartInfo{
+			PartNumber:   n,
+			Size:         5 << 20,
+			ETag:         "0123456789abcdef0123456789abcdef",
+			LastModified: time.Now(),
+		})
+	}
+	return result, nil
+}
+
+func (m *mockStorage) ListMultipartUploads(ctx context.Context, bucket, prefix, delimiter, keyMarker, uploadIDMarker string, maxUploads int) (*storage.ListMultipartUploadsResult, error) {
+	m.lastKeyMarker = keyMarker
+	m.lastUploadIDMarker = uploadIDMarker
+	m.lastMaxUploads = maxUploads
+	return &storage.ListMultipartUploadsResult{
+		Uploads: []storage.MultipartUpload{
+			{UploadID: "upload-1", Key: "big.bin", Initiated: time.Now()},
+		},
+		CommonPrefixes:     []string{prefix + "photos" + delimiter},
+		IsTruncated:        true,
+		NextKeyMarker:      prefix + "photos" + delimiter,
+		NextUploadIDMarker: "",
+	}, nil
+}
+
+func (m *mockStorage) ReapMultipartUploads(ctx context.Context, now time.Time, expiry time.Duration) (*storage.ReapResult, error) {
+	return &storage.ReapResult{}, nil
 }
 
 func TestListBuckets(t *testing.T) {
@@ -175,13 +363,120 @@ func TestListObjectsV2(t *testing.T) {
 	}
 }
 
+func TestListObjectsV2CommonPrefixes(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", handler.ListObjects)
+
+	req := httptest.NewRequest("GET", "/test-bucket?list-type=2&delimiter=/", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	var result ListObjectsV2Result
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if result.Delimiter != "/" {
+		t.Errorf("Expected delimiter '/', got '%s'", result.Delimiter)
+	}
+
+	if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "photos/" {
+		t.Errorf("Expected common prefix 'photos/', got %v", result.CommonPrefixes)
+	}
+
+	if result.KeyCount != 2 {
+		t.Errorf("Expected KeyCount 2, got %d", result.KeyCount)
+	}
+}
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	mockStore := newMockStorage()
+	mockStore.objects["test-bucket"] = append(mockStore.objects["test-bucket"], storage.ObjectInfo{Key: "z.txt"})
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", handler.ListObjects)
+
+	req := httptest.NewRequest("GET", "/test-bucket?list-type=2&max-keys=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var first ListObjectsV2Result
+	if err := xml.Unmarshal(w.Body.Bytes(), &first); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if !first.IsTruncated || first.NextContinuationToken == "" {
+		t.Fatalf("Expected truncated page with continuation token, got %+v", first)
+	}
+
+	req = httptest.NewRequest("GET", "/test-bucket?list-type=2&max-keys=1&continuation-token="+first.NextContinuationToken, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	if mockStore.lastStartAfter != "test-object.txt" {
+		t.Errorf("Expected continuation token to resume after 'test-object.txt', got '%s'", mockStore.lastStartAfter)
+	}
+
+	var second ListObjectsV2Result
+	if err := xml.Unmarshal(w.Body.Bytes(), &second); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if second.ContinuationToken != first.NextContinuationToken {
+		t.Errorf("Expected continuation token to be echoed, got '%s'", second.ContinuationToken)
+	}
+
+	req = httptest.NewRequest("GET", "/test-bucket?list-type=2&continuation-token=!!!", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for malformed token, got %d", w.Code)
+	}
+}
+
+func TestListObjectsV1Marker(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", handler.ListObjects)
+
+	req := httptest.NewRequest("GET", "/test-bucket?marker=a.txt", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	if mockStore.lastStartAfter != "a.txt" {
+		t.Errorf("Expected marker 'a.txt' to be passed to storage, got '%s'", mockStore.lastStartAfter)
+	}
+}
+
 func TestGetObject(t *testing.T) {
 	mockStore := newMockStorage()
 	cfg := config.DefaultConfig()
 	handler := New(mockStore, cfg)
 
 	r := chi.NewRouter()
-	r.Get("/{bucket}/{object:.*}", handler.GetObject)
+	r.Get("/{bucket}/*", handler.GetObject)
 
 	req := httptest.NewRequest("GET", "/test-bucket/test-object.txt", nil)
 	w := httptest.NewRecorder()
@@ -207,7 +502,7 @@ func TestPutObject(t *testing.T) {
 	handler := New(mockStore, cfg)
 
 	r := chi.NewRouter()
-	r.Put("/{bucket}/{object:.*}", handler.PutObject)
+	r.Put("/{bucket}/*", handler.PutObject)
 
 	body := bytes.NewReader([]byte("test content"))
 	req := httptest.NewRequest("PUT", "/test-bucket/new-object.txt", body)
@@ -221,3 +516,901 @@ func TestPutObject(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 }
+
+func TestPayloadVerification(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}/*", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("uploadId") {
+			handler.UploadPart(w, r)
+			return
+		}
+		handler.PutObject(w, r)
+	})
+
+	content := "test content"
+	sha := sha256.Sum256([]byte(content))
+	sum := md5.Sum([]byte(content))
+	contentSHA256 := hex.EncodeToString(sha[:])
+	contentMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		target   string
+		headers  map[string]string
+		wantCode string
+	}{
+		{"MatchingSHA256", "/test-bucket/obj", map[string]string{"X-Amz-Content-Sha256": contentSHA256}, ""},
+		{"UnsignedPayload", "/test-bucket/obj", map[string]string{"X-Amz-Content-Sha256": "UNSIGNED-PAYLOAD"}, ""},
+		{"SHA256Mismatch", "/test-bucket/obj", map[string]string{"X-Amz-Content-Sha256": strings.Repeat("0", 64)}, "XAmzContentSHA256Mismatch"},
+		{"MalformedSHA256", "/test-bucket/obj", map[string]string{"X-Amz-Content-Sha256": "not-a-hash"}, "InvalidArgument"},
+		{"PartSHA256Mismatch", "/test-bucket/obj?uploadId=1&partNumber=1", map[string]string{"X-Amz-Content-Sha256": strings.Repeat("0", 64)}, "XAmzContentSHA256Mismatch"},
+		{"PartMatchingMD5", "/test-bucket/obj?uploadId=1&partNumber=1", map[string]string{"Content-MD5": contentMD5}, ""},
+		{"PartMD5Mismatch", "/test-bucket/obj?uploadId=1&partNumber=1", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(make([]byte, md5.Size))}, "BadDigest"},
+		{"PartInvalidMD5", "/test-bucket/obj?uploadId=1&partNumber=1", map[string]string{"Content-MD5": "abc"}, "InvalidDigest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", tt.target, strings.NewReader(content))
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if tt.wantCode == "" {
+				if w.Code != http.StatusOK {
+					t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			var result s3err.ErrorResponse
+			if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if w.Code != http.StatusBadRequest || result.Code != tt.wantCode {
+				t.Errorf("Expected 400 %s, got %d %s", tt.wantCode, w.Code, result.Code)
+			}
+		})
+	}
+}
+
+func TestPutObjectMetadata(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}/*", handler.PutObject)
+
+	req := httptest.NewRequest("PUT", "/test-bucket/new-object.txt", strings.NewReader("test content"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("X-Amz-Meta-Owner", "porter")
+	req.Header.Set("X-Amz-Checksum-Sha256", "checksum")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	meta := mockStore.lastMeta
+	if meta.ContentType != "text/plain" {
+		t.Errorf("Expected Content-Type 'text/plain', got '%s'", meta.ContentType)
+	}
+	if meta.CacheControl != "no-cache" {
+		t.Errorf("Expected Cache-Control 'no-cache', got '%s'", meta.CacheControl)
+	}
+	if meta.UserMetadata["owner"] != "porter" {
+		t.Errorf("Expected user metadata owner=porter, got %v", meta.UserMetadata)
+	}
+	if meta.Checksums["SHA256"] != "checksum" {
+		t.Errorf("Expected SHA256 checksum to be recorded, got %v", meta.Checksums)
+	}
+}
+
+func TestHeadObjectMetadata(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Head("/{bucket}/*", handler.HeadObject)
+
+	req := httptest.NewRequest("HEAD", "/test-bucket/test-object.txt", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	if w.Header().Get("Content-Disposition") != "attachment" {
+		t.Errorf("Expected Content-Disposition 'attachment', got '%s'", w.Header().Get("Content-Disposition"))
+	}
+
+	if w.Header().Get("X-Amz-Meta-Owner") != "porter" {
+		t.Errorf("Expected X-Amz-Meta-Owner 'porter', got '%s'", w.Header().Get("X-Amz-Meta-Owner"))
+	}
+
+	if w.Header().Get("ETag") != `"abc123"` {
+		t.Errorf("Expected quoted ETag, got '%s'", w.Header().Get("ETag"))
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}", handler.CreateBucket)
+	r.Put("/{bucket}/*", handler.PutObject)
+
+	t.Run("InvalidBucketName", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/Invalid_Bucket", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+
+		var result s3err.ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.Code != "InvalidBucketName" {
+			t.Errorf("Expected code 'InvalidBucketName', got '%s'", result.Code)
+		}
+	})
+
+	t.Run("KeyTooLong", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+strings.Repeat("k", 1025), strings.NewReader("x"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var result s3err.ErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.Code != "KeyTooLongError" {
+			t.Errorf("Expected code 'KeyTooLongError', got '%s'", result.Code)
+		}
+	})
+
+	t.Run("NestedKey", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/photos/2024/jan%2Bfeb.jpg", strings.NewReader("x"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+		if mockStore.lastKey != "photos/2024/jan+feb.jpg" {
+			t.Errorf("Expected key 'photos/2024/jan+feb.jpg', got '%s'", mockStore.lastKey)
+		}
+	})
+
+	t.Run("TraversalKey", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/a/%2E%2E/%2E%2E/etc/passwd", strings.NewReader("x"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestBucketVersioning(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}", handler.PutBucketVersioning)
+	r.Get("/{bucket}", handler.GetBucketVersioning)
+	r.Get("/{bucket}/*", handler.GetObject)
+	r.Head("/{bucket}/*", handler.HeadObject)
+	r.Delete("/{bucket}/*", handler.DeleteObject)
+
+	t.Run("MalformedConfiguration", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket?versioning", strings.NewReader("<VersioningConfiguration"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("EnableAndGet", func(t *testing.T) {
+		body := `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`
+		req := httptest.NewRequest("PUT", "/test-bucket?versioning", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest("GET", "/test-bucket?versioning", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var result VersioningConfiguration
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.Status != "Enabled" {
+			t.Errorf("Expected status 'Enabled', got '%s'", result.Status)
+		}
+	})
+
+	t.Run("DeleteMarkerHeaders", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/test-bucket/doc.txt", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", w.Code)
+		}
+		if w.Header().Get("x-amz-delete-marker") != "true" {
+			t.Error("Expected x-amz-delete-marker header")
+		}
+		if w.Header().Get("x-amz-version-id") != "marker1" {
+			t.Errorf("Expected version 'marker1', got '%s'", w.Header().Get("x-amz-version-id"))
+		}
+	})
+
+	t.Run("ReadDeleteMarker", func(t *testing.T) {
+		tests := []struct {
+			method   string
+			target   string
+			wantCode int
+		}{
+			{"GET", "/test-bucket/deleted.txt", http.StatusNotFound},
+			{"HEAD", "/test-bucket/deleted.txt", http.StatusNotFound},
+			{"GET", "/test-bucket/deleted.txt?versionId=marker1", http.StatusMethodNotAllowed},
+			{"HEAD", "/test-bucket/deleted.txt?versionId=marker1", http.StatusMethodNotAllowed},
+		}
+		for _, tt := range tests {
+			t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+				req := httptest.NewRequest(tt.method, tt.target, nil)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if w.Code != tt.wantCode {
+					t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
+				}
+				if w.Header().Get("x-amz-delete-marker") != "true" {
+					t.Error("Expected x-amz-delete-marker header")
+				}
+				if w.Header().Get("x-amz-version-id") != "marker1" {
+					t.Errorf("Expected version 'marker1', got '%s'", w.Header().Get("x-amz-version-id"))
+				}
+			})
+		}
+	})
+}
+
+func TestHeadObjectVersion(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Head("/{bucket}/*", handler.HeadObject)
+
+	req := httptest.NewRequest("HEAD", "/test-bucket/doc.txt?versionId=missing", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestListObjectVersions(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", handler.ListObjectVersions)
+
+	req := httptest.NewRequest("GET", "/test-bucket?versions", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var result ListVersionsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if len(result.DeleteMarkers) != 1 || !result.DeleteMarkers[0].IsLatest {
+		t.Errorf("Expected one latest delete marker, got %+v", result.DeleteMarkers)
+	}
+	if len(result.Versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(result.Versions))
+	}
+	if result.Versions[1].VersionID != "null" {
+		t.Errorf("Expected null version ID 'null', got '%s'", result.Versions[1].VersionID)
+	}
+	if result.Versions[0].ETag != "\"abc123\"" {
+		t.Errorf("Expected quoted ETag, got '%s'", result.Versions[0].ETag)
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}/*", handler.CopyObject)
+
+	t.Run("CopySource", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/copy.txt", nil)
+		req.Header.Set("x-amz-copy-source", "/src-bucket/dir/file%20name.txt?versionId=v1")
+		req.Header.Set("x-amz-copy-source-if-match", "\"abc123\"")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		src := mockStore.lastCopySource
+		if src.Bucket != "src-bucket" || src.Key != "dir/file name.txt" || src.VersionID != "v1" {
+			t.Errorf("Unexpected copy source %+v", src)
+		}
+		if src.Conditions.IfMatch != "\"abc123\"" {
+			t.Errorf("Expected If-Match condition, got '%s'", src.Conditions.IfMatch)
+		}
+		if mockStore.lastCopyMeta != nil {
+			t.Error("Expected COPY directive to keep source metadata")
+		}
+		if w.Header().Get("x-amz-copy-source-version-id") != "v1" {
+			t.Error("Expected x-amz-copy-source-version-id header")
+		}
+
+		var result CopyObjectResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.ETag != "\"abc123\"" {
+			t.Errorf("Expected ETag '\"abc123\"', got '%s'", result.ETag)
+		}
+	})
+
+	t.Run("ReplaceDirective", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/copy.txt", nil)
+		req.Header.Set("x-amz-copy-source", "test-bucket/copy.txt")
+		req.Header.Set("x-amz-metadata-directive", "REPLACE")
+		req.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		if mockStore.lastCopyMeta == nil || mockStore.lastCopyMeta.ContentType != "text/csv" {
+			t.Errorf("Expected replaced metadata, got %+v", mockStore.lastCopyMeta)
+		}
+	})
+
+	t.Run("CopyToItself", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/copy.txt", nil)
+		req.Header.Set("x-amz-copy-source", "test-bucket/copy.txt")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidCopySource", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/copy.txt", nil)
+		req.Header.Set("x-amz-copy-source", "no-key")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestDeleteObjects(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Post("/{bucket}", handler.DeleteObjects)
+
+	body := `<Delete>
+		<Object><Key>a.txt</Key></Object>
+		<Object><Key>b.txt</Key><VersionId>v1</VersionId></Object>
+		<Object><Key>locked.txt</Key></Object>
+	</Delete>`
+
+	t.Run("Verbose", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var result DeleteObjectsResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(result.Deleted) != 2 {
+			t.Fatalf("Expected 2 deleted entries, got %d", len(result.Deleted))
+		}
+		if result.Deleted[1].Key != "b.txt" || result.Deleted[1].VersionID != "v1" {
+			t.Errorf("Unexpected deleted entry %+v", result.Deleted[1])
+		}
+		if len(result.Errors) != 1 || result.Errors[0].Key != "locked.txt" || result.Errors[0].Code != "InvalidArgument" {
+			t.Errorf("Unexpected errors %+v", result.Errors)
+		}
+	})
+
+	t.Run("Quiet", func(t *testing.T) {
+		quiet := strings.Replace(body, "<Delete>", "<Delete><Quiet>true</Quiet>", 1)
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(quiet))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var result DeleteObjectsResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(result.Deleted) != 0 || len(result.Errors) != 1 {
+			t.Errorf("Expected only errors in quiet mode, got %+v", result)
+		}
+	})
+
+	t.Run("ContentMD5Mismatch", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(body))
+		req.Header.Set("Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "BadDigest") {
+			t.Errorf("Expected BadDigest error, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("TooManyKeys", func(t *testing.T) {
+		var b strings.Builder
+		b.WriteString("<Delete>")
+		for i := 0; i <= 1000; i++ {
+			b.WriteString("<Object><Key>k</Key></Object>")
+		}
+		b.WriteString("</Delete>")
+
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(b.String()))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestCompleteMultipartUpload(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Post("/{bucket}/*", handler.CompleteMultipartUpload)
+
+	t.Run("CompositeETag", func(t *testing.T) {
+		body := `<CompleteMultipartUpload>
+			<Part><PartNumber>1</PartNumber><ETag>"a"</ETag></Part>
+			<Part><PartNumber>2</PartNumber><ETag>"b"</ETag></Part>
+		</CompleteMultipartUpload>`
+		req := httptest.NewRequest("POST", "/test-bucket/big.bin?uploadId=123", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var result CompleteMultipartUploadResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.ETag != "\"0123456789abcdef0123456789abcdef-2\"" {
+			t.Errorf("Expected composite ETag, got '%s'", result.ETag)
+		}
+	})
+
+	t.Run("InvalidPartOrder", func(t *testing.T) {
+		body := `<CompleteMultipartUpload>
+			<Part><PartNumber>2</PartNumber><ETag>"b"</ETag></Part>
+			<Part><PartNumber>1</PartNumber><ETag>"a"</ETag></Part>
+		</CompleteMultipartUpload>`
+		req := httptest.NewRequest("POST", "/test-bucket/big.bin?uploadId=123", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidPartOrder") {
+			t.Errorf("Expected InvalidPartOrder, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("NoParts", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test-bucket/big.bin?uploadId=123", strings.NewReader("<CompleteMultipartUpload></CompleteMultipartUpload>"))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MalformedXML") {
+			t.Errorf("Expected MalformedXML, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestListParts(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}/*", handler.ListParts)
+
+	t.Run("Pagination", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/big.bin?uploadId=123&max-parts=2", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var first ListPartsResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &first); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(first.Parts) != 2 || !first.IsTruncated || first.NextPartNumberMarker != 2 {
+			t.Fatalf("Expected 2 parts and a truncated page, got %+v", first)
+		}
+		if first.Parts[0].ETag != "\"0123456789abcdef0123456789abcdef\"" {
+			t.Errorf("Expected quoted ETag, got '%s'", first.Parts[0].ETag)
+		}
+
+		req = httptest.NewRequest("GET", "/test-bucket/big.bin?uploadId=123&max-parts=2&part-number-marker=2", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var second ListPartsResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &second); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(second.Parts) != 1 || second.Parts[0].PartNumber != 3 || second.IsTruncated {
+			t.Errorf("Expected only part 3 on the last page, got %+v", second)
+		}
+	})
+
+	t.Run("NoSuchUpload", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/big.bin?uploadId=missing", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+			t.Errorf("Expected NoSuchUpload, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("InvalidMarker", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/big.bin?uploadId=123&part-number-marker=abc", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestListMultipartUploads(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Get("/{bucket}", handler.ListMultipartUploads)
+
+	t.Run("Result", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket?uploads&delimiter=/&key-marker=a&upload-id-marker=upload-0&max-uploads=2", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		if mockStore.lastKeyMarker != "a" || mockStore.lastUploadIDMarker != "upload-0" || mockStore.lastMaxUploads != 2 {
+			t.Errorf("Expected markers and max-uploads to reach storage, got %q %q %d",
+				mockStore.lastKeyMarker, mockStore.lastUploadIDMarker, mockStore.lastMaxUploads)
+		}
+
+		var result ListMultipartUploadsResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if result.KeyMarker != "a" || result.UploadIDMarker != "upload-0" || result.MaxUploads != 2 || result.Delimiter != "/" {
+			t.Errorf("Expected request parameters echoed back, got %+v", result)
+		}
+		if !result.IsTruncated || result.NextKeyMarker != "photos/" {
+			t.Errorf("Expected a truncated page ending at photos/, got %+v", result)
+		}
+		if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "photos/" {
+			t.Errorf("Expected CommonPrefixes photos/, got %+v", result.CommonPrefixes)
+		}
+		if len(result.Uploads) != 1 {
+			t.Fatalf("Expected 1 upload, got %d", len(result.Uploads))
+		}
+		upload := result.Uploads[0]
+		if upload.UploadID != "upload-1" || upload.Initiator.ID == "" || upload.Owner.ID == "" || upload.StorageClass != "STANDARD" {
+			t.Errorf("Expected initiator, owner and storage class, got %+v", upload)
+		}
+	})
+
+	t.Run("UploadIDMarkerRequiresKeyMarker", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket?uploads&upload-id-marker=upload-0", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if mockStore.lastUploadIDMarker != "" || mockStore.lastMaxUploads != 1000 {
+			t.Errorf("Expected upload-id-marker to be ignored, got %q (max %d)", mockStore.lastUploadIDMarker, mockStore.lastMaxUploads)
+		}
+	})
+
+	t.Run("InvalidMaxUploads", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket?uploads&max-uploads=abc", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}", handler.PutBucketLifecycle)
+	r.Get("/{bucket}", handler.GetBucketLifecycle)
+	r.Delete("/{bucket}", handler.DeleteBucketLifecycle)
+
+	t.Run("NoConfiguration", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket?lifecycle", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchLifecycleConfiguration") {
+			t.Errorf("Expected NoSuchLifecycleConfiguration, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		body := `<LifecycleConfiguration>
+			<Rule>
+				<ID>abort-uploads</ID>
+				<Filter><Prefix>tmp/</Prefix></Filter>
+				<Status>Enabled</Status>
+				<AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+			</Rule>
+		</LifecycleConfiguration>`
+		req := httptest.NewRequest("PUT", "/test-bucket?lifecycle", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if len(mockStore.lifecycle) != 1 {
+			t.Fatalf("Expected 1 stored rule, got %d", len(mockStore.lifecycle))
+		}
+		rule := mockStore.lifecycle[0]
+		if rule.ID != "abort-uploads" || rule.Prefix != "tmp/" || !rule.Enabled || rule.AbortIncompleteUploadDays != 3 {
+			t.Errorf("Unexpected stored rule %+v", rule)
+		}
+
+		req = httptest.NewRequest("GET", "/test-bucket?lifecycle", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var result LifecycleConfiguration
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(result.Rules) != 1 || result.Rules[0].AbortIncompleteMultipartUpload.DaysAfterInitiation != 3 {
+			t.Errorf("Unexpected lifecycle configuration %+v", result)
+		}
+	})
+
+	t.Run("UnsupportedAction", func(t *testing.T) {
+		body := `<LifecycleConfiguration>
+			<Rule>
+				<Status>Enabled</Status>
+				<Expiration><Days>30</Days></Expiration>
+				<AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+			</Rule>
+		</LifecycleConfiguration>`
+		req := httptest.NewRequest("PUT", "/test-bucket?lifecycle", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status 501, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnsupportedFilter", func(t *testing.T) {
+		for _, filter := range []string{
+			`<And><Prefix>tmp/</Prefix><ObjectSizeGreaterThan>1024</ObjectSizeGreaterThan></And>`,
+			`<Tag><Key>temporary</Key><Value>true</Value></Tag>`,
+			`<ObjectSizeLessThan>1024</ObjectSizeLessThan>`,
+		} {
+			body := `<LifecycleConfiguration>
+				<Rule>
+					<Filter>` + filter + `</Filter>
+					<Status>Enabled</Status>
+					<AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+				</Rule>
+			</LifecycleConfiguration>`
+			req := httptest.NewRequest("PUT", "/test-bucket?lifecycle", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotImplemented {
+				t.Errorf("Expected status 501 for %s, got %d", filter, w.Code)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/test-bucket?lifecycle", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", w.Code)
+		}
+		if mockStore.lifecycle != nil {
+			t.Error("Expected lifecycle configuration to be removed")
+		}
+	})
+}
+
+func TestBucketPolicy(t *testing.T) {
+	mockStore := newMockStorage()
+	cfg := config.DefaultConfig()
+	handler := New(mockStore, cfg)
+
+	r := chi.NewRouter()
+	r.Put("/{bucket}", handler.PutBucketPolicy)
+	r.Get("/{bucket}", handler.GetBucketPolicy)
+	r.Delete("/{bucket}", handler.DeleteBucketPolicy)
+
+	t.Run("NoPolicy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket?policy", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchBucketPolicy") {
+			t.Errorf("Expected NoSuchBucketPolicy, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		body := `{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": {"AWS": ["consumer"]},
+				"Action": ["s3:GetObject", "s3:ListBucket"],
+				"Resource": ["arn:aws:s3:::test-bucket", "arn:aws:s3:::test-bucket/*"]
+			}]
+		}`
+		req := httptest.NewRequest("PUT", "/test-bucket?policy", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+		}
+
+		req = httptest.NewRequest("GET", "/test-bucket?policy", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("Expected stored policy, got %d %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected application/json, got %s", ct)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		tests := map[string]string{
+			"NotJSON":       `<Policy/>`,
+			"NoStatement":   `{"Version": "2012-10-17", "Statement": []}`,
+			"OtherBucket":   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::other-bucket/*"}]}`,
+			"BadEffect":     `{"Statement": [{"Effect": "Maybe", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*"}]}`,
+			"BadCondition":  `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*", "Condition": {"DateGreaterThan": {"aws:CurrentTime": "2020-01-01T00:00:00Z"}}}]}`,
+			"UnknownAction": `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "iam:CreateUser", "Resource": "arn:aws:s3:::test-bucket/*"}]}`,
+		}
+		for name, body := range tests {
+			t.Run(name, func(t *testing.T) {
+				req := httptest.NewRequest("PUT", "/test-bucket?policy", strings.NewReader(body))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MalformedPolicy") {
+					t.Errorf("Expected MalformedPolicy, got %d %s", w.Code, w.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("SHA256Mismatch", func(t *testing.T) {
+		stored := string(mockStore.policy)
+		body := `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*"}]}`
+		req := httptest.NewRequest("PUT", "/test-bucket?policy", strings.NewReader(body))
+		req.Header.Set("X-Amz-Content-Sha256", strings.Repeat("0", 64))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "XAmzContentSHA256Mismatch") {
+			t.Errorf("Expected XAmzContentSHA256Mismatch, got %d %s", w.Code, w.Body.String())
+		}
+		if string(mockStore.policy) != stored {
+			t.Errorf("Expected policy to be unchanged, got %s", mockStore.policy)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/test-bucket?policy", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", w.Code)
+		}
+		if mockStore.policy != nil {
+			t.Error("Expected policy to be removed")
+		}
+	})
+}
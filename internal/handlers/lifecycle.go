@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID     string           `xml:"ID,omitempty"`
+	Filter *LifecycleFilter `xml:"Filter,omitempty"`
+	// Prefix is the pre-Filter way of scoping a rule, still sent by
+	// older clients
+	Prefix                         string                          `xml:"Prefix,omitempty"`
+	Status                         string                          `xml:"Status"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
+
+	// Unsupported collects actions such as Expiration or Transition so
+	// they can be rejected rather than silently ignored
+	Unsupported []unsupportedElement `xml:",any"`
+}
+
+type LifecycleFilter struct {
+	Prefix string `xml:"Prefix"`
+
+	// Unsupported collects And, Tag and ObjectSize* conditions, which
+	// would otherwise widen the rule to every key
+	Unsupported []unsupportedElement `xml:",any"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+type unsupportedElement struct {
+	XMLName xml.Name
+}
+
+func (h *Handler) PutBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	body, err := readPayload(r, maxXMLBodySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body) > maxXMLBodySize {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	var req LifecycleConfiguration
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Rules) == 0 {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	rules := make([]storage.LifecycleRule, len(req.Rules))
+	for i, rule := range req.Rules {
+		if len(rule.Unsupported) > 0 || (rule.Filter != nil && len(rule.Filter.Unsupported) > 0) {
+			s3err.Write(w, r, s3err.ErrNotImplemented)
+			return
+		}
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			s3err.Write(w, r, s3err.ErrMalformedXML)
+			return
+		}
+		if rule.AbortIncompleteMultipartUpload == nil {
+			s3err.Write(w, r, s3err.ErrMalformedXML)
+			return
+		}
+
+		prefix := rule.Prefix
+		if rule.Filter != nil {
+			prefix = rule.Filter.Prefix
+		}
+
+		rules[i] = storage.LifecycleRule{
+			ID:                        rule.ID,
+			Prefix:                    prefix,
+			Enabled:                   rule.Status == "Enabled",
+			AbortIncompleteUploadDays: rule.AbortIncompleteMultipartUpload.DaysAfterInitiation,
+		}
+	}
+
+	if err := h.storage.PutBucketLifecycle(r.Context(), bucket, rules); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	rules, err := h.storage.GetBucketLifecycle(r.Context(), bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := LifecycleConfiguration{Rules: make([]LifecycleRule, len(rules))}
+	for i, rule := range rules {
+		status := "Disabled"
+		if rule.Enabled {
+			status = "Enabled"
+		}
+		result.Rules[i] = LifecycleRule{
+			ID:     rule.ID,
+			Filter: &LifecycleFilter{Prefix: rule.Prefix},
+			Status: status,
+			AbortIncompleteMultipartUpload: &AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: rule.AbortIncompleteUploadDays,
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) DeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	if err := h.storage.DeleteBucketLifecycle(r.Context(), bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
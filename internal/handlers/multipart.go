@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/alexerm/porterfs/internal/s3err"
 	"github.com/alexerm/porterfs/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
@@ -35,30 +36,60 @@ type CompleteMultipartUploadResult struct {
 	ETag     string   `xml:"ETag"`
 }
 
+type ListPartsResult struct {
+	XMLName              xml.Name        `xml:"ListPartsResult"`
+	Bucket               string          `xml:"Bucket"`
+	Key                  string          `xml:"Key"`
+	UploadID             string          `xml:"UploadId"`
+	PartNumberMarker     int             `xml:"PartNumberMarker"`
+	NextPartNumberMarker int             `xml:"NextPartNumberMarker,omitempty"`
+	MaxParts             int             `xml:"MaxParts"`
+	IsTruncated          bool            `xml:"IsTruncated"`
+	Parts                []ListPartEntry `xml:"Part"`
+}
+
+type ListPartEntry struct {
+	PartNumber   int       `xml:"PartNumber"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
 type ListMultipartUploadsResult struct {
-	XMLName xml.Name                   `xml:"ListMultipartUploadsResult"`
-	Bucket  string                     `xml:"Bucket"`
-	Uploads []ListMultipartUploadEntry `xml:"Upload"`
+	XMLName            xml.Name                   `xml:"ListMultipartUploadsResult"`
+	Bucket             string                     `xml:"Bucket"`
+	KeyMarker          string                     `xml:"KeyMarker"`
+	UploadIDMarker     string                     `xml:"UploadIdMarker"`
+	NextKeyMarker      string                     `xml:"NextKeyMarker,omitempty"`
+	NextUploadIDMarker string                     `xml:"NextUploadIdMarker,omitempty"`
+	Prefix             string                     `xml:"Prefix"`
+	Delimiter          string                     `xml:"Delimiter,omitempty"`
+	MaxUploads         int                        `xml:"MaxUploads"`
+	IsTruncated        bool                       `xml:"IsTruncated"`
+	Uploads            []ListMultipartUploadEntry `xml:"Upload"`
+	CommonPrefixes     []CommonPrefix             `xml:"CommonPrefixes"`
 }
 
 type ListMultipartUploadEntry struct {
-	Key       string    `xml:"Key"`
-	UploadID  string    `xml:"UploadId"`
-	Initiated time.Time `xml:"Initiated"`
+	Key          string    `xml:"Key"`
+	UploadID     string    `xml:"UploadId"`
+	Initiator    Owner     `xml:"Initiator"`
+	Owner        Owner     `xml:"Owner"`
+	StorageClass string    `xml:"StorageClass"`
+	Initiated    time.Time `xml:"Initiated"`
 }
 
 func (h *Handler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 
-	if bucket == "" || object == "" {
-		http.Error(w, "bucket and object required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
 		return
 	}
 
-	uploadID, err := h.storage.InitMultipartUpload(r.Context(), bucket, object)
+	uploadID, err := h.storage.InitMultipartUpload(r.Context(), bucket, object, objectMetadataFromRequest(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -74,18 +105,22 @@ func (h *Handler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Request
 
 func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 	uploadID := r.URL.Query().Get("uploadId")
 	partNumberStr := r.URL.Query().Get("partNumber")
 
-	if bucket == "" || object == "" || uploadID == "" || partNumberStr == "" {
-		http.Error(w, "bucket, object, uploadId, and partNumber required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
+		return
+	}
+
+	if uploadID == "" || partNumberStr == "" {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
 		return
 	}
 
 	partNumber, err := strconv.Atoi(partNumberStr)
 	if err != nil {
-		http.Error(w, "invalid partNumber", http.StatusBadRequest)
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
 		return
 	}
 
@@ -97,29 +132,49 @@ func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	etag, err := h.storage.UploadPart(r.Context(), bucket, object, uploadID, partNumber, r.Body, contentLength)
+	body, err := verifiedPayload(r, true)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	etag, err := h.storage.UploadPart(r.Context(), bucket, object, uploadID, partNumber, body, contentLength)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", quoteETag(etag))
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 	uploadID := r.URL.Query().Get("uploadId")
 
-	if bucket == "" || object == "" || uploadID == "" {
-		http.Error(w, "bucket, object, and uploadId required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
+		return
+	}
+
+	if uploadID == "" {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
+		return
+	}
+
+	body, err := readPayload(r, maxXMLBodySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body) > maxXMLBodySize {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
 		return
 	}
 
 	var req CompleteMultipartUploadRequest
-	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
 		return
 	}
 
@@ -132,9 +187,9 @@ func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	err := h.storage.CompleteMultipartUpload(r.Context(), bucket, object, uploadID, parts)
+	info, err := h.storage.CompleteMultipartUpload(r.Context(), bucket, object, uploadID, parts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -142,58 +197,167 @@ func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request
 		Location: "/" + bucket + "/" + object,
 		Bucket:   bucket,
 		Key:      object,
-		ETag:     "\"" + uploadID + "\"", // Simplified ETag
+		ETag:     quoteETag(info.ETag),
 	}
 
+	setVersionHeaders(w, info)
+
 	w.Header().Set("Content-Type", "application/xml")
 	xml.NewEncoder(w).Encode(result)
 }
 
 func (h *Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
-	object := chi.URLParam(r, "object")
+	object := ObjectKey(r)
 	uploadID := r.URL.Query().Get("uploadId")
 
-	if bucket == "" || object == "" || uploadID == "" {
-		http.Error(w, "bucket, object, and uploadId required", http.StatusBadRequest)
+	if !validObject(w, r, bucket, object) {
+		return
+	}
+
+	if uploadID == "" {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
 		return
 	}
 
 	err := h.storage.AbortMultipartUpload(r.Context(), bucket, object, uploadID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *Handler) ListMultipartUploads(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ListParts(w http.ResponseWriter, r *http.Request) {
 	bucket := chi.URLParam(r, "bucket")
+	object := ObjectKey(r)
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	markerStr := query.Get("part-number-marker")
+	maxPartsStr := query.Get("max-parts")
+
+	if !validObject(w, r, bucket, object) {
+		return
+	}
 
-	if bucket == "" {
-		http.Error(w, "bucket required", http.StatusBadRequest)
+	if uploadID == "" {
+		s3err.Write(w, r, s3err.ErrInvalidArgument)
 		return
 	}
 
-	uploads, err := h.storage.ListMultipartUploads(r.Context(), bucket)
+	partNumberMarker := 0
+	if markerStr != "" {
+		marker, err := strconv.Atoi(markerStr)
+		if err != nil || marker < 0 {
+			s3err.Write(w, r, s3err.ErrInvalidArgument)
+			return
+		}
+		partNumberMarker = marker
+	}
+
+	// S3 never returns more than 1000 parts per page
+	maxParts := 1000
+	if maxPartsStr != "" {
+		if mp, err := strconv.Atoi(maxPartsStr); err == nil && mp > 0 && mp < maxParts {
+			maxParts = mp
+		}
+	}
+
+	listing, err := h.storage.ListParts(r.Context(), bucket, object, uploadID, partNumberMarker, maxParts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	result := ListPartsResult{
+		Bucket:           bucket,
+		Key:              object,
+		UploadID:         uploadID,
+		PartNumberMarker: partNumberMarker,
+		MaxParts:         maxParts,
+		IsTruncated:      listing.IsTruncated,
+		Parts:            make([]ListPartEntry, len(listing.Parts)),
+	}
+	if listing.IsTruncated {
+		result.NextPartNumberMarker = listing.NextPartNumberMarker
+	}
+
+	for i, part := range listing.Parts {
+		result.Parts[i] = ListPartEntry{
+			PartNumber:   part.PartNumber,
+			LastModified: part.LastModified,
+			ETag:         quoteETag(part.ETag),
+			Size:         part.Size,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) ListMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+
+	if !validBucket(w, r, bucket) {
 		return
 	}
 
-	entries := make([]ListMultipartUploadEntry, len(uploads))
-	for i, upload := range uploads {
-		entries[i] = ListMultipartUploadEntry{
-			Key:       upload.Key,
-			UploadID:  upload.UploadID,
-			Initiated: upload.Initiated,
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	uploadIDMarker := query.Get("upload-id-marker")
+	maxUploadsStr := query.Get("max-uploads")
+
+	// S3 ignores upload-id-marker unless key-marker is also given
+	if keyMarker == "" {
+		uploadIDMarker = ""
+	}
+
+	// S3 never returns more than 1000 uploads per page
+	maxUploads := 1000
+	if maxUploadsStr != "" {
+		mu, err := strconv.Atoi(maxUploadsStr)
+		if err != nil || mu < 0 {
+			s3err.Write(w, r, s3err.ErrInvalidArgument)
+			return
+		}
+		if mu < maxUploads {
+			maxUploads = mu
 		}
 	}
 
+	listing, err := h.storage.ListMultipartUploads(r.Context(), bucket, prefix, delimiter, keyMarker, uploadIDMarker, maxUploads)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	result := ListMultipartUploadsResult{
-		Bucket:  bucket,
-		Uploads: entries,
+		Bucket:             bucket,
+		KeyMarker:          keyMarker,
+		UploadIDMarker:     uploadIDMarker,
+		NextKeyMarker:      listing.NextKeyMarker,
+		NextUploadIDMarker: listing.NextUploadIDMarker,
+		Prefix:             prefix,
+		Delimiter:          delimiter,
+		MaxUploads:         maxUploads,
+		IsTruncated:        listing.IsTruncated,
+		Uploads:            make([]ListMultipartUploadEntry, len(listing.Uploads)),
+		CommonPrefixes:     commonPrefixes(listing.CommonPrefixes),
+	}
+
+	owner := Owner{ID: "porter", DisplayName: "porter"}
+	for i, upload := range listing.Uploads {
+		result.Uploads[i] = ListMultipartUploadEntry{
+			Key:          upload.Key,
+			UploadID:     upload.UploadID,
+			Initiator:    owner,
+			Owner:        owner,
+			StorageClass: "STANDARD",
+			Initiated:    upload.Initiated,
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/xml")
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+)
+
+// maxXMLBodySize bounds the XML documents the handlers read into memory:
+// configurations and the part list of CompleteMultipartUpload.
+const maxXMLBodySize = 2 << 20
+
+// payloadReader hashes a request body as it is streamed to storage and
+// fails the final read when the body does not match the digests the client
+// declared. Storage discards a staged write whose reader fails, so a
+// mismatched body is never committed.
+type payloadReader struct {
+	reader     io.Reader
+	sha256     hash.Hash
+	wantSHA256 []byte
+	md5        hash.Hash
+	wantMD5    []byte
+}
+
+// verifiedPayload returns the body of r wrapped to verify X-Amz-Content-Sha256
+// and, when checkMD5 is set, Content-MD5. Unsigned and streaming payloads
+// are not hashed here.
+func verifiedPayload(r *http.Request, checkMD5 bool) (io.Reader, error) {
+	p := &payloadReader{reader: r.Body}
+
+	contentSHA256 := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case contentSHA256 == "", contentSHA256 == "UNSIGNED-PAYLOAD", strings.HasPrefix(contentSHA256, "STREAMING-"):
+	default:
+		want, err := hex.DecodeString(contentSHA256)
+		if err != nil || len(want) != sha256.Size {
+			return nil, s3err.ErrInvalidArgument
+		}
+		p.sha256, p.wantSHA256 = sha256.New(), want
+	}
+
+	if contentMD5 := r.Header.Get("Content-MD5"); checkMD5 && contentMD5 != "" {
+		want, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(want) != md5.Size {
+			return nil, storage.ErrInvalidDigest
+		}
+		p.md5, p.wantMD5 = md5.New(), want
+	}
+
+	if p.sha256 == nil && p.md5 == nil {
+		return r.Body, nil
+	}
+	return p, nil
+}
+
+// readPayload reads up to limit+1 bytes of the verified body of r, so that
+// callers can reject oversized bodies by length. A body that fits is read to
+// the end and its X-Amz-Content-Sha256 checked before it is returned.
+func readPayload(r *http.Request, limit int64) ([]byte, error) {
+	payload, err := verifiedPayload(r, false)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(payload, limit+1))
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if p.sha256 != nil {
+		p.sha256.Write(b[:n])
+	}
+	if p.md5 != nil {
+		p.md5.Write(b[:n])
+	}
+
+	if err == io.EOF {
+		if p.sha256 != nil && string(p.sha256.Sum(nil)) != string(p.wantSHA256) {
+			return n, s3err.ErrXAmzContentSHA256Mismatch
+		}
+		if p.md5 != nil && string(p.md5.Sum(nil)) != string(p.wantMD5) {
+			return n, storage.ErrBadDigest
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/alexerm/porterfs/internal/policy"
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPolicySize is the S3 limit on the size of a bucket policy.
+const maxPolicySize = 20 << 10
+
+func (h *Handler) PutBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	data, err := readPayload(r, maxPolicySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(data) > maxPolicySize {
+		s3err.Write(w, r, s3err.ErrMalformedPolicy)
+		return
+	}
+	if _, err := policy.Parse(data, bucket); err != nil {
+		log.Printf("DEBUG: Rejecting policy for bucket %s: %v", bucket, err)
+		s3err.Write(w, r, s3err.ErrMalformedPolicy)
+		return
+	}
+
+	if err := h.storage.PutBucketPolicy(r.Context(), bucket, data); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	data, err := h.storage.GetBucketPolicy(r.Context(), bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *Handler) DeleteBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	if err := h.storage.DeleteBucketPolicy(r.Context(), bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/s3err"
+	"github.com/alexerm/porterfs/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name            `xml:"ListVersionsResult"`
+	Name                string              `xml:"Name"`
+	Prefix              string              `xml:"Prefix"`
+	KeyMarker           string              `xml:"KeyMarker"`
+	VersionIDMarker     string              `xml:"VersionIdMarker"`
+	NextKeyMarker       string              `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string              `xml:"NextVersionIdMarker,omitempty"`
+	Delimiter           string              `xml:"Delimiter,omitempty"`
+	MaxKeys             int                 `xml:"MaxKeys"`
+	IsTruncated         bool                `xml:"IsTruncated"`
+	Versions            []ObjectVersion     `xml:"Version"`
+	DeleteMarkers       []DeleteMarkerEntry `xml:"DeleteMarker"`
+	CommonPrefixes      []CommonPrefix      `xml:"CommonPrefixes"`
+}
+
+type ObjectVersion struct {
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type DeleteMarkerEntry struct {
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+func (h *Handler) PutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	body, err := readPayload(r, maxXMLBodySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body) > maxXMLBodySize {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	var req VersioningConfiguration
+	if err := xml.Unmarshal(body, &req); err != nil {
+		s3err.Write(w, r, s3err.ErrMalformedXML)
+		return
+	}
+
+	if err := h.storage.PutBucketVersioning(r.Context(), bucket, req.Status); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	status, err := h.storage.GetBucketVersioning(r.Context(), bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(VersioningConfiguration{Status: status})
+}
+
+func (h *Handler) ListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	bucket := chi.URLParam(r, "bucket")
+	if !validBucket(w, r, bucket) {
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	maxKeysStr := query.Get("max-keys")
+
+	maxKeys := 1000
+	if maxKeysStr != "" {
+		if mk, err := strconv.Atoi(maxKeysStr); err == nil && mk > 0 {
+			maxKeys = mk
+		}
+	}
+
+	listing, err := h.storage.ListObjectVersions(r.Context(), bucket, prefix, delimiter, keyMarker, versionIDMarker, maxKeys)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := ListVersionsResult{
+		Name:                bucket,
+		Prefix:              prefix,
+		KeyMarker:           keyMarker,
+		VersionIDMarker:     versionIDMarker,
+		NextKeyMarker:       listing.NextKeyMarker,
+		NextVersionIDMarker: listing.NextVersionIDMarker,
+		Delimiter:           delimiter,
+		MaxKeys:             maxKeys,
+		IsTruncated:         listing.IsTruncated,
+		CommonPrefixes:      commonPrefixes(listing.CommonPrefixes),
+	}
+
+	for _, version := range listing.Versions {
+		if version.DeleteMarker {
+			result.DeleteMarkers = append(result.DeleteMarkers, DeleteMarkerEntry{
+				Key:          version.Key,
+				VersionID:    versionIDString(version.VersionID),
+				IsLatest:     version.IsLatest,
+				LastModified: version.LastModified,
+			})
+			continue
+		}
+		result.Versions = append(result.Versions, ObjectVersion{
+			Key:          version.Key,
+			VersionID:    versionIDString(version.VersionID),
+			IsLatest:     version.IsLatest,
+			LastModified: version.LastModified,
+			ETag:         quoteETag(version.ETag),
+			Size:         version.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// versionIDString renders a stored version ID, in which the null version
+// is empty, the way S3 reports it.
+func versionIDString(versionID string) string {
+	if versionID == "" {
+		return storage.NullVersionID
+	}
+	return versionID
+}
+
+// setVersionHeaders reports which version a request read, wrote or
+// deleted. Objects without a version ID (the null version) get no
+// x-amz-version-id header.
+func setVersionHeaders(w http.ResponseWriter, info *storage.ObjectInfo) {
+	if info.VersionID != "" {
+		w.Header().Set("x-amz-version-id", info.VersionID)
+	}
+	if info.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+}
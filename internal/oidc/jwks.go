@@ -0,0 +1,246 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksRefreshInterval is how long keys fetched from a URL are used
+	// before they are fetched again
+	jwksRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval limits how often tokens signed by unknown keys
+	// can make the key set be fetched again
+	jwksMinRefreshInterval = time.Minute
+
+	// maxJWKSSize bounds the key sets read from files and URLs
+	maxJWKSSize = 1 << 20
+)
+
+// jsonWebKey is a public key in a JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// Elliptic curve keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key as an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// parseJWKS returns the signing keys in a JSON Web Key Set. Keys that are
+// not for signatures or of unsupported types are skipped.
+func parseJWKS(data []byte) ([]jsonWebKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]jsonWebKey, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if _, err := key.publicKey(); err != nil {
+			log.Printf("WARN: Skipping JWKS key %q: %v", key.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// keySet holds the provider's signing keys. Keys from a file are reloaded
+// when its modification time or size changes; keys from a URL are fetched
+// again every jwksRefreshInterval and when a token names an unknown key.
+// The last good set is kept if a reload fails.
+type keySet struct {
+	file   string
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	keys    []jsonWebKey
+	modTime time.Time
+	size    int64
+	fetched time.Time
+}
+
+// find returns the key with id kid, or the only key if kid is empty.
+func (s *keySet) find(ctx context.Context, kid string) (*jsonWebKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != "" {
+		s.reloadFile()
+	} else if s.now().Sub(s.fetched) >= jwksRefreshInterval {
+		s.fetch(ctx)
+	}
+
+	key := s.lookup(kid)
+	if key == nil && s.url != "" && s.now().Sub(s.fetched) >= jwksMinRefreshInterval {
+		// The provider may have rotated its keys
+		s.fetch(ctx)
+		key = s.lookup(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (s *keySet) lookup(kid string) *jsonWebKey {
+	if kid == "" {
+		if len(s.keys) == 1 {
+			return &s.keys[0]
+		}
+		return nil
+	}
+	for i := range s.keys {
+		if s.keys[i].Kid == kid {
+			return &s.keys[i]
+		}
+	}
+	return nil
+}
+
+// reloadFile re-reads the key file if it changed since it was last read.
+func (s *keySet) reloadFile() {
+	stat, err := os.Stat(s.file)
+	if err != nil {
+		log.Printf("ERROR: Failed to stat JWKS file %s: %v", s.file, err)
+		return
+	}
+	if stat.ModTime().Equal(s.modTime) && stat.Size() == s.size {
+		return
+	}
+	// Keep the previous keys until the file changes again
+	s.modTime = stat.ModTime()
+	s.size = stat.Size()
+
+	if stat.Size() > maxJWKSSize {
+		log.Printf("ERROR: JWKS file %s is larger than %d bytes", s.file, maxJWKSSize)
+		return
+	}
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		log.Printf("ERROR: Failed to read JWKS file %s: %v", s.file, err)
+		return
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse JWKS file %s: %v", s.file, err)
+		return
+	}
+	s.keys = keys
+	log.Printf("INFO: Loaded %d signing keys from %s", len(keys), s.file)
+}
+
+// fetch downloads the key set from its URL.
+func (s *keySet) fetch(ctx context.Context) {
+	s.fetched = s.now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		log.Printf("ERROR: Invalid JWKS URL %s: %v", s.url, err)
+		return
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("ERROR: Failed to fetch JWKS from %s: %v", s.url, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("ERROR: Failed to fetch JWKS from %s: %s", s.url, resp.Status)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		log.Printf("ERROR: Failed to fetch JWKS from %s: %v", s.url, err)
+		return
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse JWKS from %s: %v", s.url, err)
+		return
+	}
+	s.keys = keys
+	log.Printf("INFO: Fetched %d signing keys from %s", len(keys), s.url)
+}
@@ -0,0 +1,260 @@
+// Package oidc verifies the ID tokens of an OpenID Connect provider, which
+// AssumeRoleWithWebIdentity exchanges for temporary credentials. Tokens are
+// JWTs signed with RS256, RS384, RS512, ES256, ES384 or ES512 by a key in
+// the provider's JSON Web Key Set.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/policy"
+)
+
+// clockLeeway is how far the provider's clock may be off when checking the
+// times in a token.
+const clockLeeway = time.Minute
+
+var (
+	// ErrInvalidToken wraps every reason a token is rejected other than
+	// expiry.
+	ErrInvalidToken = errors.New("invalid web identity token")
+	ErrExpiredToken = errors.New("web identity token has expired")
+)
+
+// signingHashes maps the supported JWS algorithms to their hash functions.
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// Subject returns the sub claim, which identifies the token's holder.
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// values returns the string forms of claim name: a string, number or
+// boolean yields one value and a list of them one per element.
+func (c Claims) values(name string) []string {
+	items, ok := c[name].([]interface{})
+	if !ok {
+		items = []interface{}{c[name]}
+	}
+
+	var values []string
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			values = append(values, item)
+		case json.Number, bool:
+			values = append(values, fmt.Sprint(item))
+		}
+	}
+	return values
+}
+
+// numericDate returns the NumericDate claim name.
+func (c Claims) numericDate(name string) (time.Time, bool) {
+	number, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// Verifier checks tokens against the provider in an OIDCConfig.
+type Verifier struct {
+	issuer   string
+	audience string
+	keys     *keySet
+	now      func() time.Time
+}
+
+// NewVerifier returns a verifier for the provider in cfg, or nil if none is
+// configured.
+func NewVerifier(cfg config.OIDCConfig) *Verifier {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	v := &Verifier{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}
+	v.keys = &keySet{
+		file:   cfg.JWKSFile,
+		url:    cfg.JWKSURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    func() time.Time { return v.now() },
+	}
+	return v
+}
+
+// Verify checks the signature, issuer, audience and validity period of
+// token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a signed JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %v", ErrInvalidToken, err)
+	}
+	hash, ok := signingHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := v.keys.find(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return nil, fmt.Errorf("%w: key %q is not for %s", ErrInvalidToken, key.Kid, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrInvalidToken)
+	}
+	if err := verifySignature(key, header.Alg, hash, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature checks the JWS signature of signed with key.
+func verifySignature(key *jsonWebKey, alg string, hash crypto.Hash, signed string, signature []byte) error {
+	pub, err := key.publicKey()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] == "RS" && rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// ES signatures are the big-endian r and s, each padded to the
+		// curve's size
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(pub, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+}
+
+func (v *Verifier) validateClaims(claims Claims) error {
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return fmt.Errorf("%w: issuer %q is not trusted", ErrInvalidToken, iss)
+	}
+
+	audienceMatched := false
+	for _, aud := range claims.values("aud") {
+		if aud == v.audience {
+			audienceMatched = true
+			break
+		}
+	}
+	if !audienceMatched {
+		return fmt.Errorf("%w: token is not for audience %q", ErrInvalidToken, v.audience)
+	}
+
+	if claims.Subject() == "" {
+		return fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	now := v.now()
+	exp, ok := claims.numericDate("exp")
+	if !ok {
+		return fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(clockLeeway)) {
+		return ErrExpiredToken
+	}
+	if nbf, ok := claims.numericDate("nbf"); ok && now.Add(clockLeeway).Before(nbf) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if iat, ok := claims.numericDate("iat"); ok && now.Add(clockLeeway).Before(iat) {
+		return fmt.Errorf("%w: token was issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWT, keeping
+// numbers as json.Number.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// MatchRole reports whether claims satisfy every claim pattern of role.
+func MatchRole(role *config.OIDCRoleConfig, claims Claims) bool {
+	for name, pattern := range role.Claims {
+		matched := false
+		for _, value := range claims.values(name) {
+			if policy.WildcardMatch(pattern, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
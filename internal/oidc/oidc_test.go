@@ -0,0 +1,240 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/config"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "porterfs"
+)
+
+// signToken returns a JWT with claims signed by key.
+func signToken(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+
+	hash := signingHashes[alg]
+	h := hash.New()
+	h.Write([]byte(signed))
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		// Convert the ASN.1 signature to the fixed-size JWS form
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			t.Fatal(err)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		sig.R.FillBytes(signature[:size])
+		sig.S.FillBytes(signature[size:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwksFor(keys map[string]crypto.PublicKey) []byte {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: encode(key.X.Bytes()), Y: encode(key.Y.Bytes())})
+		}
+	}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "porter-oidc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	jwksFile := filepath.Join(tmpDir, "jwks.json")
+	if err := os.WriteFile(jwksFile, jwksFor(map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier(config.OIDCConfig{Issuer: testIssuer, Audience: testAudience, JWKSFile: jwksFile})
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": testIssuer,
+			"aud": testAudience,
+			"sub": "repo:acme/app:ref:refs/heads/main",
+			"iat": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(5 * time.Minute).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"RS256", signToken(t, rsaKey, "RS256", "rsa", claims(nil)), nil},
+		{"RS512", signToken(t, rsaKey, "RS512", "rsa", claims(nil)), nil},
+		{"ES256", signToken(t, ecKey, "ES256", "ec", claims(nil)), nil},
+		{"AudienceList", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"aud": []string{"other", testAudience}})), nil},
+		{"WrongKey", signToken(t, otherKey, "RS256", "rsa", claims(nil)), ErrInvalidToken},
+		{"UnknownKey", signToken(t, rsaKey, "RS256", "missing", claims(nil)), ErrInvalidToken},
+		{"KeyTypeMismatch", signToken(t, rsaKey, "RS256", "ec", claims(nil)), ErrInvalidToken},
+		{"WrongIssuer", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.example.com"})), ErrInvalidToken},
+		{"WrongAudience", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"aud": "other"})), ErrInvalidToken},
+		{"MissingSubject", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"sub": nil})), ErrInvalidToken},
+		{"MissingExpiry", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})), ErrInvalidToken},
+		{"Expired", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), ErrExpiredToken},
+		{"NotYetValid", signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), ErrInvalidToken},
+		{"Unsigned", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0.", ErrInvalidToken},
+		{"Garbage", "not-a-token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(context.Background(), tt.token)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Expected token to verify, got %v", err)
+				}
+				if got.Subject() != "repo:acme/app:ref:refs/heads/main" {
+					t.Errorf("Expected subject from token, got %q", got.Subject())
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestJWKSURL(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := jwksFor(map[string]crypto.PublicKey{"old": &oldKey.PublicKey})
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	verifier := NewVerifier(config.OIDCConfig{Issuer: testIssuer, Audience: testAudience, JWKSURL: server.URL})
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+	token := func(key *rsa.PrivateKey, kid string) string {
+		return signToken(t, key, "RS256", kid, map[string]interface{}{
+			"iss": testIssuer, "aud": testAudience, "sub": "user", "exp": now.Add(time.Hour).Unix(),
+		})
+	}
+
+	if _, err := verifier.Verify(context.Background(), token(oldKey, "old")); err != nil {
+		t.Fatalf("Expected token to verify, got %v", err)
+	}
+	if _, err := verifier.Verify(context.Background(), token(oldKey, "old")); err != nil || fetches != 1 {
+		t.Errorf("Expected cached keys to be reused, got %d fetches (%v)", fetches, err)
+	}
+
+	// The provider rotates its keys
+	jwks = jwksFor(map[string]crypto.PublicKey{"new": &newKey.PublicKey})
+	if _, err := verifier.Verify(context.Background(), token(newKey, "new")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected unknown keys to be refetched at most once a minute, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := verifier.Verify(context.Background(), token(newKey, "new")); err != nil {
+		t.Errorf("Expected rotated key to be fetched, got %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetches)
+	}
+}
+
+func TestMatchRole(t *testing.T) {
+	role := &config.OIDCRoleConfig{
+		Name: "deploy",
+		Claims: map[string]string{
+			"sub":    "repo:acme/*:ref:refs/heads/main",
+			"groups": "deployers",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		claims Claims
+		want   bool
+	}{
+		{"Match", Claims{"sub": "repo:acme/app:ref:refs/heads/main", "groups": []interface{}{"dev", "deployers"}}, true},
+		{"WrongBranch", Claims{"sub": "repo:acme/app:ref:refs/heads/feature", "groups": []interface{}{"deployers"}}, false},
+		{"MissingGroup", Claims{"sub": "repo:acme/app:ref:refs/heads/main", "groups": []interface{}{"dev"}}, false},
+		{"MissingClaim", Claims{"sub": "repo:acme/app:ref:refs/heads/main"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchRole(role, tt.claims); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"net"
+	"strings"
+)
+
+// Condition keys PorterFS sets on a Request.
+const (
+	KeySourceIP        = "aws:sourceip"
+	KeySecureTransport = "aws:securetransport"
+	KeyUsername        = "aws:username"
+	KeyPrefix          = "s3:prefix"
+	KeyDelimiter       = "s3:delimiter"
+	KeyVersionID       = "s3:versionid"
+)
+
+var conditionKeys = map[string]bool{
+	KeySourceIP:        true,
+	KeySecureTransport: true,
+	KeyUsername:        true,
+	KeyPrefix:          true,
+	KeyDelimiter:       true,
+	KeyVersionID:       true,
+}
+
+// conditionFunc reports whether a request value satisfies a condition.
+// Positive operators fail when the key is absent; negated ones pass.
+type conditionFunc func(value string, present bool, values []string) bool
+
+var conditionOperators = map[string]conditionFunc{
+	"StringEquals": func(value string, present bool, values []string) bool {
+		return present && anyOf(values, func(v string) bool { return v == value })
+	},
+	"StringNotEquals": func(value string, present bool, values []string) bool {
+		return !present || !anyOf(values, func(v string) bool { return v == value })
+	},
+	"StringEqualsIgnoreCase": func(value string, present bool, values []string) bool {
+		return present && anyOf(values, func(v string) bool { return strings.EqualFold(v, value) })
+	},
+	"StringNotEqualsIgnoreCase": func(value string, present bool, values []string) bool {
+		return !present || !anyOf(values, func(v string) bool { return strings.EqualFold(v, value) })
+	},
+	"StringLike": func(value string, present bool, values []string) bool {
+		return present && anyOf(values, func(v string) bool { return WildcardMatch(v, value) })
+	},
+	"StringNotLike": func(value string, present bool, values []string) bool {
+		return !present || !anyOf(values, func(v string) bool { return WildcardMatch(v, value) })
+	},
+	"IpAddress": func(value string, present bool, values []string) bool {
+		return present && anyOf(values, func(v string) bool { return ipInRange(value, v) })
+	},
+	"NotIpAddress": func(value string, present bool, values []string) bool {
+		return !present || !anyOf(values, func(v string) bool { return ipInRange(value, v) })
+	},
+	"Bool": func(value string, present bool, values []string) bool {
+		return present && anyOf(values, func(v string) bool { return strings.EqualFold(v, value) })
+	},
+}
+
+func anyOf(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipInRange reports whether ip lies in cidr, which may also be a single
+// address.
+func ipInRange(ip, cidr string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if !strings.Contains(cidr, "/") {
+		other := net.ParseIP(cidr)
+		return other != nil && other.Equal(addr)
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return err == nil && network.Contains(addr)
+}
@@ -0,0 +1,315 @@
+// Package policy parses S3 bucket policies and the session policies of
+// temporary credentials, and evaluates requests against them. Only the
+// subset PorterFS can enforce is accepted: s3 actions, resources in the
+// policy's own bucket, principals naming PorterFS users and the conditions
+// listed in conditionKeys.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	EffectAllow = "Allow"
+	EffectDeny  = "Deny"
+
+	// resourcePrefix starts every S3 resource ARN.
+	resourcePrefix = "arn:aws:s3:::"
+)
+
+// Decision is the outcome of evaluating a request against a policy.
+type Decision int
+
+const (
+	// NotApplicable means no statement matched the request.
+	NotApplicable Decision = iota
+	Allow
+	Deny
+)
+
+// ErrMalformedPolicy wraps every reason a policy document is rejected.
+var ErrMalformedPolicy = errors.New("malformed policy")
+
+// Policy is a parsed bucket or session policy document.
+type Policy struct {
+	Version   string      `json:"Version,omitempty"`
+	ID        string      `json:"Id,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement is a single policy statement. Session policy statements have
+// no Principal and apply to the session they were issued for.
+type Statement struct {
+	Sid       string                          `json:"Sid,omitempty"`
+	Effect    string                          `json:"Effect"`
+	Principal *Principal                      `json:"Principal"`
+	Action    valueList                       `json:"Action"`
+	Resource  valueList                       `json:"Resource"`
+	Condition map[string]map[string]valueList `json:"Condition,omitempty"`
+}
+
+// Principal names the users a statement applies to, either "*" or
+// {"AWS": [...]} with user names, IAM user ARNs or "*".
+type Principal struct {
+	AWS valueList
+}
+
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("%w: principal must be \"*\" or an object", ErrMalformedPolicy)
+		}
+		p.AWS = valueList{"*"}
+		return nil
+	}
+
+	var principals map[string]valueList
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return fmt.Errorf("%w: invalid principal", ErrMalformedPolicy)
+	}
+	for kind, values := range principals {
+		if kind != "AWS" {
+			return fmt.Errorf("%w: unsupported principal type %q", ErrMalformedPolicy, kind)
+		}
+		p.AWS = values
+	}
+	return nil
+}
+
+// valueList is a JSON string or array of strings. Booleans and numbers are
+// accepted as their string form, as condition values often use them.
+type valueList []string
+
+func (v *valueList) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+	*v = make(valueList, 0, len(items))
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			*v = append(*v, item)
+		case bool, float64:
+			*v = append(*v, fmt.Sprint(item))
+		default:
+			return fmt.Errorf("%w: expected a string or list of strings", ErrMalformedPolicy)
+		}
+	}
+	return nil
+}
+
+// Parse decodes and validates the policy document of bucket.
+func Parse(data []byte, bucket string) (*Policy, error) {
+	return parse(data, bucket)
+}
+
+// ParseSessionPolicy decodes and validates the policy attached to temporary
+// credentials. It may name resources in any bucket but no principals.
+func ParseSessionPolicy(data []byte) (*Policy, error) {
+	return parse(data, "")
+}
+
+// parse decodes a bucket policy, or a session policy when bucket is empty.
+func parse(data []byte, bucket string) (*Policy, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return nil, fmt.Errorf("%w: policies must be JSON objects", ErrMalformedPolicy)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var p Policy
+	if err := decoder.Decode(&p); err != nil {
+		if errors.Is(err, ErrMalformedPolicy) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPolicy, err)
+	}
+
+	if p.Version != "" && p.Version != "2012-10-17" && p.Version != "2008-10-17" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrMalformedPolicy, p.Version)
+	}
+	if len(p.Statement) == 0 {
+		return nil, fmt.Errorf("%w: missing statement", ErrMalformedPolicy)
+	}
+	for i := range p.Statement {
+		if err := p.Statement[i].validate(bucket); err != nil {
+			return nil, fmt.Errorf("%w: statement %d: %v", ErrMalformedPolicy, i, err)
+		}
+	}
+
+	return &p, nil
+}
+
+func (s *Statement) validate(bucket string) error {
+	if s.Effect != EffectAllow && s.Effect != EffectDeny {
+		return fmt.Errorf("invalid effect %q", s.Effect)
+	}
+	if bucket == "" {
+		if s.Principal != nil {
+			return errors.New("session policies cannot name a principal")
+		}
+	} else if s.Principal == nil || len(s.Principal.AWS) == 0 {
+		return errors.New("missing principal")
+	}
+	if len(s.Action) == 0 {
+		return errors.New("missing action")
+	}
+	for _, action := range s.Action {
+		if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+			return fmt.Errorf("unsupported action %q", action)
+		}
+	}
+	if len(s.Resource) == 0 {
+		return errors.New("missing resource")
+	}
+	for _, resource := range s.Resource {
+		name, ok := strings.CutPrefix(resource, resourcePrefix)
+		if !ok {
+			return fmt.Errorf("invalid resource %q", resource)
+		}
+		// A bucket policy may only grant access to its own bucket
+		if name, _, _ = strings.Cut(name, "/"); bucket != "" && name != bucket {
+			return fmt.Errorf("resource %q is outside bucket %s", resource, bucket)
+		}
+	}
+	for operator, conditions := range s.Condition {
+		if _, ok := conditionOperators[operator]; !ok {
+			return fmt.Errorf("unsupported condition operator %q", operator)
+		}
+		for key := range conditions {
+			if !conditionKeys[strings.ToLower(key)] {
+				return fmt.Errorf("unsupported condition key %q", key)
+			}
+		}
+	}
+	return nil
+}
+
+// Request describes an S3 request for evaluation.
+type Request struct {
+	// User is the name of the authenticated user.
+	User   string
+	Action string
+	Bucket string
+	// Key is empty for bucket-level actions.
+	Key string
+	// Conditions holds the condition key values of the request, keyed by
+	// lower-case condition key. Absent keys are left out.
+	Conditions map[string]string
+}
+
+// Resource returns the ARN of the bucket or object the request is for.
+func (r *Request) Resource() string {
+	if r.Key == "" {
+		return resourcePrefix + r.Bucket
+	}
+	return resourcePrefix + r.Bucket + "/" + r.Key
+}
+
+// Evaluate returns Deny if any matching statement denies the request, Allow
+// if one allows it and NotApplicable otherwise.
+func (p *Policy) Evaluate(req *Request) Decision {
+	decision := NotApplicable
+	for i := range p.Statement {
+		s := &p.Statement[i]
+		if !s.matches(req) {
+			continue
+		}
+		if s.Effect == EffectDeny {
+			return Deny
+		}
+		decision = Allow
+	}
+	return decision
+}
+
+func (s *Statement) matches(req *Request) bool {
+	if !s.matchesPrincipal(req.User) {
+		return false
+	}
+
+	actionMatched := false
+	for _, action := range s.Action {
+		// Action names are case-insensitive, resources are not
+		if WildcardMatch(strings.ToLower(action), strings.ToLower(req.Action)) {
+			actionMatched = true
+			break
+		}
+	}
+	if !actionMatched {
+		return false
+	}
+
+	resourceMatched := false
+	resource := req.Resource()
+	for _, pattern := range s.Resource {
+		if WildcardMatch(pattern, resource) {
+			resourceMatched = true
+			break
+		}
+	}
+	if !resourceMatched {
+		return false
+	}
+
+	for operator, conditions := range s.Condition {
+		for key, values := range conditions {
+			value, present := req.Conditions[strings.ToLower(key)]
+			if !conditionOperators[operator](value, present, values) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Statement) matchesPrincipal(user string) bool {
+	if s.Principal == nil {
+		return true
+	}
+	for _, principal := range s.Principal.AWS {
+		if principal == "*" || principal == user || strings.HasSuffix(principal, ":user/"+user) {
+			return true
+		}
+	}
+	return false
+}
+
+// WildcardMatch reports whether value matches pattern, where * matches any
+// run of characters and ? matches exactly one.
+func WildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
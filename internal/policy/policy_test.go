@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Sid": "ConsumersRead",
+				"Effect": "Allow",
+				"Principal": {"AWS": ["consumer", "arn:aws:iam::000000000000:user/analyst"]},
+				"Action": ["s3:Get*", "s3:ListBucket"],
+				"Resource": ["arn:aws:s3:::data", "arn:aws:s3:::data/*"]
+			},
+			{
+				"Sid": "ProducersWriteIncoming",
+				"Effect": "Allow",
+				"Principal": {"AWS": "producer"},
+				"Action": "s3:PutObject",
+				"Resource": "arn:aws:s3:::data/incoming/*",
+				"Condition": {"IpAddress": {"aws:SourceIp": ["10.0.0.0/8", "192.168.1.7"]}}
+			},
+			{
+				"Sid": "AnalystListsReports",
+				"Effect": "Deny",
+				"Principal": {"AWS": "analyst"},
+				"Action": "s3:ListBucket",
+				"Resource": "arn:aws:s3:::data",
+				"Condition": {"StringNotLike": {"s3:prefix": "reports/*"}}
+			},
+			{
+				"Sid": "NoSecrets",
+				"Effect": "Deny",
+				"Principal": "*",
+				"Action": "s3:*",
+				"Resource": "arn:aws:s3:::data/secret/*"
+			}
+		]
+	}`), "data")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		user       string
+		action     string
+		key        string
+		conditions map[string]string
+		want       Decision
+	}{
+		{"ConsumerReads", "consumer", "s3:GetObject", "a/b.txt", nil, Allow},
+		{"ActionsAreCaseInsensitive", "consumer", "S3:GETOBJECT", "a/b.txt", nil, Allow},
+		{"ConsumerLists", "consumer", "s3:ListBucket", "", nil, Allow},
+		{"ConsumerCannotWrite", "consumer", "s3:PutObject", "incoming/x", nil, NotApplicable},
+		{"IAMUserARN", "analyst", "s3:GetObject", "a.txt", nil, Allow},
+		{"ProducerFromNetwork", "producer", "s3:PutObject", "incoming/x", map[string]string{KeySourceIP: "10.1.2.3"}, Allow},
+		{"ProducerFromSingleAddress", "producer", "s3:PutObject", "incoming/x", map[string]string{KeySourceIP: "192.168.1.7"}, Allow},
+		{"ProducerFromElsewhere", "producer", "s3:PutObject", "incoming/x", map[string]string{KeySourceIP: "203.0.113.9"}, NotApplicable},
+		{"ProducerOutsidePrefix", "producer", "s3:PutObject", "other/x", map[string]string{KeySourceIP: "10.1.2.3"}, NotApplicable},
+		{"ProducerCannotRead", "producer", "s3:GetObject", "incoming/x", nil, NotApplicable},
+		{"AnalystListsReports", "analyst", "s3:ListBucket", "", map[string]string{KeyPrefix: "reports/2024/"}, Allow},
+		{"AnalystListsElsewhere", "analyst", "s3:ListBucket", "", map[string]string{KeyPrefix: "raw/"}, Deny},
+		{"AnalystListsWithoutPrefix", "analyst", "s3:ListBucket", "", nil, Deny},
+		{"DenyWins", "consumer", "s3:GetObject", "secret/key", nil, Deny},
+		{"UnknownUser", "mallory", "s3:GetObject", "a.txt", nil, NotApplicable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Evaluate(&Request{
+				User:       tt.user,
+				Action:     tt.action,
+				Bucket:     "data",
+				Key:        tt.key,
+				Conditions: tt.conditions,
+			})
+			if got != tt.want {
+				t.Errorf("Expected decision %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"Empty":            ``,
+		"Array":            `[]`,
+		"UnknownField":     `{"Statement": [], "Extra": 1}`,
+		"BadVersion":       `{"Version": "2020-01-01", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::data"}]}`,
+		"MissingPrincipal": `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::data"}]}`,
+		"ServicePrincipal": `{"Statement": [{"Effect": "Allow", "Principal": {"Service": "s3.amazonaws.com"}, "Action": "s3:*", "Resource": "arn:aws:s3:::data"}]}`,
+		"MissingResource":  `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*"}]}`,
+		"ForeignResource":  `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::database/*"}]}`,
+		"UnknownKey":       `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::data", "Condition": {"StringEquals": {"aws:Referer": "x"}}}]}`,
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(doc), "data"); !errors.Is(err, ErrMalformedPolicy) {
+				t.Errorf("Expected ErrMalformedPolicy, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "", true},
+		{"arn:aws:s3:::data/*", "arn:aws:s3:::data/a/b", true},
+		{"arn:aws:s3:::data/*", "arn:aws:s3:::data", false},
+		{"arn:aws:s3:::data/*.csv", "arn:aws:s3:::data/x/y.csv", true},
+		{"arn:aws:s3:::data/?.csv", "arn:aws:s3:::data/ab.csv", false},
+		{"reports/*/daily", "reports/2024/01/daily", true},
+		{"reports/*/daily", "reports/2024/weekly", false},
+	}
+	for _, tt := range tests {
+		if got := WildcardMatch(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("WildcardMatch(%q, %q) = %v, expected %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseSessionPolicy(t *testing.T) {
+	p, err := ParseSessionPolicy([]byte(`{
+		"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": ["arn:aws:s3:::data/*", "arn:aws:s3:::logs/*"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseSessionPolicy failed: %v", err)
+	}
+	if got := p.Evaluate(&Request{User: "ci", Action: "s3:GetObject", Bucket: "logs", Key: "today"}); got != Allow {
+		t.Errorf("Expected Allow in any named bucket, got %v", got)
+	}
+	if got := p.Evaluate(&Request{User: "ci", Action: "s3:PutObject", Bucket: "data", Key: "file"}); got != NotApplicable {
+		t.Errorf("Expected NotApplicable for other actions, got %v", got)
+	}
+
+	withPrincipal := `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::data"}]}`
+	if _, err := ParseSessionPolicy([]byte(withPrincipal)); !errors.Is(err, ErrMalformedPolicy) {
+		t.Errorf("Expected ErrMalformedPolicy for a principal, got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package s3err defines the S3 error codes PorterFS returns and renders them
+// as the XML error documents S3 clients expect.
+package s3err
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// APIError is an S3 error code with the HTTP status it is returned with.
+type APIError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	ErrAccessDenied = APIError{
+		Code:       "AccessDenied",
+		Message:    "Access Denied",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrAuthorizationHeaderMalformed = APIError{
+		Code:       "AuthorizationHeaderMalformed",
+		Message:    "The authorization header you provided is invalid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrAuthorizationQueryParametersError = APIError{
+		Code:       "AuthorizationQueryParametersError",
+		Message:    "The query-string authentication parameters you provided are invalid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrBadDigest = APIError{
+		Code:       "BadDigest",
+		Message:    "The Content-MD5 you specified did not match what we received.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrBucketNotEmpty = APIError{
+		Code:       "BucketNotEmpty",
+		Message:    "The bucket you tried to delete is not empty.",
+		StatusCode: http.StatusConflict,
+	}
+	ErrCopyToItself = APIError{
+		Code:       "InvalidRequest",
+		Message:    "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrEntityTooSmall = APIError{
+		Code:       "EntityTooSmall",
+		Message:    "Your proposed upload is smaller than the minimum allowed object size.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrExpiredPresignRequest = APIError{
+		Code:       "AccessDenied",
+		Message:    "Request has expired",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrExpiredToken = APIError{
+		Code:       "ExpiredToken",
+		Message:    "The provided token has expired.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrIllegalVersioningConfiguration = APIError{
+		Code:       "IllegalVersioningConfigurationException",
+		Message:    "The versioning configuration specified in the request is invalid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrIncompleteBody = APIError{
+		Code:       "IncompleteBody",
+		Message:    "You did not provide the number of bytes specified by the Content-Length HTTP header.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInternalError = APIError{
+		Code:       "InternalError",
+		Message:    "We encountered an internal error. Please try again.",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrInvalidAccessKeyID = APIError{
+		Code:       "InvalidAccessKeyId",
+		Message:    "The AWS access key ID you provided does not exist in our records.",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrInvalidArgument = APIError{
+		Code:       "InvalidArgument",
+		Message:    "Invalid argument.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidBucketName = APIError{
+		Code:       "InvalidBucketName",
+		Message:    "The specified bucket is not valid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidDigest = APIError{
+		Code:       "InvalidDigest",
+		Message:    "The Content-MD5 you specified is not valid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidObjectName = APIError{
+		Code:       "InvalidArgument",
+		Message:    "The specified object key is not valid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidPart = APIError{
+		Code:       "InvalidPart",
+		Message:    "One or more of the specified parts could not be found. The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidPartNumber = APIError{
+		Code:       "InvalidArgument",
+		Message:    "Part number must be an integer between 1 and 10000, inclusive.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidPartOrder = APIError{
+		Code:       "InvalidPartOrder",
+		Message:    "The list of parts was not in ascending order. Parts must be ordered by part number.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidRange = APIError{
+		Code:       "InvalidRange",
+		Message:    "The requested range is not satisfiable.",
+		StatusCode: http.StatusRequestedRangeNotSatisfiable,
+	}
+	ErrInvalidToken = APIError{
+		Code:       "InvalidToken",
+		Message:    "The provided token is malformed or otherwise invalid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInvalidVersionID = APIError{
+		Code:       "InvalidArgument",
+		Message:    "Invalid version id specified.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrKeyTooLong = APIError{
+		Code:       "KeyTooLongError",
+		Message:    "Your key is too long.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrMalformedPolicy = APIError{
+		Code:       "MalformedPolicy",
+		Message:    "Policies must be valid JSON and the first byte must be '{'",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrMalformedXML = APIError{
+		Code:       "MalformedXML",
+		Message:    "The XML you provided was not well-formed or did not validate against our published schema.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrMethodNotAllowed = APIError{
+		Code:       "MethodNotAllowed",
+		Message:    "The specified method is not allowed against this resource.",
+		StatusCode: http.StatusMethodNotAllowed,
+	}
+	ErrMissingContentLength = APIError{
+		Code:       "MissingContentLength",
+		Message:    "You must provide the Content-Length HTTP header.",
+		StatusCode: http.StatusLengthRequired,
+	}
+	ErrMissingDateHeader = APIError{
+		Code:       "AccessDenied",
+		Message:    "AWS authentication requires a valid Date or x-amz-date header",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrMissingSecurityHeader = APIError{
+		Code:       "AccessDenied",
+		Message:    "Your request is missing a required authorization header.",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrNoSuchBucket = APIError{
+		Code:       "NoSuchBucket",
+		Message:    "The specified bucket does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNoSuchBucketPolicy = APIError{
+		Code:       "NoSuchBucketPolicy",
+		Message:    "The bucket policy does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNoSuchKey = APIError{
+		Code:       "NoSuchKey",
+		Message:    "The specified key does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNoSuchLifecycleConfiguration = APIError{
+		Code:       "NoSuchLifecycleConfiguration",
+		Message:    "The lifecycle configuration does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNoSuchUpload = APIError{
+		Code:       "NoSuchUpload",
+		Message:    "The specified multipart upload does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNoSuchVersion = APIError{
+		Code:       "NoSuchVersion",
+		Message:    "The specified version does not exist.",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNotImplemented = APIError{
+		Code:       "NotImplemented",
+		Message:    "A header or element you provided implies functionality that is not implemented.",
+		StatusCode: http.StatusNotImplemented,
+	}
+	ErrPreconditionFailed = APIError{
+		Code:       "PreconditionFailed",
+		Message:    "At least one of the pre-conditions you specified did not hold.",
+		StatusCode: http.StatusPreconditionFailed,
+	}
+	ErrPresignNotYetValid = APIError{
+		Code:       "AccessDenied",
+		Message:    "Request is not valid yet",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrRequestTimeTooSkewed = APIError{
+		Code:       "RequestTimeTooSkewed",
+		Message:    "The difference between the request time and the server's time is too large.",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrSignatureDoesNotMatch = APIError{
+		Code:       "SignatureDoesNotMatch",
+		Message:    "The request signature we calculated does not match the signature you provided.",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrUnsupportedSignatureVersion = APIError{
+		Code:       "InvalidRequest",
+		Message:    "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrXAmzContentSHA256Mismatch = APIError{
+		Code:       "XAmzContentSHA256Mismatch",
+		Message:    "The provided 'x-amz-content-sha256' header does not match what was computed.",
+		StatusCode: http.StatusBadRequest,
+	}
+)
+
+// ErrorResponse is the XML body S3 returns for failed requests.
+type ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// Write renders apiErr as an S3 XML error response for r.
+func Write(w http.ResponseWriter, r *http.Request, apiErr APIError) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(apiErr.StatusCode)
+	xml.NewEncoder(w).Encode(ErrorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+	})
+}
+
+// RequestIDHeader exposes the ID assigned by middleware.RequestID as the
+// x-amz-request-id response header. It must be installed after RequestID.
+func RequestIDHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set("x-amz-request-id", id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
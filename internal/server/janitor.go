@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/storage"
+)
+
+// multipartJanitor periodically aborts multipart uploads that were started
+// but never completed or aborted, which would otherwise keep their parts on
+// disk forever.
+type multipartJanitor struct {
+	storage  storage.Storage
+	expiry   time.Duration
+	interval time.Duration
+}
+
+// run reaps stale uploads once at startup and then on every interval until
+// ctx is cancelled.
+func (j *multipartJanitor) run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *multipartJanitor) reap(ctx context.Context) {
+	result, err := j.storage.ReapMultipartUploads(ctx, time.Now(), j.expiry)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("ERROR: multipart janitor: %v", err)
+	}
+	if result != nil && result.Uploads > 0 {
+		log.Printf("Multipart janitor aborted %d stale uploads, reclaimed %d bytes", result.Uploads, result.Bytes)
+	}
+}
@@ -3,24 +3,34 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/alexerm/porterfs/internal/auth"
 	"github.com/alexerm/porterfs/internal/config"
 	"github.com/alexerm/porterfs/internal/handlers"
+	"github.com/alexerm/porterfs/internal/policy"
+	"github.com/alexerm/porterfs/internal/s3err"
 	"github.com/alexerm/porterfs/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// sessionKeyFile holds the generated session key under the storage root.
+// Like the storage's own internal directories it starts with a dot, so it
+// is never listed as a bucket.
+const sessionKeyFile = ".session-key"
+
 type Server struct {
-	config  *config.Config
-	storage storage.Storage
-	server  *http.Server
+	config      *config.Config
+	storage     storage.Storage
+	server      *http.Server
+	stopJanitor context.CancelFunc
 }
 
 func New(cfg *config.Config) (*Server, error) {
@@ -33,6 +43,16 @@ func New(cfg *config.Config) (*Server, error) {
 		return nil, fmt.Errorf("failed to create storage: %w", err)
 	}
 
+	// Session tokens need a signing key that survives restarts; without a
+	// configured one, generate it once and keep it with the data
+	if cfg.Auth.SessionKey == "" {
+		key, err := auth.LoadSessionKey(filepath.Join(cfg.Storage.RootPath, sessionKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load session key: %w", err)
+		}
+		cfg.Auth.SessionKey = key
+	}
+
 	return &Server{
 		config:  cfg,
 		storage: store,
@@ -45,11 +65,24 @@ func (s *Server) ListenAndServe(addr string) error {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(s3err.RequestIDHeader)
+	r.Use(auth.PeerAddress)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	s.stopJanitor = stopJanitor
+	janitor := &multipartJanitor{
+		storage:  s.storage,
+		expiry:   s.config.Storage.Multipart.Expiry,
+		interval: s.config.Storage.Multipart.CleanupInterval,
+	}
+	go janitor.run(janitorCtx)
+
 	h := handlers.New(s.storage, s.config)
 	authenticator := auth.New(s.config)
+	authenticator.SetPolicySource(s.bucketPolicy)
+	sts := newSTSHandler(authenticator, s.config.Auth.OIDC)
 
 	// Test endpoint without authentication (must come before bucket routes)
 	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
@@ -73,19 +106,19 @@ func (s *Server) ListenAndServe(addr string) error {
 
 		r.Get("/bucket/{bucket}", func(w http.ResponseWriter, r *http.Request) {
 			bucket := chi.URLParam(r, "bucket")
-			objects, _, err := s.storage.ListObjects(r.Context(), bucket, "", "", 1000)
+			result, err := s.storage.ListObjects(r.Context(), bucket, "", "", "", 1000)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"status": "ok", "bucket": "` + bucket + `", "objects": ` + fmt.Sprintf("%d", len(objects)) + `}`))
+			w.Write([]byte(`{"status": "ok", "bucket": "` + bucket + `", "objects": ` + fmt.Sprintf("%d", len(result.Objects)) + `}`))
 		})
 
-		r.Put("/bucket/{bucket}/object/{object:.*}", func(w http.ResponseWriter, r *http.Request) {
+		r.Put("/bucket/{bucket}/object/*", func(w http.ResponseWriter, r *http.Request) {
 			bucket := chi.URLParam(r, "bucket")
-			object := chi.URLParam(r, "object")
+			object := handlers.ObjectKey(r)
 
 			// Read body
 			body, err := io.ReadAll(r.Body)
@@ -95,7 +128,7 @@ func (s *Server) ListenAndServe(addr string) error {
 			}
 
 			reader := bytes.NewReader(body)
-			if err := s.storage.PutObject(r.Context(), bucket, object, reader, int64(len(body)), "application/octet-stream"); err != nil {
+			if _, err := s.storage.PutObject(r.Context(), bucket, object, reader, int64(len(body)), storage.ObjectMetadata{}); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -105,11 +138,11 @@ func (s *Server) ListenAndServe(addr string) error {
 			w.Write([]byte(`{"status": "ok", "message": "Object uploaded", "bucket": "` + bucket + `", "object": "` + object + `"}`))
 		})
 
-		r.Get("/bucket/{bucket}/object/{object:.*}", func(w http.ResponseWriter, r *http.Request) {
+		r.Get("/bucket/{bucket}/object/*", func(w http.ResponseWriter, r *http.Request) {
 			bucket := chi.URLParam(r, "bucket")
-			object := chi.URLParam(r, "object")
+			object := handlers.ObjectKey(r)
 
-			reader, _, err := s.storage.GetObject(r.Context(), bucket, object, "")
+			reader, _, err := s.storage.GetObject(r.Context(), bucket, object, "", "")
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusNotFound)
 				return
@@ -128,6 +161,11 @@ func (s *Server) ListenAndServe(addr string) error {
 		})
 	})
 
+	// STS clients POST their form-encoded requests to the endpoint root.
+	// The handler authenticates them itself, as AssumeRoleWithWebIdentity
+	// requests carry a token instead of a signature.
+	r.Post("/", sts.ServeHTTP)
+
 	// S3 API routes with authentication
 	r.Route("/", func(r chi.Router) {
 		log.Printf("DEBUG: Applying authentication middleware to S3 routes")
@@ -135,45 +173,99 @@ func (s *Server) ListenAndServe(addr string) error {
 		r.Get("/", h.ListBuckets)
 		r.Route("/{bucket}", func(r chi.Router) {
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-				// Check for multipart uploads query
-				if r.URL.Query().Get("uploads") != "" {
+				query := r.URL.Query()
+				switch {
+				case query.Has("uploads"):
 					h.ListMultipartUploads(w, r)
+				case query.Has("versioning"):
+					h.GetBucketVersioning(w, r)
+				case query.Has("lifecycle"):
+					h.GetBucketLifecycle(w, r)
+				case query.Has("policy"):
+					h.GetBucketPolicy(w, r)
+				case query.Has("versions"):
+					h.ListObjectVersions(w, r)
+				default:
+					h.ListObjects(w, r)
+				}
+			})
+			r.Put("/", func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				switch {
+				case query.Has("versioning"):
+					h.PutBucketVersioning(w, r)
+				case query.Has("lifecycle"):
+					h.PutBucketLifecycle(w, r)
+				case query.Has("policy"):
+					h.PutBucketPolicy(w, r)
+				default:
+					h.CreateBucket(w, r)
+				}
+			})
+			r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				switch {
+				case query.Has("lifecycle"):
+					h.DeleteBucketLifecycle(w, r)
+				case query.Has("policy"):
+					h.DeleteBucketPolicy(w, r)
+				default:
+					h.DeleteBucket(w, r)
+				}
+			})
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Has("delete") {
+					h.DeleteObjects(w, r)
 					return
 				}
-				h.ListObjects(w, r)
+				s3err.Write(w, r, s3err.ErrMethodNotAllowed)
 			})
-			r.Put("/", h.CreateBucket)
-			r.Delete("/", h.DeleteBucket)
-
-			r.Route("/{object:.*}", func(r chi.Router) {
-				r.Get("/", h.GetObject)
-				r.Put("/", func(w http.ResponseWriter, r *http.Request) {
-					// Check for multipart upload operations
-					if uploadID := r.URL.Query().Get("uploadId"); uploadID != "" {
-						if partNumber := r.URL.Query().Get("partNumber"); partNumber != "" {
-							h.UploadPart(w, r)
-							return
-						}
-						h.CompleteMultipartUpload(w, r)
-						return
-					}
-					h.PutObject(w, r)
-				})
-				r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
-					if uploadID := r.URL.Query().Get("uploadId"); uploadID != "" {
-						h.AbortMultipartUpload(w, r)
-						return
-					}
-					h.DeleteObject(w, r)
-				})
-				r.Head("/", h.HeadObject)
-				r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-					if r.URL.Query().Get("uploads") != "" {
-						h.InitiateMultipartUpload(w, r)
+
+			// Object keys may contain slashes, so they are captured with a
+			// trailing wildcard rather than a single path segment
+			r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Has("uploadId") {
+					h.ListParts(w, r)
+					return
+				}
+				h.GetObject(w, r)
+			})
+			r.Put("/*", func(w http.ResponseWriter, r *http.Request) {
+				isCopy := r.Header.Get("x-amz-copy-source") != ""
+
+				// Parts are uploaded with PUT; completion is a POST below
+				if r.URL.Query().Has("uploadId") {
+					if isCopy {
+						h.UploadPartCopy(w, r)
 						return
 					}
-					http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-				})
+					h.UploadPart(w, r)
+					return
+				}
+				if isCopy {
+					h.CopyObject(w, r)
+					return
+				}
+				h.PutObject(w, r)
+			})
+			r.Delete("/*", func(w http.ResponseWriter, r *http.Request) {
+				if uploadID := r.URL.Query().Get("uploadId"); uploadID != "" {
+					h.AbortMultipartUpload(w, r)
+					return
+				}
+				h.DeleteObject(w, r)
+			})
+			r.Head("/*", h.HeadObject)
+			r.Post("/*", func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				switch {
+				case query.Has("uploads"):
+					h.InitiateMultipartUpload(w, r)
+				case query.Has("uploadId"):
+					h.CompleteMultipartUpload(w, r)
+				default:
+					s3err.Write(w, r, s3err.ErrMethodNotAllowed)
+				}
 			})
 		})
 	})
@@ -192,7 +284,25 @@ func (s *Server) ListenAndServe(addr string) error {
 	}
 }
 
+// bucketPolicy loads the policy of bucket for the authenticator. Buckets that
+// do not exist have no policy, so the handler reports NoSuchBucket.
+func (s *Server) bucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
+	data, err := s.storage.GetBucketPolicy(ctx, bucket)
+	switch {
+	case errors.Is(err, storage.ErrNoSuchBucketPolicy),
+		errors.Is(err, storage.ErrBucketNotFound),
+		errors.Is(err, storage.ErrInvalidBucketName):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return policy.Parse(data, bucket)
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.stopJanitor != nil {
+		s.stopJanitor()
+	}
 	if s.server != nil {
 		return s.server.Shutdown(ctx)
 	}
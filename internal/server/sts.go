@@ -0,0 +1,437 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/auth"
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/alexerm/porterfs/internal/oidc"
+	"github.com/alexerm/porterfs/internal/policy"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// stsNamespace is the XML namespace of STS responses.
+const stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// maxSessionPolicySize is the largest session policy STS accepts.
+const maxSessionPolicySize = 2048
+
+// stsError is an STS error code with the HTTP status it is returned with.
+// STS renders errors differently from S3, so they are not s3err.APIErrors.
+type stsError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+var (
+	errSTSAccessDenied = stsError{
+		Code:       "AccessDenied",
+		Message:    "Temporary credentials cannot be used to request further sessions.",
+		StatusCode: http.StatusForbidden,
+	}
+	errSTSSessionsDisabled = stsError{
+		Code:       "AccessDenied",
+		Message:    "Temporary credentials are disabled because no session key is set.",
+		StatusCode: http.StatusForbidden,
+	}
+	errSTSRoleDenied = stsError{
+		Code:       "AccessDenied",
+		Message:    "Not authorized to perform sts:AssumeRoleWithWebIdentity.",
+		StatusCode: http.StatusForbidden,
+	}
+	errSTSExpiredToken = stsError{
+		Code:       "ExpiredTokenException",
+		Message:    "Token is expired.",
+		StatusCode: http.StatusBadRequest,
+	}
+	errSTSInvalidIdentityToken = stsError{
+		Code:       "InvalidIdentityToken",
+		Message:    "The web identity token could not be validated.",
+		StatusCode: http.StatusBadRequest,
+	}
+	errSTSInvalidAction = stsError{
+		Code:       "InvalidAction",
+		Message:    "The action or operation requested is invalid.",
+		StatusCode: http.StatusBadRequest,
+	}
+	errSTSMalformedPolicy = stsError{
+		Code:       "MalformedPolicyDocument",
+		Message:    "The session policy is malformed.",
+		StatusCode: http.StatusBadRequest,
+	}
+	errSTSNoProvider = stsError{
+		Code:       "InvalidIdentityToken",
+		Message:    "No OpenID Connect provider is configured.",
+		StatusCode: http.StatusBadRequest,
+	}
+)
+
+func errSTSMissingParameter(name string) stsError {
+	return stsError{
+		Code:       "MissingParameter",
+		Message:    "The request must contain the parameter " + name + ".",
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+func errSTSInvalidParameter(message string) stsError {
+	return stsError{
+		Code:       "InvalidParameterValue",
+		Message:    message,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// stsErrorResponse is the XML body STS returns for failed requests.
+type stsErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Error   struct {
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestID string `xml:"RequestId"`
+}
+
+type stsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type stsResponseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+type assumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials     stsCredentials `xml:"Credentials"`
+		AssumedRoleUser struct {
+			Arn           string `xml:"Arn"`
+			AssumedRoleID string `xml:"AssumedRoleId"`
+		} `xml:"AssumedRoleUser"`
+	} `xml:"AssumeRoleResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type assumeRoleWithWebIdentityResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleWithWebIdentityResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials                 stsCredentials `xml:"Credentials"`
+		SubjectFromWebIdentityToken string         `xml:"SubjectFromWebIdentityToken"`
+		AssumedRoleUser             struct {
+			Arn           string `xml:"Arn"`
+			AssumedRoleID string `xml:"AssumedRoleId"`
+		} `xml:"AssumedRoleUser"`
+		Provider string `xml:"Provider"`
+		Audience string `xml:"Audience"`
+	} `xml:"AssumeRoleWithWebIdentityResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type getSessionTokenResponse struct {
+	XMLName xml.Name `xml:"GetSessionTokenResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials stsCredentials `xml:"Credentials"`
+	} `xml:"GetSessionTokenResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// roleSessionNamePattern matches the role session names STS accepts.
+var roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// stsHandler serves the subset of the STS query API that issues temporary
+// credentials. PorterFS has no IAM roles: AssumeRole accepts any role ARN
+// and, like GetSessionToken, returns credentials acting as the calling user,
+// optionally narrowed by a session policy. AssumeRoleWithWebIdentity
+// assumes one of the roles in the OIDC config instead.
+type stsHandler struct {
+	auth     *auth.Authenticator
+	signed   http.Handler
+	oidc     config.OIDCConfig
+	verifier *oidc.Verifier
+}
+
+func newSTSHandler(authenticator *auth.Authenticator, cfg config.OIDCConfig) *stsHandler {
+	h := &stsHandler{
+		auth:     authenticator,
+		oidc:     cfg,
+		verifier: oidc.NewVerifier(cfg),
+	}
+	h.signed = authenticator.AuthMiddleware(http.HandlerFunc(h.serveSigned))
+	return h
+}
+
+// ServeHTTP serves STS requests. AssumeRoleWithWebIdentity requests are
+// authenticated by their token rather than a signature; all other requests
+// go through the authenticator first.
+func (h *stsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" && !r.URL.Query().Has("X-Amz-Algorithm") {
+		if err := r.ParseForm(); err == nil && r.Form.Get("Action") == "AssumeRoleWithWebIdentity" {
+			h.assumeRoleWithWebIdentity(w, r)
+			return
+		}
+	}
+	h.signed.ServeHTTP(w, r)
+}
+
+func (h *stsHandler) serveSigned(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSTSError(w, r, errSTSInvalidParameter("The request body could not be parsed."))
+		return
+	}
+
+	switch action := r.Form.Get("Action"); action {
+	case "AssumeRole":
+		h.assumeRole(w, r)
+	case "GetSessionToken":
+		h.getSessionToken(w, r)
+	default:
+		log.Printf("DEBUG: Unsupported STS action %q", action)
+		writeSTSError(w, r, errSTSInvalidAction)
+	}
+}
+
+func (h *stsHandler) assumeRole(w http.ResponseWriter, r *http.Request) {
+	roleArn, sessionName, ok := roleParams(w, r)
+	if !ok {
+		return
+	}
+	duration, ok := sessionDuration(w, r, time.Hour, 12*time.Hour)
+	if !ok {
+		return
+	}
+	sessionPolicy, ok := sessionPolicyParam(w, r)
+	if !ok {
+		return
+	}
+
+	creds, ok := h.issue(w, r, duration, sessionPolicy)
+	if !ok {
+		return
+	}
+
+	var resp assumeRoleResponse
+	resp.Xmlns = stsNamespace
+	resp.Result.Credentials = creds
+	resp.Result.AssumedRoleUser.Arn = "arn:aws:sts::000000000000:assumed-role/" + roleName(roleArn) + "/" + sessionName
+	resp.Result.AssumedRoleUser.AssumedRoleID = creds.AccessKeyID + ":" + sessionName
+	resp.ResponseMetadata.RequestID = middleware.GetReqID(r.Context())
+	writeSTSResponse(w, resp)
+}
+
+func (h *stsHandler) assumeRoleWithWebIdentity(w http.ResponseWriter, r *http.Request) {
+	if h.verifier == nil {
+		writeSTSError(w, r, errSTSNoProvider)
+		return
+	}
+
+	roleArn, sessionName, ok := roleParams(w, r)
+	if !ok {
+		return
+	}
+	token := r.Form.Get("WebIdentityToken")
+	if token == "" {
+		writeSTSError(w, r, errSTSMissingParameter("WebIdentityToken"))
+		return
+	}
+	duration, ok := sessionDuration(w, r, time.Hour, 12*time.Hour)
+	if !ok {
+		return
+	}
+	sessionPolicy, ok := sessionPolicyParam(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := h.verifier.Verify(r.Context(), token)
+	if err != nil {
+		log.Printf("DEBUG: Rejected web identity token: %v", err)
+		if errors.Is(err, oidc.ErrExpiredToken) {
+			writeSTSError(w, r, errSTSExpiredToken)
+			return
+		}
+		writeSTSError(w, r, errSTSInvalidIdentityToken)
+		return
+	}
+
+	role, ok := h.oidc.Role(roleName(roleArn))
+	if !ok || !oidc.MatchRole(role, claims) {
+		log.Printf("DEBUG: Web identity %s may not assume role %s", claims.Subject(), roleArn)
+		writeSTSError(w, r, errSTSRoleDenied)
+		return
+	}
+
+	session, err := h.auth.IssueWebIdentitySession(role, duration, sessionPolicy)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionsDisabled) {
+			writeSTSError(w, r, errSTSSessionsDisabled)
+			return
+		}
+		log.Printf("ERROR: Failed to issue session for role %s: %v", role.Name, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("AUDIT: web identity %s assumed role %s as session %s until %s", claims.Subject(), role.Name, session.AccessKey, session.Expiration.Format(time.RFC3339))
+
+	var resp assumeRoleWithWebIdentityResponse
+	resp.Xmlns = stsNamespace
+	resp.Result.Credentials = newSTSCredentials(session)
+	resp.Result.SubjectFromWebIdentityToken = claims.Subject()
+	resp.Result.AssumedRoleUser.Arn = "arn:aws:sts::000000000000:assumed-role/" + role.Name + "/" + sessionName
+	resp.Result.AssumedRoleUser.AssumedRoleID = session.AccessKey + ":" + sessionName
+	resp.Result.Provider = h.oidc.Issuer
+	resp.Result.Audience = h.oidc.Audience
+	resp.ResponseMetadata.RequestID = middleware.GetReqID(r.Context())
+	writeSTSResponse(w, resp)
+}
+
+func (h *stsHandler) getSessionToken(w http.ResponseWriter, r *http.Request) {
+	duration, ok := sessionDuration(w, r, 12*time.Hour, 36*time.Hour)
+	if !ok {
+		return
+	}
+
+	creds, ok := h.issue(w, r, duration, "")
+	if !ok {
+		return
+	}
+
+	var resp getSessionTokenResponse
+	resp.Xmlns = stsNamespace
+	resp.Result.Credentials = creds
+	resp.ResponseMetadata.RequestID = middleware.GetReqID(r.Context())
+	writeSTSResponse(w, resp)
+}
+
+// issue returns temporary credentials for the user r is signed by.
+func (h *stsHandler) issue(w http.ResponseWriter, r *http.Request, duration time.Duration, sessionPolicy string) (stsCredentials, bool) {
+	identity := auth.IdentityFromContext(r.Context())
+	if identity == nil {
+		writeSTSError(w, r, errSTSAccessDenied)
+		return stsCredentials{}, false
+	}
+
+	session, err := h.auth.IssueSession(identity, duration, sessionPolicy)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionChaining) {
+			writeSTSError(w, r, errSTSAccessDenied)
+			return stsCredentials{}, false
+		}
+		if errors.Is(err, auth.ErrSessionsDisabled) {
+			writeSTSError(w, r, errSTSSessionsDisabled)
+			return stsCredentials{}, false
+		}
+		log.Printf("ERROR: Failed to issue session for %s: %v", identity.Name, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return stsCredentials{}, false
+	}
+	log.Printf("AUDIT: user=%s issued session %s until %s", identity.Name, session.AccessKey, session.Expiration.Format(time.RFC3339))
+
+	return newSTSCredentials(session), true
+}
+
+func newSTSCredentials(session *auth.SessionCredentials) stsCredentials {
+	return stsCredentials{
+		AccessKeyID:     session.AccessKey,
+		SecretAccessKey: session.SecretKey,
+		SessionToken:    session.SessionToken,
+		Expiration:      session.Expiration.Format(time.RFC3339),
+	}
+}
+
+// roleParams returns the RoleArn and RoleSessionName parameters of r.
+func roleParams(w http.ResponseWriter, r *http.Request) (roleArn, sessionName string, ok bool) {
+	roleArn = r.Form.Get("RoleArn")
+	if roleArn == "" {
+		writeSTSError(w, r, errSTSMissingParameter("RoleArn"))
+		return "", "", false
+	}
+	sessionName = r.Form.Get("RoleSessionName")
+	if sessionName == "" {
+		writeSTSError(w, r, errSTSMissingParameter("RoleSessionName"))
+		return "", "", false
+	}
+	if !roleSessionNamePattern.MatchString(sessionName) {
+		writeSTSError(w, r, errSTSInvalidParameter("RoleSessionName must be 2 to 64 characters of letters, digits and +=,.@_-"))
+		return "", "", false
+	}
+	return roleArn, sessionName, true
+}
+
+// sessionPolicyParam returns the Policy parameter of r, which is empty or a
+// valid session policy.
+func sessionPolicyParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sessionPolicy := r.Form.Get("Policy")
+	if len(sessionPolicy) > maxSessionPolicySize {
+		writeSTSError(w, r, errSTSInvalidParameter("The session policy is too large."))
+		return "", false
+	}
+	if sessionPolicy != "" {
+		if _, err := policy.ParseSessionPolicy([]byte(sessionPolicy)); err != nil {
+			log.Printf("DEBUG: Rejected session policy: %v", err)
+			writeSTSError(w, r, errSTSMalformedPolicy)
+			return "", false
+		}
+	}
+	return sessionPolicy, true
+}
+
+// sessionDuration returns the DurationSeconds parameter of r, or def when it
+// is absent. Durations must lie between 15 minutes and max.
+func sessionDuration(w http.ResponseWriter, r *http.Request, def, max time.Duration) (time.Duration, bool) {
+	param := r.Form.Get("DurationSeconds")
+	if param == "" {
+		return def, true
+	}
+
+	seconds, err := strconv.Atoi(param)
+	duration := time.Duration(seconds) * time.Second
+	if err != nil || duration < 15*time.Minute || duration > max {
+		writeSTSError(w, r, errSTSInvalidParameter("DurationSeconds must be between 900 and "+strconv.Itoa(int(max.Seconds()))+"."))
+		return 0, false
+	}
+	return duration, true
+}
+
+// roleName returns the name in a role ARN such as
+// arn:aws:iam::123456789012:role/path/name, or the ARN itself if it has none.
+func roleName(roleArn string) string {
+	for i := len(roleArn) - 1; i >= 0; i-- {
+		if roleArn[i] == '/' || roleArn[i] == ':' {
+			return roleArn[i+1:]
+		}
+	}
+	return roleArn
+}
+
+func writeSTSResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func writeSTSError(w http.ResponseWriter, r *http.Request, stsErr stsError) {
+	var resp stsErrorResponse
+	resp.Xmlns = stsNamespace
+	resp.Error.Type = "Sender"
+	resp.Error.Code = stsErr.Code
+	resp.Error.Message = stsErr.Message
+	resp.RequestID = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(stsErr.StatusCode)
+	xml.NewEncoder(w).Encode(resp)
+}
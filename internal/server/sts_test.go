@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexerm/porterfs/internal/auth"
+	"github.com/alexerm/porterfs/internal/config"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const testSessionKey = "0123456789abcdef0123456789abcdef"
+
+func TestSTSHandler(t *testing.T) {
+	authenticator := auth.New(&config.Config{
+		Auth: config.AuthConfig{AccessKey: "root-access-key", SecretKey: "root-secret-key", SessionKey: testSessionKey},
+	})
+	h := newSTSHandler(authenticator, config.OIDCConfig{})
+	caller := &auth.Identity{Name: auth.RootUser, AccessKey: "root-access-key", Root: true}
+
+	post := func(identity *auth.Identity, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(auth.WithIdentity(req.Context(), identity))
+		w := httptest.NewRecorder()
+		h.serveSigned(w, req)
+		return w
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		t.Helper()
+		var resp stsErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse error response: %v", err)
+		}
+		return resp.Error.Code
+	}
+
+	t.Run("GetSessionToken", func(t *testing.T) {
+		w := post(caller, url.Values{"Action": {"GetSessionToken"}, "Version": {"2011-06-15"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp getSessionTokenResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		creds := resp.Result.Credentials
+		if !strings.HasPrefix(creds.AccessKeyID, "ASIA") || creds.SecretAccessKey == "" || creds.SessionToken == "" {
+			t.Errorf("Expected temporary credentials, got %+v", creds)
+		}
+		expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining := time.Until(expiration); remaining < 11*time.Hour || remaining > 12*time.Hour {
+			t.Errorf("Expected the default 12 hour duration, got %s", remaining)
+		}
+	})
+
+	t.Run("AssumeRole", func(t *testing.T) {
+		w := post(caller, url.Values{
+			"Action":          {"AssumeRole"},
+			"RoleArn":         {"arn:aws:iam::123456789012:role/ci-uploader"},
+			"RoleSessionName": {"build-42"},
+			"DurationSeconds": {"900"},
+			"Policy":          {`{"Statement": [{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::artifacts/*"}]}`},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp assumeRoleResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if want := "arn:aws:sts::000000000000:assumed-role/ci-uploader/build-42"; resp.Result.AssumedRoleUser.Arn != want {
+			t.Errorf("Expected ARN %s, got %s", want, resp.Result.AssumedRoleUser.Arn)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			form url.Values
+			code string
+		}{
+			{"UnknownAction", url.Values{"Action": {"GetCallerIdentity"}}, "InvalidAction"},
+			{"MissingRoleArn", url.Values{"Action": {"AssumeRole"}, "RoleSessionName": {"build"}}, "MissingParameter"},
+			{"BadSessionName", url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"a b"}}, "InvalidParameterValue"},
+			{"DurationTooShort", url.Values{"Action": {"GetSessionToken"}, "DurationSeconds": {"60"}}, "InvalidParameterValue"},
+			{"DurationTooLong", url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"build"}, "DurationSeconds": {"86400"}}, "InvalidParameterValue"},
+			{"MalformedPolicy", url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:role/r"}, "RoleSessionName": {"build"}, "Policy": {`{"Statement": []}`}}, "MalformedPolicyDocument"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := post(caller, tt.form)
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("Expected status 400, got %d", w.Code)
+				}
+				if code := errorCode(w); code != tt.code {
+					t.Errorf("Expected %s, got %s", tt.code, code)
+				}
+			})
+		}
+	})
+
+	t.Run("SessionsDisabled", func(t *testing.T) {
+		disabled := newSTSHandler(auth.New(&config.Config{
+			Auth: config.AuthConfig{AccessKey: "root-access-key", SecretKey: "root-secret-key"},
+		}), config.OIDCConfig{})
+		req := httptest.NewRequest("POST", "/", strings.NewReader("Action=GetSessionToken"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(auth.WithIdentity(req.Context(), caller))
+		w := httptest.NewRecorder()
+		disabled.serveSigned(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("TemporaryCaller", func(t *testing.T) {
+		temporary := &auth.Identity{Name: auth.RootUser, AccessKey: "ASIAEXAMPLE", Root: true, Expiration: time.Now().Add(time.Hour)}
+		w := post(temporary, url.Values{"Action": {"GetSessionToken"}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+		if code := errorCode(w); code != "AccessDenied" {
+			t.Errorf("Expected AccessDenied, got %s", code)
+		}
+	})
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir, err := os.MkdirTemp("", "porter-sts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	jwksFile := filepath.Join(tmpDir, "jwks.json")
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "test", "n": %q, "e": "AQAB"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	if err := os.WriteFile(jwksFile, []byte(jwks), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			AccessKey:  "root-access-key",
+			SecretKey:  "root-secret-key",
+			SessionKey: testSessionKey,
+			OIDC: config.OIDCConfig{
+				Issuer:   "https://issuer.example.com",
+				Audience: "porterfs",
+				JWKSFile: jwksFile,
+				Roles: []config.OIDCRoleConfig{{
+					Name:   "deploy",
+					Claims: map[string]string{"sub": "repo:acme/*"},
+					Policy: `{"Statement": [{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::releases/*"}]}`,
+				}},
+			},
+		},
+	}
+	authenticator := auth.New(cfg)
+	h := newSTSHandler(authenticator, cfg.Auth.OIDC)
+
+	token := func(sub string) string {
+		encode := func(v string) string { return base64.RawURLEncoding.EncodeToString([]byte(v)) }
+		signed := encode(`{"alg": "RS256", "kid": "test"}`) + "." +
+			encode(fmt.Sprintf(`{"iss": "https://issuer.example.com", "aud": "porterfs", "sub": %q, "exp": %d}`, sub, time.Now().Add(time.Hour).Unix()))
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	post := func(roleArn, webIdentityToken string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{
+			"Action":           {"AssumeRoleWithWebIdentity"},
+			"RoleArn":          {roleArn},
+			"RoleSessionName":  {"pipeline-7"},
+			"WebIdentityToken": {webIdentityToken},
+		}
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		w := post("arn:aws:iam::000000000000:role/deploy", token("repo:acme/app"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp assumeRoleWithWebIdentityResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Result.SubjectFromWebIdentityToken != "repo:acme/app" {
+			t.Errorf("Expected subject repo:acme/app, got %q", resp.Result.SubjectFromWebIdentityToken)
+		}
+
+		// The credentials authenticate as the role, limited by its policy
+		creds := resp.Result.Credentials
+		middleware := authenticator.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity := auth.IdentityFromContext(r.Context()); identity.Name != "deploy" {
+				t.Errorf("Expected identity deploy, got %s", identity.Name)
+			}
+		}))
+		for path, want := range map[string]int{"/releases/app.tar.gz": http.StatusOK, "/private/app.tar.gz": http.StatusForbidden} {
+			req := httptest.NewRequest("PUT", "http://localhost:9000"+path, nil)
+			signer := v4.NewSigner(credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken))
+			if _, err := signer.Sign(req, nil, "s3", "us-east-1", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, req)
+			if w.Code != want {
+				t.Errorf("PUT %s: expected status %d, got %d", path, want, w.Code)
+			}
+		}
+	})
+
+	t.Run("ClaimsMismatch", func(t *testing.T) {
+		w := post("arn:aws:iam::000000000000:role/deploy", token("repo:other/app"))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnknownRole", func(t *testing.T) {
+		w := post("arn:aws:iam::000000000000:role/admin", token("repo:acme/app"))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		tampered := token("repo:acme/app")
+		tampered = tampered[:len(tampered)-4] + "AAAA"
+		w := post("arn:aws:iam::000000000000:role/deploy", tampered)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+		var resp stsErrorResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code != "InvalidIdentityToken" {
+			t.Errorf("Expected InvalidIdentityToken, got %q (%v)", resp.Error.Code, err)
+		}
+	})
+
+	t.Run("UnsignedSessionRequest", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("Action=GetSessionToken"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Bucket versioning states. A bucket that has never been configured has no
+// status; once enabled, versioning can only be suspended, not turned off.
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// bucketConfig holds per-bucket settings under .buckets/<bucket>.json.
+type bucketConfig struct {
+	Versioning string          `json:"versioning,omitempty"`
+	Lifecycle  []LifecycleRule `json:"lifecycle,omitempty"`
+	Policy     json.RawMessage `json:"policy,omitempty"`
+}
+
+func (l *LocalStorage) bucketConfigPath(bucket string) string {
+	return filepath.Join(l.rootPath, ".buckets", bucket+".json")
+}
+
+// loadBucketConfig returns the bucket's settings, or the zero config when
+// none have been stored.
+func (l *LocalStorage) loadBucketConfig(bucket string) (*bucketConfig, error) {
+	data, err := os.ReadFile(l.bucketConfigPath(bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &bucketConfig{}, nil
+		}
+		return nil, err
+	}
+
+	var cfg bucketConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (l *LocalStorage) saveBucketConfig(bucket string, cfg *bucketConfig) error {
+	return l.writeMetaFile(l.bucketConfigPath(bucket), cfg)
+}
+
+func (l *LocalStorage) PutBucketVersioning(ctx context.Context, bucket, status string) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return ErrInvalidVersioningStatus
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Versioning = status
+
+	return l.saveBucketConfig(bucket, cfg)
+}
+
+func (l *LocalStorage) GetBucketVersioning(ctx context.Context, bucket string) (string, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return "", err
+	}
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.Versioning, nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyObject(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-copy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allow parts smaller than the S3 minimum to keep test data short
+	storage.minPartSize = 1
+
+	ctx := context.Background()
+	for _, bucket := range []string{"source-bucket", "dest-bucket"} {
+		if err := storage.CreateBucket(ctx, bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content := "copy me please"
+	source, err := storage.PutObject(ctx, "source-bucket", "docs/a.txt", strings.NewReader(content), int64(len(content)), ObjectMetadata{
+		ContentType:  "text/plain",
+		UserMetadata: map[string]string{"owner": "porter"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := CopySource{Bucket: "source-bucket", Key: "docs/a.txt"}
+
+	t.Run("CopyMetadata", func(t *testing.T) {
+		info, err := storage.CopyObject(ctx, src, "dest-bucket", "b.txt", nil)
+		if err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		if info.ETag != source.ETag {
+			t.Errorf("Expected ETag %s, got %s", source.ETag, info.ETag)
+		}
+
+		if got := readObjectVersion(t, storage, "dest-bucket", "b.txt", ""); got != content {
+			t.Errorf("Expected content '%s', got '%s'", content, got)
+		}
+
+		head, err := storage.HeadObject(ctx, "dest-bucket", "b.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.ContentType != "text/plain" || head.UserMetadata["owner"] != "porter" {
+			t.Errorf("Expected source metadata to be copied, got %+v", head)
+		}
+
+		// The source must be left untouched
+		if got := readObjectVersion(t, storage, "source-bucket", "docs/a.txt", ""); got != content {
+			t.Errorf("Expected source content '%s', got '%s'", content, got)
+		}
+	})
+
+	t.Run("ReplaceMetadata", func(t *testing.T) {
+		_, err := storage.CopyObject(ctx, src, "dest-bucket", "c.txt", &ObjectMetadata{ContentType: "application/json"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		head, err := storage.HeadObject(ctx, "dest-bucket", "c.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.ContentType != "application/json" || len(head.UserMetadata) != 0 {
+			t.Errorf("Expected replaced metadata, got %+v", head)
+		}
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			conditions CopyConditions
+			wantErr    error
+		}{
+			{"IfMatch", CopyConditions{IfMatch: "\"" + source.ETag + "\""}, nil},
+			{"IfMatchMismatch", CopyConditions{IfMatch: "\"other\""}, ErrPreconditionFailed},
+			{"IfNoneMatch", CopyConditions{IfNoneMatch: source.ETag}, ErrPreconditionFailed},
+			{"IfModifiedSinceFuture", CopyConditions{IfModifiedSince: time.Now().Add(time.Hour)}, ErrPreconditionFailed},
+			{"IfUnmodifiedSincePast", CopyConditions{IfUnmodifiedSince: time.Now().Add(-time.Hour)}, ErrPreconditionFailed},
+			{"IfMatchOverridesUnmodifiedSince", CopyConditions{IfMatch: "*", IfUnmodifiedSince: time.Now().Add(-time.Hour)}, nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conditional := src
+				conditional.Conditions = tt.conditions
+				_, err := storage.CopyObject(ctx, conditional, "dest-bucket", "conditional.txt", nil)
+				if err != tt.wantErr {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	})
+
+	t.Run("MissingSource", func(t *testing.T) {
+		missing := CopySource{Bucket: "source-bucket", Key: "missing.txt"}
+		if _, err := storage.CopyObject(ctx, missing, "dest-bucket", "d.txt", nil); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UploadPartCopy", func(t *testing.T) {
+		uploadID, err := storage.InitMultipartUpload(ctx, "dest-bucket", "assembled.txt", ObjectMetadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ranged := src
+		ranged.Range = "bytes=0-3"
+		etag1, err := storage.UploadPartCopy(ctx, ranged, "dest-bucket", "assembled.txt", uploadID, 1)
+		if err != nil {
+			t.Fatalf("UploadPartCopy failed: %v", err)
+		}
+		etag2, err := storage.UploadPartCopy(ctx, src, "dest-bucket", "assembled.txt", uploadID, 2)
+		if err != nil {
+			t.Fatalf("UploadPartCopy failed: %v", err)
+		}
+
+		parts := []Part{{PartNumber: 1, ETag: etag1}, {PartNumber: 2, ETag: etag2}}
+		if _, err := storage.CompleteMultipartUpload(ctx, "dest-bucket", "assembled.txt", uploadID, parts); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readObjectVersion(t, storage, "dest-bucket", "assembled.txt", ""); got != "copy"+content {
+			t.Errorf("Expected content '%s', got '%s'", "copy"+content, got)
+		}
+	})
+}
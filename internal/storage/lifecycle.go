@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// maxLifecycleRules is the S3 limit on rules per lifecycle configuration.
+const maxLifecycleRules = 1000
+
+// LifecycleRule is a bucket lifecycle rule. Only the
+// AbortIncompleteMultipartUpload action is supported: uploads of keys under
+// Prefix are aborted AbortIncompleteUploadDays after they were initiated.
+type LifecycleRule struct {
+	ID                        string `json:"id,omitempty"`
+	Prefix                    string `json:"prefix,omitempty"`
+	Enabled                   bool   `json:"enabled"`
+	AbortIncompleteUploadDays int    `json:"abort_incomplete_upload_days"`
+}
+
+// abortUploadAfter returns how long after initiation an upload of key may
+// live under rules, and false when no enabled rule applies to it.
+func abortUploadAfter(rules []LifecycleRule, key string) (time.Duration, bool) {
+	var after time.Duration
+	found := false
+	for _, rule := range rules {
+		if !rule.Enabled || !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+		ruleAfter := time.Duration(rule.AbortIncompleteUploadDays) * 24 * time.Hour
+		if !found || ruleAfter < after {
+			after = ruleAfter
+			found = true
+		}
+	}
+	return after, found
+}
+
+func (l *LocalStorage) PutBucketLifecycle(ctx context.Context, bucket string, rules []LifecycleRule) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+	if len(rules) == 0 || len(rules) > maxLifecycleRules {
+		return ErrInvalidLifecycleRule
+	}
+	for _, rule := range rules {
+		if rule.AbortIncompleteUploadDays < 1 {
+			return ErrInvalidLifecycleRule
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Lifecycle = rules
+
+	return l.saveBucketConfig(bucket, cfg)
+}
+
+func (l *LocalStorage) GetBucketLifecycle(ctx context.Context, bucket string) ([]LifecycleRule, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Lifecycle) == 0 {
+		return nil, ErrNoSuchLifecycleConfiguration
+	}
+
+	return cfg.Lifecycle, nil
+}
+
+func (l *LocalStorage) DeleteBucketLifecycle(ctx context.Context, bucket string) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Lifecycle = nil
+
+	return l.saveBucketConfig(bucket, cfg)
+}
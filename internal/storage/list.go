@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errStopWalk ends a bucket walk early once a listing page is full.
+var errStopWalk = errors.New("stop walk")
+
+// walkEntry is a directory entry with the name it sorts under. Directories
+// sort as "name/" so the walk yields keys in S3 (byte-wise) order: "a-b"
+// comes before "a/c".
+type walkEntry struct {
+	entry   os.DirEntry
+	sortKey string
+}
+
+func readSortedDir(dir string) ([]walkEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]walkEntry, len(entries))
+	for i, entry := range entries {
+		sortKey := entry.Name()
+		if entry.IsDir() {
+			sortKey += "/"
+		}
+		sorted[i] = walkEntry{entry: entry, sortKey: sortKey}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sortKey < sorted[j].sortKey
+	})
+
+	return sorted, nil
+}
+
+// hasObjects reports whether dir contains at least one file at any depth.
+func hasObjects(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || hasObjects(filepath.Join(dir, entry.Name())) {
+			return true
+		}
+	}
+	return false
+}
+
+// objectLister accumulates one page of a ListObjects call.
+type objectLister struct {
+	storage    *LocalStorage
+	bucket     string
+	prefix     string
+	delimiter  string
+	startAfter string
+	maxKeys    int
+	result     ListObjectsResult
+	count      int
+	lastPrefix string
+}
+
+// commonPrefix returns the CommonPrefixes entry key rolls up into, if any.
+func (o *objectLister) commonPrefix(key string) (string, bool) {
+	if o.delimiter == "" {
+		return "", false
+	}
+	rest := strings.TrimPrefix(key, o.prefix)
+	i := strings.Index(rest, o.delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return o.prefix + rest[:i+len(o.delimiter)], true
+}
+
+func (o *objectLister) addPrefix(prefix string) error {
+	// A cursor equal to a prefix means the previous page ended on it
+	if prefix == o.lastPrefix || prefix == o.startAfter {
+		return nil
+	}
+	if o.count >= o.maxKeys {
+		o.result.IsTruncated = true
+		return errStopWalk
+	}
+	o.result.CommonPrefixes = append(o.result.CommonPrefixes, prefix)
+	o.result.NextMarker = prefix
+	o.lastPrefix = prefix
+	o.count++
+	return nil
+}
+
+func (o *objectLister) addObject(key string, entry os.DirEntry) error {
+	if prefix, ok := o.commonPrefix(key); ok {
+		return o.addPrefix(prefix)
+	}
+	if o.count >= o.maxKeys {
+		o.result.IsTruncated = true
+		return errStopWalk
+	}
+
+	stat, err := entry.Info()
+	if err != nil {
+		// The object was removed after the directory was read
+		return nil
+	}
+	info, err := o.storage.objectInfo(o.bucket, key, stat)
+	if err != nil {
+		return err
+	}
+
+	o.result.Objects = append(o.result.Objects, *info)
+	o.result.NextMarker = key
+	o.count++
+	return nil
+}
+
+// walk visits dir, whose contents have keys starting with keyPrefix.
+func (o *objectLister) walk(dir, keyPrefix string) error {
+	entries, err := readSortedDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, we := range entries {
+		key := keyPrefix + we.sortKey
+		path := filepath.Join(dir, we.entry.Name())
+
+		if !we.entry.IsDir() {
+			if strings.HasPrefix(key, o.prefix) && key > o.startAfter {
+				if err := o.addObject(key, we.entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Skip subtrees that cannot contain keys matching the prefix
+		if !strings.HasPrefix(key, o.prefix) && !strings.HasPrefix(o.prefix, key) {
+			continue
+		}
+
+		// Skip subtrees whose keys all sort at or before the cursor
+		if key < o.startAfter && !strings.HasPrefix(o.startAfter, key) {
+			continue
+		}
+
+		// When every key below this directory rolls up into the same common
+		// prefix there is no need to descend beyond finding one object
+		if strings.HasPrefix(key, o.prefix) && key >= o.startAfter {
+			if prefix, ok := o.commonPrefix(key); ok && len(prefix) <= len(key) {
+				if hasObjects(path) {
+					if err := o.addPrefix(prefix); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+
+		if err := o.walk(path, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalStorage) listObjects(bucket, prefix, delimiter, startAfter string, maxKeys int) (*ListObjectsResult, error) {
+	lister := &objectLister{
+		storage:    l,
+		bucket:     bucket,
+		prefix:     prefix,
+		delimiter:  delimiter,
+		startAfter: startAfter,
+		maxKeys:    maxKeys,
+	}
+
+	if err := lister.walk(l.bucketPath(bucket), ""); err != nil && err != errStopWalk {
+		return nil, err
+	}
+	if !lister.result.IsTruncated {
+		lister.result.NextMarker = ""
+	}
+
+	return &lister.result, nil
+}
+
+// removeEmptyParents deletes the now-empty directories between path and
+// stopAt left behind after removing a nested key.
+func removeEmptyParents(path, stopAt string) {
+	for dir := filepath.Dir(path); dir != stopAt && strings.HasPrefix(dir, stopAt); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
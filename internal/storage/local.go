@@ -2,18 +2,30 @@ package storage
 
 import (
 	"context"
-	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type LocalStorage struct {
 	rootPath string
+
+	// minPartSize is the smallest size accepted for all but the last part
+	// of a multipart upload
+	minPartSize int64
+
+	// mu serialises changes to which version of an object is current
+	mu sync.Mutex
+
+	// uploads locks multipart uploads while parts are added to them or
+	// they are completed, aborted or reaped
+	uploads uploadLocks
 }
 
 func NewLocalStorage(rootPath string) (*LocalStorage, error) {
@@ -21,7 +33,15 @@ func NewLocalStorage(rootPath string) (*LocalStorage, error) {
 		return nil, err
 	}
 
-	return &LocalStorage{rootPath: rootPath}, nil
+	l := &LocalStorage{rootPath: rootPath, minPartSize: defaultMinPartSize}
+	if err := l.cleanupTempFiles(); err != nil {
+		return nil, fmt.Errorf("failed to clean up temp files: %v", err)
+	}
+	if err := l.migrateVersionDirs(); err != nil {
+		return nil, fmt.Errorf("failed to migrate versions: %v", err)
+	}
+
+	return l, nil
 }
 
 func (l *LocalStorage) bucketPath(bucket string) string {
@@ -33,11 +53,48 @@ func (l *LocalStorage) objectPath(bucket, key string) string {
 }
 
 func (l *LocalStorage) CreateBucket(ctx context.Context, bucket string) error {
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+
 	return os.MkdirAll(l.bucketPath(bucket), 0755)
 }
 
 func (l *LocalStorage) DeleteBucket(ctx context.Context, bucket string) error {
-	return os.Remove(l.bucketPath(bucket))
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(l.bucketPath(bucket))
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrBucketNotEmpty
+	}
+
+	// Noncurrent versions and delete markers also keep a bucket in use
+	versions, err := os.ReadDir(l.versionsRoot(bucket))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(versions) > 0 {
+		return ErrBucketNotEmpty
+	}
+
+	if err := os.Remove(l.bucketPath(bucket)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(l.rootPath, ".meta", bucket)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(l.versionsRoot(bucket)); err != nil {
+		return err
+	}
+	if err := os.Remove(l.bucketConfigPath(bucket)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func (l *LocalStorage) ListBuckets(ctx context.Context) ([]string, error) {
@@ -48,7 +105,8 @@ func (l *LocalStorage) ListBuckets(ctx context.Context) ([]string, error) {
 
 	var buckets []string
 	for _, entry := range entries {
-		if entry.IsDir() {
+		// Internal directories such as .meta and .multipart are not buckets
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
 			buckets = append(buckets, entry.Name())
 		}
 	}
@@ -56,31 +114,80 @@ func (l *LocalStorage) ListBuckets(ctx context.Context) ([]string, error) {
 	return buckets, nil
 }
 
-func (l *LocalStorage) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, contentType string) error {
-	objectPath := l.objectPath(bucket, key)
+func (l *LocalStorage) PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, meta ObjectMetadata) (*ObjectInfo, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, err
+	}
 
-	dir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	// Stage the upload so readers never observe a partially written object;
+	// the content is hashed while streaming so the ETag reflects what was stored
+	staged, err := l.createStagedFile()
+	if err != nil {
+		return nil, err
 	}
+	defer staged.Discard()
 
-	file, err := os.Create(objectPath)
+	if _, err := staged.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	if err := staged.Verify(size, meta.Checksums["MD5"]); err != nil {
+		return nil, err
+	}
+
+	return l.commitObject(bucket, key, staged, newObjectMeta(hex.EncodeToString(staged.MD5()), meta))
+}
+
+// commitObject moves a staged upload into place as the current version of
+// key, archiving the version it replaces when the bucket is versioned.
+func (l *LocalStorage) commitObject(bucket, key string, staged *stagedFile, stored *objectMeta) (*ObjectInfo, error) {
+	cfg, err := l.loadBucketConfig(bucket)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to load bucket config: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.archiveCurrent(bucket, key, cfg.Versioning); err != nil {
+		return nil, fmt.Errorf("failed to archive current version: %v", err)
+	}
+	stored.VersionID = ""
+	if cfg.Versioning == VersioningEnabled {
+		stored.VersionID = newVersionID()
+	}
+
+	if err := staged.Commit(l.objectPath(bucket, key)); err != nil {
+		return nil, err
+	}
+
+	if err := l.writeObjectMeta(bucket, key, stored); err != nil {
+		return nil, fmt.Errorf("failed to write object metadata: %v", err)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, reader)
-	return err
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         staged.written,
+		LastModified: time.Now(),
+	}
+	stored.applyTo(info)
+
+	return info, nil
 }
 
-func (l *LocalStorage) GetObject(ctx context.Context, bucket, key string, rangeHeader string) (io.ReadCloser, *ObjectInfo, error) {
+func (l *LocalStorage) GetObject(ctx context.Context, bucket, key, versionID, rangeHeader string) (io.ReadCloser, *ObjectInfo, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, nil, err
+	}
+	if versionID != "" {
+		return l.getObjectVersion(bucket, key, versionID, rangeHeader)
+	}
+
 	objectPath := l.objectPath(bucket, key)
 
 	file, err := os.Open(objectPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, nil, ErrNotFound
+			return nil, l.currentDeleteMarker(bucket, key), ErrNotFound
 		}
 		return nil, nil, err
 	}
@@ -90,13 +197,16 @@ func (l *LocalStorage) GetObject(ctx context.Context, bucket, key string, rangeH
 		file.Close()
 		return nil, nil, err
 	}
+	if stat.IsDir() {
+		// Directories only exist to hold nested keys
+		file.Close()
+		return nil, l.currentDeleteMarker(bucket, key), ErrNotFound
+	}
 
-	info := &ObjectInfo{
-		Key:          key,
-		Size:         stat.Size(),
-		LastModified: stat.ModTime(),
-		ETag:         fmt.Sprintf("%x", md5.Sum([]byte(key))),
-		ContentType:  "application/octet-stream",
+	info, err := l.objectInfo(bucket, key, stat)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
 	}
 
 	// Handle HTTP Range requests
@@ -107,11 +217,37 @@ func (l *LocalStorage) GetObject(ctx context.Context, bucket, key string, rangeH
 	return file, info, nil
 }
 
+func (l *LocalStorage) getObjectVersion(bucket, key, versionID, rangeHeader string) (io.ReadCloser, *ObjectInfo, error) {
+	if err := validateVersionID(versionID); err != nil {
+		return nil, nil, err
+	}
+
+	path, info, err := l.lookupVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, info, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The version became current or was deleted since the lookup
+			return nil, nil, ErrNoSuchVersion
+		}
+		return nil, nil, err
+	}
+
+	if rangeHeader != "" {
+		return l.handleRangeRequest(file, info, rangeHeader)
+	}
+
+	return file, info, nil
+}
+
 func (l *LocalStorage) handleRangeRequest(file *os.File, info *ObjectInfo, rangeHeader string) (io.ReadCloser, *ObjectInfo, error) {
 	// Parse Range header: "bytes=start-end"
 	if !strings.HasPrefix(rangeHeader, "bytes=") {
 		file.Close()
-		return nil, nil, fmt.Errorf("invalid range header format")
+		return nil, nil, fmt.Errorf("%w: invalid range header format", ErrInvalidRange)
 	}
 
 	rangeSpec := strings.TrimPrefix(rangeHeader, "bytes=")
@@ -119,7 +255,7 @@ func (l *LocalStorage) handleRangeRequest(file *os.File, info *ObjectInfo, range
 
 	if len(rangeParts) != 2 {
 		file.Close()
-		return nil, nil, fmt.Errorf("invalid range specification")
+		return nil, nil, fmt.Errorf("%w: invalid range specification", ErrInvalidRange)
 	}
 
 	var start, end int64
@@ -130,7 +266,7 @@ func (l *LocalStorage) handleRangeRequest(file *os.File, info *ObjectInfo, range
 		start, err = strconv.ParseInt(rangeParts[0], 10, 64)
 		if err != nil {
 			file.Close()
-			return nil, nil, fmt.Errorf("invalid range start: %v", err)
+			return nil, nil, fmt.Errorf("%w: invalid range start: %v", ErrInvalidRange, err)
 		}
 	}
 
@@ -139,7 +275,7 @@ func (l *LocalStorage) handleRangeRequest(file *os.File, info *ObjectInfo, range
 		end, err = strconv.ParseInt(rangeParts[1], 10, 64)
 		if err != nil {
 			file.Close()
-			return nil, nil, fmt.Errorf("invalid range end: %v", err)
+			return nil, nil, fmt.Errorf("%w: invalid range end: %v", ErrInvalidRange, err)
 		}
 	} else {
 		end = info.Size - 1
@@ -148,7 +284,7 @@ func (l *LocalStorage) handleRangeRequest(file *os.File, info *ObjectInfo, range
 	// Validate range
 	if start < 0 || end >= info.Size || start > end {
 		file.Close()
-		return nil, nil, fmt.Errorf("invalid range: %d-%d for size %d", start, end, info.Size)
+		return nil, nil, fmt.Errorf("%w: %d-%d for size %d", ErrInvalidRange, start, end, info.Size)
 	}
 
 	// Seek to start position
@@ -181,88 +317,147 @@ func (r *rangeReadCloser) Close() error {
 	return r.closer.Close()
 }
 
-func (l *LocalStorage) DeleteObject(ctx context.Context, bucket, key string) error {
-	objectPath := l.objectPath(bucket, key)
-	return os.Remove(objectPath)
+func (l *LocalStorage) DeleteObject(ctx context.Context, bucket, key, versionID string) (*ObjectInfo, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, err
+	}
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bucket config: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.deleteObject(bucket, key, versionID, cfg.Versioning)
+}
+
+// DeleteObjects deletes a batch of objects, reporting the outcome of each
+// in order. Only a missing or invalid bucket fails the whole batch.
+func (l *LocalStorage) DeleteObjects(ctx context.Context, bucket string, objects []ObjectIdentifier) ([]DeleteResult, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bucket config: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	results := make([]DeleteResult, len(objects))
+	for i, object := range objects {
+		if err := ValidateObjectKey(object.Key); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Info, results[i].Err = l.deleteObject(bucket, object.Key, object.VersionID, cfg.Versioning)
+	}
+
+	return results, nil
+}
+
+// deleteObject removes key, or one version of it, from a bucket with the
+// given versioning status. The caller must hold l.mu.
+func (l *LocalStorage) deleteObject(bucket, key, versionID, status string) (*ObjectInfo, error) {
+	if versionID != "" {
+		if err := validateVersionID(versionID); err != nil {
+			return nil, err
+		}
+		return l.deleteObjectVersion(bucket, key, versionID)
+	}
+
+	if status != "" {
+		return l.addDeleteMarker(bucket, key, status)
+	}
+
+	// Deleting a missing key succeeds, as in S3
+	if err := l.removeCurrent(bucket, key); err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key}, nil
 }
 
-func (l *LocalStorage) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+func (l *LocalStorage) HeadObject(ctx context.Context, bucket, key, versionID string) (*ObjectInfo, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, err
+	}
+	if versionID != "" {
+		if err := validateVersionID(versionID); err != nil {
+			return nil, err
+		}
+		_, info, err := l.lookupVersion(bucket, key, versionID)
+		return info, err
+	}
+
 	objectPath := l.objectPath(bucket, key)
 
 	stat, err := os.Stat(objectPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, os.ErrNotExist
+			return l.currentDeleteMarker(bucket, key), ErrNotFound
 		}
 		return nil, err
 	}
+	if stat.IsDir() {
+		return l.currentDeleteMarker(bucket, key), ErrNotFound
+	}
 
-	return &ObjectInfo{
-		Key:          key,
-		Size:         stat.Size(),
-		LastModified: stat.ModTime(),
-		ETag:         fmt.Sprintf("%x", md5.Sum([]byte(key))),
-		ContentType:  "application/octet-stream",
-	}, nil
+	return l.objectInfo(bucket, key, stat)
 }
 
-func (l *LocalStorage) ListObjects(ctx context.Context, bucket, prefix, delimiter string, maxKeys int) ([]ObjectInfo, bool, error) {
-	bucketPath := l.bucketPath(bucket)
-
-	entries, err := os.ReadDir(bucketPath)
+func (l *LocalStorage) objectInfo(bucket, key string, stat os.FileInfo) (*ObjectInfo, error) {
+	meta, err := l.loadObjectMeta(bucket, key, stat, true)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, false, os.ErrNotExist
-		}
-		return nil, false, err
+		return nil, fmt.Errorf("failed to load object metadata: %v", err)
 	}
 
-	var objects []ObjectInfo
-	count := 0
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}
+	meta.applyTo(info)
 
-	for _, entry := range entries {
-		if count >= maxKeys {
-			return objects, true, nil
-		}
+	return info, nil
+}
 
-		if !entry.IsDir() {
-			name := entry.Name()
-			if prefix != "" && !strings.HasPrefix(name, prefix) {
-				continue
-			}
-
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-
-			objects = append(objects, ObjectInfo{
-				Key:          name,
-				Size:         info.Size(),
-				LastModified: info.ModTime(),
-				ETag:         fmt.Sprintf("%x", md5.Sum([]byte(name))),
-				ContentType:  "application/octet-stream",
-			})
-			count++
-		}
+func (l *LocalStorage) ListObjects(ctx context.Context, bucket, prefix, delimiter, startAfter string, maxKeys int) (*ListObjectsResult, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
 	}
 
-	return objects, false, nil
+	return l.listObjects(bucket, prefix, delimiter, startAfter, maxKeys)
 }
 
-func (l *LocalStorage) InitMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
-	uploadID := fmt.Sprintf("%d", time.Now().UnixNano())
+func (l *LocalStorage) InitMultipartUpload(ctx context.Context, bucket, key string, meta ObjectMetadata) (string, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return "", err
+	}
 
-	// Create multipart directory
-	multipartDir := filepath.Join(l.rootPath, ".multipart", bucket, uploadID)
-	if err := os.MkdirAll(multipartDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(l.rootPath, ".multipart", bucket), 0755); err != nil {
 		return "", fmt.Errorf("failed to create multipart directory: %v", err)
 	}
 
-	// Store metadata
-	metaFile := filepath.Join(multipartDir, "metadata")
-	metadata := fmt.Sprintf("bucket=%s\nkey=%s\ninitiated=%s\n", bucket, key, time.Now().Format(time.RFC3339))
-	if err := os.WriteFile(metaFile, []byte(metadata), 0644); err != nil {
+	// Mkdir fails rather than reusing the directory of another upload
+	uploadID := newUploadID()
+	multipartDir := l.multipartDir(bucket, uploadID)
+	if err := os.Mkdir(multipartDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create multipart directory: %v", err)
+	}
+
+	// Object metadata is applied to the final object on completion
+	if err := l.writeMetaFile(filepath.Join(multipartDir, "object.json"), newObjectMeta("", meta)); err != nil {
+		return "", fmt.Errorf("failed to write object metadata: %v", err)
+	}
+
+	// The upload becomes visible to part, complete and abort requests once
+	// its record is in place
+	record := &uploadRecord{Bucket: bucket, Key: key, Initiated: time.Now()}
+	if err := l.writeMetaFile(filepath.Join(multipartDir, "upload.json"), record); err != nil {
 		return "", fmt.Errorf("failed to write metadata: %v", err)
 	}
 
@@ -270,120 +465,129 @@ func (l *LocalStorage) InitMultipartUpload(ctx context.Context, bucket, key stri
 }
 
 func (l *LocalStorage) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
-	multipartDir := filepath.Join(l.rootPath, ".multipart", bucket, uploadID)
+	if err := l.checkObject(bucket, key); err != nil {
+		return "", err
+	}
+	if partNumber < MinPartNumber || partNumber > MaxPartNumber {
+		return "", ErrInvalidPartNumber
+	}
 
-	// Check if multipart upload exists
-	if _, err := os.Stat(multipartDir); os.IsNotExist(err) {
-		return "", fmt.Errorf("multipart upload not found")
+	if _, err := l.openUpload(bucket, key, uploadID); err != nil {
+		return "", err
 	}
 
 	// Write part to file
-	partFile := filepath.Join(multipartDir, fmt.Sprintf("part-%05d", partNumber))
-	file, err := os.Create(partFile)
+	staged, err := l.createStagedFile()
 	if err != nil {
 		return "", fmt.Errorf("failed to create part file: %v", err)
 	}
-	defer file.Close()
+	defer staged.Discard()
 
-	hasher := md5.New()
-	writer := io.MultiWriter(file, hasher)
+	if _, err := staged.ReadFrom(reader); err != nil {
+		return "", fmt.Errorf("failed to write part: %w", err)
+	}
+	if err := staged.Verify(size, ""); err != nil {
+		return "", err
+	}
 
-	_, err = io.Copy(writer, reader)
+	// Parts may be added concurrently, but not once the upload is being
+	// completed or removed
+	unlock := l.uploads.rlock(bucket, uploadID)
+	defer unlock()
+	multipartDir, err := l.openUpload(bucket, key, uploadID)
 	if err != nil {
+		return "", err
+	}
+
+	unlockPart := l.uploads.lockPart(bucket, uploadID, partNumber)
+	defer unlockPart()
+
+	// The data is renamed into place last; until it is, the metadata does
+	// not match the part's file and is ignored
+	stat, err := staged.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to write part: %v", err)
+	}
+	partFile := partPath(multipartDir, partNumber)
+	etag := hex.EncodeToString(staged.MD5())
+	meta := &partMeta{ETag: etag, Size: staged.written, ModTime: stat.ModTime().UnixNano()}
+	if err := l.writeMetaFile(partFile+".json", meta); err != nil {
+		return "", fmt.Errorf("failed to write part metadata: %v", err)
+	}
+	if err := staged.Commit(partFile); err != nil {
 		return "", fmt.Errorf("failed to write part: %v", err)
 	}
 
-	etag := fmt.Sprintf("%x", hasher.Sum(nil))
 	return etag, nil
 }
 
-func (l *LocalStorage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
-	multipartDir := filepath.Join(l.rootPath, ".multipart", bucket, uploadID)
+func (l *LocalStorage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) (*ObjectInfo, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, err
+	}
 
-	// Check if multipart upload exists
-	if _, err := os.Stat(multipartDir); os.IsNotExist(err) {
-		return fmt.Errorf("multipart upload not found")
+	unlock := l.uploads.lock(bucket, uploadID)
+	defer unlock()
+	multipartDir, err := l.openUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create final object path
-	objectPath := l.objectPath(bucket, key)
-	objectDir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(objectDir, 0755); err != nil {
-		return fmt.Errorf("failed to create object directory: %v", err)
+	etag, err := l.validateParts(multipartDir, parts)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create final file
-	finalFile, err := os.Create(objectPath)
+	// Assemble the object in a staged file
+	finalFile, err := l.createStagedFile()
 	if err != nil {
-		return fmt.Errorf("failed to create final object: %v", err)
+		return nil, fmt.Errorf("failed to create final object: %v", err)
 	}
-	defer finalFile.Close()
+	defer finalFile.Discard()
 
-	// Concatenate parts in order
 	for _, part := range parts {
-		partFile := filepath.Join(multipartDir, fmt.Sprintf("part-%05d", part.PartNumber))
-		partReader, err := os.Open(partFile)
+		partReader, err := os.Open(partPath(multipartDir, part.PartNumber))
 		if err != nil {
-			return fmt.Errorf("failed to open part %d: %v", part.PartNumber, err)
+			return nil, fmt.Errorf("failed to open part %d: %v", part.PartNumber, err)
 		}
 
 		_, err = io.Copy(finalFile, partReader)
 		partReader.Close()
 		if err != nil {
-			return fmt.Errorf("failed to copy part %d: %v", part.PartNumber, err)
+			return nil, fmt.Errorf("failed to copy part %d: %v", part.PartNumber, err)
 		}
 	}
 
+	stored, err := readMetaFile(filepath.Join(multipartDir, "object.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read object metadata: %v", err)
+		}
+		stored = &objectMeta{}
+	}
+	stored.ETag = etag
+
+	info, err := l.commitObject(bucket, key, finalFile, stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit final object: %v", err)
+	}
+
 	// Clean up multipart directory
 	os.RemoveAll(multipartDir)
 
-	return nil
+	return info, nil
 }
 
 func (l *LocalStorage) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
-	multipartDir := filepath.Join(l.rootPath, ".multipart", bucket, uploadID)
-	return os.RemoveAll(multipartDir)
-}
-
-func (l *LocalStorage) ListMultipartUploads(ctx context.Context, bucket string) ([]MultipartUpload, error) {
-	multipartRoot := filepath.Join(l.rootPath, ".multipart", bucket)
-
-	entries, err := os.ReadDir(multipartRoot)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []MultipartUpload{}, nil
-		}
-		return nil, err
+	if err := l.checkObject(bucket, key); err != nil {
+		return err
 	}
 
-	var uploads []MultipartUpload
-	for _, entry := range entries {
-		if entry.IsDir() {
-			metaFile := filepath.Join(multipartRoot, entry.Name(), "metadata")
-			metaData, err := os.ReadFile(metaFile)
-			if err != nil {
-				continue
-			}
-
-			// Parse metadata
-			lines := strings.Split(string(metaData), "\n")
-			var key, initiated string
-			for _, line := range lines {
-				if strings.HasPrefix(line, "key=") {
-					key = strings.TrimPrefix(line, "key=")
-				} else if strings.HasPrefix(line, "initiated=") {
-					initiated = strings.TrimPrefix(line, "initiated=")
-				}
-			}
-
-			initiatedTime, _ := time.Parse(time.RFC3339, initiated)
-			uploads = append(uploads, MultipartUpload{
-				UploadID:  entry.Name(),
-				Key:       key,
-				Initiated: initiatedTime,
-			})
-		}
+	unlock := l.uploads.lock(bucket, uploadID)
+	defer unlock()
+	multipartDir, err := l.openUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
 	}
-
-	return uploads, nil
+	return os.RemoveAll(multipartDir)
 }
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"os"
 	"path/filepath"
 	"strings"
@@ -49,9 +51,14 @@ func TestLocalStorage(t *testing.T) {
 		content := "test content"
 		reader := strings.NewReader(content)
 
-		err := storage.PutObject(ctx, "test-bucket", "test-object.txt", reader, int64(len(content)), "text/plain")
+		info, err := storage.PutObject(ctx, "test-bucket", "test-object.txt", reader, int64(len(content)), ObjectMetadata{ContentType: "text/plain"})
 		if err != nil {
-			t.Errorf("PutObject failed: %v", err)
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		// md5("test content")
+		if info.ETag != "9473fdd0d880a43c21b7778d34872157" {
+			t.Errorf("Expected content MD5 ETag, got '%s'", info.ETag)
 		}
 
 		objectPath := filepath.Join(tmpDir, "test-bucket", "test-object.txt")
@@ -61,7 +68,7 @@ func TestLocalStorage(t *testing.T) {
 	})
 
 	t.Run("GetObject", func(t *testing.T) {
-		reader, info, err := storage.GetObject(ctx, "test-bucket", "test-object.txt", "")
+		reader, info, err := storage.GetObject(ctx, "test-bucket", "test-object.txt", "", "")
 		if err != nil {
 			t.Errorf("GetObject failed: %v", err)
 		}
@@ -87,7 +94,7 @@ func TestLocalStorage(t *testing.T) {
 	})
 
 	t.Run("HeadObject", func(t *testing.T) {
-		info, err := storage.HeadObject(ctx, "test-bucket", "test-object.txt")
+		info, err := storage.HeadObject(ctx, "test-bucket", "test-object.txt", "")
 		if err != nil {
 			t.Errorf("HeadObject failed: %v", err)
 		}
@@ -99,15 +106,181 @@ func TestLocalStorage(t *testing.T) {
 		if info.Size != 12 {
 			t.Errorf("Expected size 12, got %d", info.Size)
 		}
+
+		if info.ETag != "9473fdd0d880a43c21b7778d34872157" {
+			t.Errorf("Expected content MD5 ETag, got '%s'", info.ETag)
+		}
+	})
+
+	t.Run("ETagTracksContent", func(t *testing.T) {
+		_, err := storage.PutObject(ctx, "test-bucket", "etag-object.txt", strings.NewReader("first"), 5, ObjectMetadata{ContentType: "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, err := storage.HeadObject(ctx, "test-bucket", "etag-object.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = storage.PutObject(ctx, "test-bucket", "etag-object.txt", strings.NewReader("second"), 6, ObjectMetadata{ContentType: "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := storage.HeadObject(ctx, "test-bucket", "etag-object.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if first.ETag == second.ETag {
+			t.Errorf("Expected ETag to change after overwrite, got '%s' both times", first.ETag)
+		}
+
+		if _, err := storage.DeleteObject(ctx, "test-bucket", "etag-object.txt", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ETagForUntrackedObject", func(t *testing.T) {
+		// Objects placed on disk directly have no metadata sidecar yet
+		objectPath := filepath.Join(tmpDir, "test-bucket", "untracked.txt")
+		if err := os.WriteFile(objectPath, []byte("test content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.HeadObject(ctx, "test-bucket", "untracked.txt", "")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+
+		if info.ETag != "9473fdd0d880a43c21b7778d34872157" {
+			t.Errorf("Expected content MD5 ETag, got '%s'", info.ETag)
+		}
+
+		if _, err := storage.DeleteObject(ctx, "test-bucket", "untracked.txt", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("StaleMetadataAfterCrash", func(t *testing.T) {
+		_, err := storage.PutObject(ctx, "test-bucket", "crashed.txt", strings.NewReader("first"), 5, ObjectMetadata{ContentType: "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A crash after the new data was renamed into place but before its
+		// metadata was written
+		objectPath := filepath.Join(tmpDir, "test-bucket", "crashed.txt")
+		if err := os.WriteFile(objectPath+".new", []byte("test content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(objectPath+".new", objectPath); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.HeadObject(ctx, "test-bucket", "crashed.txt", "")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if info.ETag != "9473fdd0d880a43c21b7778d34872157" || info.ContentType != defaultContentType {
+			t.Errorf("Expected metadata of the new data, got ETag '%s' and Content-Type '%s'", info.ETag, info.ContentType)
+		}
+		stat, err := os.Stat(objectPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta, err := storage.readObjectMeta("test-bucket", "crashed.txt"); err != nil || !meta.describes(stat) {
+			t.Errorf("Expected the stale metadata to be replaced, got %+v (%v)", meta, err)
+		}
+
+		if _, err := storage.DeleteObject(ctx, "test-bucket", "crashed.txt", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("BackfillRacingPut", func(t *testing.T) {
+		objectPath := filepath.Join(tmpDir, "test-bucket", "backfilled.txt")
+		if err := os.WriteFile(objectPath, []byte("legacy"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta, hashed, err := storage.hashObject("test-bucket", "backfilled.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta.stamp(hashed)
+
+		// A write lands between hashing the legacy object and storing the
+		// result
+		_, err = storage.PutObject(ctx, "test-bucket", "backfilled.txt", strings.NewReader("new"), 3, ObjectMetadata{ContentType: "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.backfillObjectMeta("test-bucket", "backfilled.txt", meta, hashed); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.HeadObject(ctx, "test-bucket", "backfilled.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContentType != "text/plain" || info.ETag == meta.ETag {
+			t.Errorf("Expected the new object's metadata to be kept, got ETag '%s' and Content-Type '%s'", info.ETag, info.ContentType)
+		}
+
+		if _, err := storage.DeleteObject(ctx, "test-bucket", "backfilled.txt", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ObjectMetadata", func(t *testing.T) {
+		meta := ObjectMetadata{
+			ContentType:        "application/json",
+			ContentEncoding:    "gzip",
+			ContentDisposition: "attachment; filename=\"data.json\"",
+			CacheControl:       "max-age=60",
+			UserMetadata:       map[string]string{"owner": "porter"},
+			Checksums:          map[string]string{"SHA256": "checksum"},
+		}
+		if _, err := storage.PutObject(ctx, "test-bucket", "meta-object.json", strings.NewReader("{}"), 2, meta); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.HeadObject(ctx, "test-bucket", "meta-object.json", "")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+
+		if info.ContentType != meta.ContentType {
+			t.Errorf("Expected Content-Type '%s', got '%s'", meta.ContentType, info.ContentType)
+		}
+		if info.ContentEncoding != meta.ContentEncoding {
+			t.Errorf("Expected Content-Encoding '%s', got '%s'", meta.ContentEncoding, info.ContentEncoding)
+		}
+		if info.ContentDisposition != meta.ContentDisposition {
+			t.Errorf("Expected Content-Disposition '%s', got '%s'", meta.ContentDisposition, info.ContentDisposition)
+		}
+		if info.CacheControl != meta.CacheControl {
+			t.Errorf("Expected Cache-Control '%s', got '%s'", meta.CacheControl, info.CacheControl)
+		}
+		if info.UserMetadata["owner"] != "porter" {
+			t.Errorf("Expected user metadata owner=porter, got %v", info.UserMetadata)
+		}
+		if info.Checksums["SHA256"] != "checksum" {
+			t.Errorf("Expected SHA256 checksum, got %v", info.Checksums)
+		}
+
+		if _, err := storage.DeleteObject(ctx, "test-bucket", "meta-object.json", ""); err != nil {
+			t.Fatal(err)
+		}
 	})
 
 	t.Run("ListObjects", func(t *testing.T) {
-		objects, isTruncated, err := storage.ListObjects(ctx, "test-bucket", "", "", 1000)
+		result, err := storage.ListObjects(ctx, "test-bucket", "", "", "", 1000)
 		if err != nil {
-			t.Errorf("ListObjects failed: %v", err)
+			t.Fatalf("ListObjects failed: %v", err)
 		}
+		objects := result.Objects
 
-		if isTruncated {
+		if result.IsTruncated {
 			t.Error("Expected isTruncated to be false")
 		}
 
@@ -118,10 +291,14 @@ func TestLocalStorage(t *testing.T) {
 		if objects[0].Key != "test-object.txt" {
 			t.Errorf("Expected key 'test-object.txt', got '%s'", objects[0].Key)
 		}
+
+		if objects[0].ETag != "9473fdd0d880a43c21b7778d34872157" {
+			t.Errorf("Expected content MD5 ETag, got '%s'", objects[0].ETag)
+		}
 	})
 
 	t.Run("DeleteObject", func(t *testing.T) {
-		err := storage.DeleteObject(ctx, "test-bucket", "test-object.txt")
+		_, err := storage.DeleteObject(ctx, "test-bucket", "test-object.txt", "")
 		if err != nil {
 			t.Errorf("DeleteObject failed: %v", err)
 		}
@@ -132,6 +309,40 @@ func TestLocalStorage(t *testing.T) {
 		}
 	})
 
+	t.Run("DeleteObjects", func(t *testing.T) {
+		for _, key := range []string{"batch/a.txt", "batch/b.txt"} {
+			if _, err := storage.PutObject(ctx, "test-bucket", key, strings.NewReader("x"), 1, ObjectMetadata{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		results, err := storage.DeleteObjects(ctx, "test-bucket", []ObjectIdentifier{
+			{Key: "batch/a.txt"},
+			{Key: "../escape"},
+			{Key: "batch/b.txt"},
+			{Key: "batch/missing.txt"},
+		})
+		if err != nil {
+			t.Fatalf("DeleteObjects failed: %v", err)
+		}
+		if len(results) != 4 {
+			t.Fatalf("Expected 4 results, got %d", len(results))
+		}
+		for i, want := range []error{nil, ErrInvalidObjectName, nil, nil} {
+			if results[i].Err != want {
+				t.Errorf("Result %d: expected %v, got %v", i, want, results[i].Err)
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(tmpDir, "test-bucket", "batch")); !os.IsNotExist(err) {
+			t.Error("Expected batch directory to be removed with its last object")
+		}
+
+		if _, err := storage.DeleteObjects(ctx, "missing-bucket", []ObjectIdentifier{{Key: "a"}}); err != ErrBucketNotFound {
+			t.Errorf("Expected ErrBucketNotFound, got %v", err)
+		}
+	})
+
 	t.Run("DeleteBucket", func(t *testing.T) {
 		err := storage.DeleteBucket(ctx, "test-bucket")
 		if err != nil {
@@ -144,3 +355,334 @@ func TestLocalStorage(t *testing.T) {
 		}
 	})
 }
+
+func TestAtomicWrites(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-atomic-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Simulate a write orphaned by a crash
+	orphan := filepath.Join(tmpDir, ".tmp", "upload-orphan")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OrphanedTempFilesRemoved", func(t *testing.T) {
+		if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+			t.Error("Orphaned temp file was not removed on startup")
+		}
+	})
+
+	t.Run("ShortBody", func(t *testing.T) {
+		_, err := storage.PutObject(ctx, bucket, "short.txt", strings.NewReader("abc"), 10, ObjectMetadata{})
+		if err != ErrIncompleteBody {
+			t.Errorf("Expected ErrIncompleteBody, got %v", err)
+		}
+
+		if _, err := storage.HeadObject(ctx, bucket, "short.txt", ""); err != ErrNotFound {
+			t.Errorf("Expected truncated object to be invisible, got %v", err)
+		}
+	})
+
+	t.Run("ContentMD5Mismatch", func(t *testing.T) {
+		sum := md5.Sum([]byte("other content"))
+		meta := ObjectMetadata{Checksums: map[string]string{"MD5": base64.StdEncoding.EncodeToString(sum[:])}}
+
+		_, err := storage.PutObject(ctx, bucket, "digest.txt", strings.NewReader("test content"), 12, meta)
+		if err != ErrBadDigest {
+			t.Errorf("Expected ErrBadDigest, got %v", err)
+		}
+
+		if _, err := storage.HeadObject(ctx, bucket, "digest.txt", ""); err != ErrNotFound {
+			t.Errorf("Expected rejected object to be invisible, got %v", err)
+		}
+	})
+
+	t.Run("ContentMD5Match", func(t *testing.T) {
+		sum := md5.Sum([]byte("test content"))
+		meta := ObjectMetadata{Checksums: map[string]string{"MD5": base64.StdEncoding.EncodeToString(sum[:])}}
+
+		if _, err := storage.PutObject(ctx, bucket, "digest.txt", strings.NewReader("test content"), 12, meta); err != nil {
+			t.Errorf("PutObject failed: %v", err)
+		}
+	})
+
+	t.Run("FailedOverwriteKeepsPrevious", func(t *testing.T) {
+		if _, err := storage.PutObject(ctx, bucket, "keep.txt", strings.NewReader("original"), 8, ObjectMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.PutObject(ctx, bucket, "keep.txt", strings.NewReader("new"), 100, ObjectMetadata{}); err == nil {
+			t.Fatal("Expected short overwrite to fail")
+		}
+
+		data, err := os.ReadFile(filepath.Join(tmpDir, bucket, "keep.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "original" {
+			t.Errorf("Expected previous content 'original', got '%s'", string(data))
+		}
+	})
+
+	t.Run("NoTempFilesLeft", func(t *testing.T) {
+		entries, err := os.ReadDir(filepath.Join(tmpDir, ".tmp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("Expected empty temp directory, found %d entries", len(entries))
+		}
+	})
+}
+
+func TestListObjectsHierarchy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-list-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"a/b/c.txt",
+		"a/d.txt",
+		"a-b.txt",
+		"b.txt",
+		"photos/2024/jan.jpg",
+		"photos/2024/feb.jpg",
+		"photos/2025/mar.jpg",
+	}
+	for _, key := range keys {
+		if _, err := storage.PutObject(ctx, bucket, key, strings.NewReader(key), int64(len(key)), ObjectMetadata{}); err != nil {
+			t.Fatalf("PutObject %s failed: %v", key, err)
+		}
+	}
+
+	listKeys := func(result *ListObjectsResult) []string {
+		var keys []string
+		for _, obj := range result.Objects {
+			keys = append(keys, obj.Key)
+		}
+		return keys
+	}
+
+	t.Run("RecursiveLexicographic", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		expected := []string{
+			"a-b.txt",
+			"a/b/c.txt",
+			"a/d.txt",
+			"b.txt",
+			"photos/2024/feb.jpg",
+			"photos/2024/jan.jpg",
+			"photos/2025/mar.jpg",
+		}
+		if got := listKeys(result); strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("Delimiter", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "", "/", "", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		if got := listKeys(result); strings.Join(got, ",") != "a-b.txt,b.txt" {
+			t.Errorf("Expected top-level objects [a-b.txt b.txt], got %v", got)
+		}
+		if strings.Join(result.CommonPrefixes, ",") != "a/,photos/" {
+			t.Errorf("Expected common prefixes [a/ photos/], got %v", result.CommonPrefixes)
+		}
+	})
+
+	t.Run("PrefixAndDelimiter", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "photos/", "/", "", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		if len(result.Objects) != 0 {
+			t.Errorf("Expected no objects, got %v", listKeys(result))
+		}
+		if strings.Join(result.CommonPrefixes, ",") != "photos/2024/,photos/2025/" {
+			t.Errorf("Expected common prefixes [photos/2024/ photos/2025/], got %v", result.CommonPrefixes)
+		}
+	})
+
+	t.Run("PartialPrefix", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "photos/2024/j", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		if got := listKeys(result); strings.Join(got, ",") != "photos/2024/jan.jpg" {
+			t.Errorf("Expected [photos/2024/jan.jpg], got %v", got)
+		}
+	})
+
+	t.Run("MaxKeysCountsPrefixes", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "", "/", "", 2)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		if !result.IsTruncated {
+			t.Error("Expected truncated listing")
+		}
+		if len(result.Objects)+len(result.CommonPrefixes) != 2 {
+			t.Errorf("Expected 2 entries, got %d objects and %d prefixes", len(result.Objects), len(result.CommonPrefixes))
+		}
+	})
+
+	t.Run("Paging", func(t *testing.T) {
+		for _, delimiter := range []string{"", "/"} {
+			var entries []string
+			cursor := ""
+			for page := 0; page < 10; page++ {
+				result, err := storage.ListObjects(ctx, bucket, "", delimiter, cursor, 2)
+				if err != nil {
+					t.Fatalf("ListObjects failed: %v", err)
+				}
+				entries = append(entries, listKeys(result)...)
+				entries = append(entries, result.CommonPrefixes...)
+				if !result.IsTruncated {
+					break
+				}
+				cursor = result.NextMarker
+			}
+
+			full, err := storage.ListObjects(ctx, bucket, "", delimiter, "", 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := len(full.Objects) + len(full.CommonPrefixes)
+			if len(entries) != expected {
+				t.Errorf("Delimiter %q: expected %d entries across pages, got %v", delimiter, expected, entries)
+			}
+
+			seen := make(map[string]bool)
+			for _, entry := range entries {
+				if seen[entry] {
+					t.Errorf("Delimiter %q: entry %q returned on more than one page", delimiter, entry)
+				}
+				seen[entry] = true
+			}
+		}
+	})
+
+	t.Run("StartAfter", func(t *testing.T) {
+		result, err := storage.ListObjects(ctx, bucket, "", "", "a/d.txt", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+
+		if got := listKeys(result); len(got) == 0 || got[0] != "b.txt" {
+			t.Errorf("Expected listing to resume at 'b.txt', got %v", got)
+		}
+	})
+
+	t.Run("DeletePrunesEmptyDirectories", func(t *testing.T) {
+		if _, err := storage.DeleteObject(ctx, bucket, "photos/2025/mar.jpg", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(tmpDir, bucket, "photos", "2025")); !os.IsNotExist(err) {
+			t.Error("Expected empty directory to be removed")
+		}
+
+		result, err := storage.ListObjects(ctx, bucket, "photos/", "/", "", 1000)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		if strings.Join(result.CommonPrefixes, ",") != "photos/2024/" {
+			t.Errorf("Expected common prefixes [photos/2024/], got %v", result.CommonPrefixes)
+		}
+	})
+}
+
+func TestBucketPolicy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-policy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.GetBucketPolicy(ctx, bucket); err != ErrNoSuchBucketPolicy {
+		t.Errorf("Expected ErrNoSuchBucketPolicy, got %v", err)
+	}
+	if err := storage.PutBucketPolicy(ctx, bucket, []byte("{not json")); err != ErrMalformedPolicy {
+		t.Errorf("Expected ErrMalformedPolicy, got %v", err)
+	}
+	if err := storage.PutBucketPolicy(ctx, "missing-bucket", []byte("{}")); err != ErrBucketNotFound {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+
+	policy := `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::test-bucket/*"}]}`
+	if err := storage.PutBucketPolicy(ctx, bucket, []byte(policy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PutBucketVersioning(ctx, bucket, VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != policy {
+		t.Errorf("Expected %s, got %s", policy, got)
+	}
+
+	if err := storage.DeleteBucketPolicy(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.GetBucketPolicy(ctx, bucket); err != ErrNoSuchBucketPolicy {
+		t.Errorf("Expected ErrNoSuchBucketPolicy after delete, got %v", err)
+	}
+	if status, _ := storage.GetBucketVersioning(ctx, bucket); status != VersioningEnabled {
+		t.Errorf("Expected versioning to stay enabled, got '%s'", status)
+	}
+}
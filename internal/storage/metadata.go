@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// objectMeta is persisted next to every object under .meta/<bucket>/<key>.json
+// so read paths can report the content hash and client metadata without
+// re-reading the data. DataSize and DataModTime identify the data file it
+// was written for, since the file is replaced before its metadata.
+type objectMeta struct {
+	ETag               string            `json:"etag"`
+	VersionID          string            `json:"version_id,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	UserMetadata       map[string]string `json:"user_metadata,omitempty"`
+	Checksums          map[string]string `json:"checksums,omitempty"`
+	DataSize           int64             `json:"data_size,omitempty"`
+	DataModTime        int64             `json:"data_mod_time,omitempty"`
+}
+
+func newObjectMeta(etag string, meta ObjectMetadata) *objectMeta {
+	return &objectMeta{
+		ETag:               etag,
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		ContentDisposition: meta.ContentDisposition,
+		CacheControl:       meta.CacheControl,
+		UserMetadata:       meta.UserMetadata,
+		Checksums:          meta.Checksums,
+	}
+}
+
+// applyTo copies the stored metadata onto info.
+func (m *objectMeta) applyTo(info *ObjectInfo) {
+	info.ETag = m.ETag
+	info.VersionID = m.VersionID
+	info.ContentType = m.ContentType
+	if info.ContentType == "" {
+		info.ContentType = defaultContentType
+	}
+	info.ContentEncoding = m.ContentEncoding
+	info.ContentDisposition = m.ContentDisposition
+	info.CacheControl = m.CacheControl
+	info.UserMetadata = m.UserMetadata
+	info.Checksums = m.Checksums
+}
+
+func (l *LocalStorage) metaPath(bucket, key string) string {
+	return filepath.Join(l.rootPath, ".meta", bucket, key+".json")
+}
+
+func (l *LocalStorage) readObjectMeta(bucket, key string) (*objectMeta, error) {
+	return readMetaFile(l.metaPath(bucket, key))
+}
+
+// stamp records stat as the data file meta describes.
+func (m *objectMeta) stamp(stat os.FileInfo) {
+	m.DataSize = stat.Size()
+	m.DataModTime = stat.ModTime().UnixNano()
+}
+
+// describes reports whether meta was written for the data file stat.
+// Metadata written before data files were recorded is trusted.
+func (m *objectMeta) describes(stat os.FileInfo) bool {
+	if m.DataModTime == 0 {
+		return true
+	}
+	return m.DataSize == stat.Size() && m.DataModTime == stat.ModTime().UnixNano()
+}
+
+// writeObjectMeta stores meta for the data file currently at the object
+// path. Callers hold l.mu so the file cannot be replaced meanwhile.
+func (l *LocalStorage) writeObjectMeta(bucket, key string, meta *objectMeta) error {
+	stat, err := os.Stat(l.objectPath(bucket, key))
+	if err != nil {
+		return err
+	}
+	meta.stamp(stat)
+	return l.writeMetaFile(l.metaPath(bucket, key), meta)
+}
+
+func (l *LocalStorage) deleteObjectMeta(bucket, key string) error {
+	metaPath := l.metaPath(bucket, key)
+	err := os.Remove(metaPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeEmptyParents(metaPath, filepath.Join(l.rootPath, ".meta", bucket))
+	return nil
+}
+
+func readMetaFile(path string) (*objectMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta objectMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (l *LocalStorage) writeMetaFile(path string, meta interface{}) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return l.writeFileAtomic(path, data)
+}
+
+// loadObjectMeta returns the stored metadata for the object whose data file
+// is stat. Objects without metadata describing that file, because they were
+// written before metadata was tracked or a write was interrupted by a
+// crash, are hashed instead. With backfill the result is persisted; callers
+// already holding l.mu pass false and leave that to a later read.
+func (l *LocalStorage) loadObjectMeta(bucket, key string, stat os.FileInfo, backfill bool) (*objectMeta, error) {
+	meta, err := l.readObjectMeta(bucket, key)
+	if err == nil && meta.describes(stat) {
+		return meta, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	meta, hashed, err := l.hashObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	meta.stamp(hashed)
+	if backfill {
+		if err := l.backfillObjectMeta(bucket, key, meta, hashed); err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
+}
+
+// backfillObjectMeta persists the metadata of the hashed data file unless a
+// write replaced the file or its metadata meanwhile. Writes hold l.mu from
+// renaming the data into place until its metadata is stored, so under the
+// lock a sidecar that does not describe the current file is left over from
+// a crash.
+func (l *LocalStorage) backfillObjectMeta(bucket, key string, meta *objectMeta, hashed os.FileInfo) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, err := os.Stat(l.objectPath(bucket, key))
+	if err != nil || !os.SameFile(current, hashed) || !meta.describes(current) {
+		return nil
+	}
+	stored, err := l.readObjectMeta(bucket, key)
+	if err == nil && stored.describes(current) {
+		return nil
+	}
+
+	return l.writeMetaFile(l.metaPath(bucket, key), meta)
+}
+
+// hashObject returns metadata holding only the ETag of an object's data,
+// and the data file it hashed.
+func (l *LocalStorage) hashObject(bucket, key string) (*objectMeta, os.FileInfo, error) {
+	file, err := os.Open(l.objectPath(bucket, key))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, nil, err
+	}
+
+	return &objectMeta{ETag: hex.EncodeToString(hasher.Sum(nil))}, stat, nil
+}
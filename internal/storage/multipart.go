@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MinPartNumber and MaxPartNumber bound the part numbers S3 accepts.
+	MinPartNumber = 1
+	MaxPartNumber = 10000
+
+	// defaultMinPartSize is the S3 minimum size of every part but the last.
+	defaultMinPartSize = 5 << 20
+)
+
+// partMeta is stored next to each part as part-NNNNN.json so completion can
+// validate the parts a client lists without re-reading them. ModTime is
+// that of the data file it was written for, which is renamed into place
+// after its metadata.
+type partMeta struct {
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time,omitempty"`
+}
+
+// uploadLocks holds a read-write lock per multipart upload in use. Adding
+// a part takes the read lock; completing, aborting and reaping an upload
+// take the write lock, so they never remove parts from under each other.
+// Each part number also has its own lock, so two uploads of the same part
+// cannot mix one's data with the other's metadata.
+type uploadLocks struct {
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+type uploadLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// acquire returns the lock named id, creating it on first use.
+func (u *uploadLocks) acquire(id string) (*uploadLock, func()) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.locks == nil {
+		u.locks = make(map[string]*uploadLock)
+	}
+	lock, ok := u.locks[id]
+	if !ok {
+		lock = &uploadLock{}
+		u.locks[id] = lock
+	}
+	lock.refs++
+
+	return lock, func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(u.locks, id)
+		}
+	}
+}
+
+// lock takes the write lock of an upload and returns its unlock function.
+func (u *uploadLocks) lock(bucket, uploadID string) func() {
+	lock, release := u.acquire(bucket + "/" + uploadID)
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		release()
+	}
+}
+
+// rlock takes the read lock of an upload and returns its unlock function.
+func (u *uploadLocks) rlock(bucket, uploadID string) func() {
+	lock, release := u.acquire(bucket + "/" + uploadID)
+	lock.RLock()
+	return func() {
+		lock.RUnlock()
+		release()
+	}
+}
+
+// lockPart takes the lock of one part number of an upload and returns its
+// unlock function. Callers hold the upload's read lock.
+func (u *uploadLocks) lockPart(bucket, uploadID string, partNumber int) func() {
+	lock, release := u.acquire(fmt.Sprintf("%s/%s/%d", bucket, uploadID, partNumber))
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		release()
+	}
+}
+
+func (l *LocalStorage) multipartDir(bucket, uploadID string) string {
+	return filepath.Join(l.rootPath, ".multipart", bucket, uploadID)
+}
+
+// newUploadID returns an unguessable upload ID. IDs are URL-safe so they can
+// be used as a directory name and in query strings without escaping.
+func newUploadID() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// openUpload returns the directory of an upload after checking that it was
+// initiated for bucket and key. Unknown IDs and IDs recorded for another
+// object are both reported as ErrNoSuchUpload.
+func (l *LocalStorage) openUpload(bucket, key, uploadID string) (string, error) {
+	if err := validateUploadID(uploadID); err != nil {
+		return "", err
+	}
+
+	multipartDir := l.multipartDir(bucket, uploadID)
+	upload, err := readUpload(multipartDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoSuchUpload
+		}
+		return "", fmt.Errorf("failed to read upload metadata: %v", err)
+	}
+	if upload.Bucket != bucket || upload.Key != key {
+		return "", ErrNoSuchUpload
+	}
+
+	return multipartDir, nil
+}
+
+func partPath(multipartDir string, partNumber int) string {
+	return filepath.Join(multipartDir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+// readPartMeta returns the stored ETag and size of a part. Parts uploaded
+// before the sidecar existed, and parts whose sidecar was written for data
+// that never replaced them, are hashed instead.
+func readPartMeta(multipartDir string, partNumber int) (*partMeta, error) {
+	path := partPath(multipartDir, partNumber)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path + ".json")
+	if err == nil {
+		var meta partMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+		if meta.Size == stat.Size() && (meta.ModTime == 0 || meta.ModTime == stat.ModTime().UnixNano()) {
+			return &meta, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partMeta{ETag: hex.EncodeToString(hasher.Sum(nil)), Size: size}, nil
+}
+
+// validateParts checks the parts listed in a completion request against the
+// uploaded ones and returns the S3 composite ETag of the object they form:
+// md5(md5(part1) || md5(part2) || ...)-N.
+func (l *LocalStorage) validateParts(multipartDir string, parts []Part) (string, error) {
+	if len(parts) == 0 {
+		return "", ErrInvalidPart
+	}
+
+	compositeHasher := md5.New()
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return "", ErrInvalidPartOrder
+		}
+		if part.PartNumber < MinPartNumber || part.PartNumber > MaxPartNumber {
+			return "", ErrInvalidPart
+		}
+
+		meta, err := readPartMeta(multipartDir, part.PartNumber)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", ErrInvalidPart
+			}
+			return "", fmt.Errorf("failed to read part %d: %v", part.PartNumber, err)
+		}
+
+		if strings.Trim(part.ETag, "\"") != meta.ETag {
+			return "", ErrInvalidPart
+		}
+		if i < len(parts)-1 && meta.Size < l.minPartSize {
+			return "", ErrEntityTooSmall
+		}
+
+		digest, err := hex.DecodeString(meta.ETag)
+		if err != nil {
+			return "", fmt.Errorf("invalid stored ETag for part %d: %v", part.PartNumber, err)
+		}
+		compositeHasher.Write(digest)
+	}
+
+	return fmt.Sprintf("%x-%d", compositeHasher.Sum(nil), len(parts)), nil
+}
+
+// ListParts lists the parts uploaded so far, so an interrupted upload can be
+// resumed by sending only the missing ones.
+func (l *LocalStorage) ListParts(ctx context.Context, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListPartsResult, error) {
+	if err := l.checkObject(bucket, key); err != nil {
+		return nil, err
+	}
+	multipartDir, err := l.openUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if maxParts < 1 {
+		return &ListPartsResult{}, nil
+	}
+
+	entries, err := os.ReadDir(multipartDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchUpload
+		}
+		return nil, err
+	}
+
+	// ReadDir sorts by name and part files are zero-padded, so the parts
+	// come back in part number order
+	result := &ListPartsResult{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "part-") || strings.HasSuffix(name, ".json") {
+			continue
+		}
+		partNumber, err := strconv.Atoi(strings.TrimPrefix(name, "part-"))
+		if err != nil || partNumber <= partNumberMarker {
+			continue
+		}
+
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
+			break
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			// The upload was completed or aborted while listing
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		meta, err := readPartMeta(multipartDir, partNumber)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read part %d: %v", partNumber, err)
+		}
+
+		result.Parts = append(result.Parts, PartInfo{
+			PartNumber:   partNumber,
+			Size:         meta.Size,
+			ETag:         meta.ETag,
+			LastModified: fileInfo.ModTime(),
+		})
+	}
+
+	return result, nil
+}
+
+// uploadRecord is stored as upload.json in the directory of each upload.
+type uploadRecord struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// readUpload returns the record of the upload stored in multipartDir.
+// Uploads initiated before records were JSON are read from their legacy
+// metadata file.
+func readUpload(multipartDir string) (*MultipartUpload, error) {
+	upload := &MultipartUpload{UploadID: filepath.Base(multipartDir)}
+
+	data, err := os.ReadFile(filepath.Join(multipartDir, "upload.json"))
+	if os.IsNotExist(err) {
+		if legacy, legacyErr := os.ReadFile(filepath.Join(multipartDir, "metadata")); legacyErr == nil {
+			parseLegacyUpload(upload, string(legacy))
+			return upload, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record uploadRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	upload.Bucket = record.Bucket
+	upload.Key = record.Key
+	upload.Initiated = record.Initiated
+	return upload, nil
+}
+
+// parseLegacyUpload reads the bucket=, key= and initiated= lines of a
+// legacy metadata file. The bucket was always written first, so only the
+// first line of each kind counts and a key cannot inject another bucket.
+func parseLegacyUpload(upload *MultipartUpload, data string) {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "bucket":
+			upload.Bucket = value
+		case "key":
+			upload.Key = value
+		case "initiated":
+			upload.Initiated, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+}
+
+// uploadCursor returns the NextUploadIDMarker for upload. Uploads of a key
+// are ordered by initiation time and IDs are random, so the cursor carries
+// the initiation time to resume from after the upload itself is gone.
+func uploadCursor(upload MultipartUpload) string {
+	return upload.UploadID + "." + strconv.FormatInt(upload.Initiated.UnixNano(), 10)
+}
+
+// parseUploadCursor returns the upload ID and initiation time of an
+// uploadIDMarker. A bare upload ID, as taken from a listed upload, has the
+// zero time.
+func parseUploadCursor(marker string) (string, time.Time) {
+	uploadID, nanos, ok := strings.Cut(marker, ".")
+	if !ok {
+		return marker, time.Time{}
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return marker, time.Time{}
+	}
+	return uploadID, time.Unix(0, n)
+}
+
+// uploadBefore reports whether a sorts before b among the uploads of a key.
+func uploadBefore(a, b MultipartUpload) bool {
+	if !a.Initiated.Equal(b.Initiated) {
+		return a.Initiated.Before(b.Initiated)
+	}
+	return a.UploadID < b.UploadID
+}
+
+// ListMultipartUploads lists the uploads of a bucket after the keyMarker and
+// uploadIDMarker cursor. Without an uploadIDMarker every upload of keyMarker
+// itself is skipped, as in S3.
+func (l *LocalStorage) ListMultipartUploads(ctx context.Context, bucket, prefix, delimiter, keyMarker, uploadIDMarker string, maxUploads int) (*ListMultipartUploadsResult, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	multipartRoot := filepath.Join(l.rootPath, ".multipart", bucket)
+	entries, err := os.ReadDir(multipartRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var uploads []MultipartUpload
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		upload, err := readUpload(filepath.Join(multipartRoot, entry.Name()))
+		if err != nil {
+			// Initiation has not written its metadata yet
+			continue
+		}
+		if strings.HasPrefix(upload.Key, prefix) {
+			uploads = append(uploads, *upload)
+		}
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		a, b := uploads[i], uploads[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return uploadBefore(a, b)
+	})
+
+	// The uploads of keyMarker resume after the marker upload, found by ID
+	// while it exists and by the time in the cursor once it has been
+	// completed or aborted.
+	var marker MultipartUpload
+	if uploadIDMarker != "" {
+		marker.UploadID, marker.Initiated = parseUploadCursor(uploadIDMarker)
+		for _, upload := range uploads {
+			if upload.Key == keyMarker && upload.UploadID == marker.UploadID {
+				marker.Initiated = upload.Initiated
+				break
+			}
+		}
+	}
+
+	lister := &objectLister{prefix: prefix, delimiter: delimiter}
+	result := &ListMultipartUploadsResult{}
+	count := 0
+
+	for _, upload := range uploads {
+		if upload.Key < keyMarker || (upload.Key == keyMarker && uploadIDMarker == "") {
+			continue
+		}
+		if upload.Key == keyMarker && !uploadBefore(marker, upload) {
+			continue
+		}
+
+		if commonPrefix, ok := lister.commonPrefix(upload.Key); ok {
+			// A marker inside the prefix means it was returned already
+			if commonPrefix == lister.lastPrefix || strings.HasPrefix(keyMarker, commonPrefix) {
+				continue
+			}
+			if count >= maxUploads {
+				result.IsTruncated = true
+				break
+			}
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+			result.NextKeyMarker = commonPrefix
+			result.NextUploadIDMarker = ""
+			lister.lastPrefix = commonPrefix
+			count++
+			continue
+		}
+
+		if count >= maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, upload)
+		result.NextKeyMarker = upload.Key
+		result.NextUploadIDMarker = uploadCursor(upload)
+		count++
+	}
+
+	if !result.IsTruncated {
+		result.NextKeyMarker = ""
+		result.NextUploadIDMarker = ""
+	}
+
+	return result, nil
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func (l *LocalStorage) ReapMultipartUploads(ctx context.Context, now time.Time, expiry time.Duration) (*ReapResult, error) {
+	result := &ReapResult{}
+
+	multipartRoot := filepath.Join(l.rootPath, ".multipart")
+	buckets, err := os.ReadDir(multipartRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	for _, bucketEntry := range buckets {
+		if !bucketEntry.IsDir() {
+			continue
+		}
+		bucket := bucketEntry.Name()
+
+		cfg, err := l.loadBucketConfig(bucket)
+		if err != nil {
+			return result, err
+		}
+
+		uploads, err := os.ReadDir(filepath.Join(multipartRoot, bucket))
+		if err != nil {
+			return result, err
+		}
+
+		for _, uploadEntry := range uploads {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if !uploadEntry.IsDir() {
+				continue
+			}
+
+			multipartDir := filepath.Join(multipartRoot, bucket, uploadEntry.Name())
+			upload, err := readUpload(multipartDir)
+			if err != nil {
+				// Initiation has not written its metadata yet
+				continue
+			}
+
+			initiated := upload.Initiated
+			if initiated.IsZero() {
+				if info, err := uploadEntry.Info(); err == nil {
+					initiated = info.ModTime()
+				}
+			}
+
+			maxAge, hasRule := abortUploadAfter(cfg.Lifecycle, upload.Key)
+			if expiry > 0 && (!hasRule || expiry < maxAge) {
+				maxAge, hasRule = expiry, true
+			}
+			if !hasRule || now.Sub(initiated) < maxAge {
+				continue
+			}
+
+			reaped, size, err := l.reapUpload(bucket, upload.UploadID)
+			if err != nil {
+				return result, err
+			}
+			if reaped {
+				result.Uploads++
+				result.Bytes += size
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// reapUpload removes an expired upload unless it was completed or aborted
+// since it was listed, and returns the bytes it held.
+func (l *LocalStorage) reapUpload(bucket, uploadID string) (bool, int64, error) {
+	unlock := l.uploads.lock(bucket, uploadID)
+	defer unlock()
+
+	multipartDir := l.multipartDir(bucket, uploadID)
+	if _, err := readUpload(multipartDir); err != nil {
+		if os.IsNotExist(err) {
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+
+	size, err := dirSize(multipartDir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, 0, err
+	}
+	if err := os.RemoveAll(multipartDir); err != nil {
+		return false, 0, err
+	}
+	return true, size, nil
+}
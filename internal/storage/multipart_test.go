@@ -2,10 +2,17 @@ package storage
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMultipartUpload(t *testing.T) {
@@ -20,6 +27,9 @@ func TestMultipartUpload(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Allow parts smaller than the S3 minimum to keep test data short
+	storage.minPartSize = 1
+
 	ctx := context.Background()
 	bucket := "test-bucket"
 	key := "test-multipart-object"
@@ -31,7 +41,7 @@ func TestMultipartUpload(t *testing.T) {
 	}
 
 	t.Run("InitiateMultipartUpload", func(t *testing.T) {
-		uploadID, err := storage.InitMultipartUpload(ctx, bucket, key)
+		uploadID, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{ContentType: "text/plain"})
 		if err != nil {
 			t.Errorf("InitMultipartUpload failed: %v", err)
 		}
@@ -73,13 +83,13 @@ func TestMultipartUpload(t *testing.T) {
 			{PartNumber: 2, ETag: etag2},
 		}
 
-		err = storage.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts)
+		_, err = storage.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts)
 		if err != nil {
 			t.Errorf("CompleteMultipartUpload failed: %v", err)
 		}
 
 		// Verify final object
-		reader, info, err := storage.GetObject(ctx, bucket, key, "")
+		reader, info, err := storage.GetObject(ctx, bucket, key, "", "")
 		if err != nil {
 			t.Errorf("GetObject failed after multipart upload: %v", err)
 		}
@@ -96,6 +106,14 @@ func TestMultipartUpload(t *testing.T) {
 			t.Errorf("Expected content '%s', got '%s'", expectedContent, string(content))
 		}
 
+		if !strings.HasSuffix(info.ETag, "-2") {
+			t.Errorf("Expected composite ETag with part count suffix, got '%s'", info.ETag)
+		}
+
+		if info.ContentType != "text/plain" {
+			t.Errorf("Expected Content-Type from initiation 'text/plain', got '%s'", info.ContentType)
+		}
+
 		// Check that multipart directory was cleaned up
 		if _, err := os.Stat(multipartDir); !os.IsNotExist(err) {
 			t.Error("Multipart directory was not cleaned up after completion")
@@ -103,7 +121,7 @@ func TestMultipartUpload(t *testing.T) {
 	})
 
 	t.Run("AbortMultipartUpload", func(t *testing.T) {
-		uploadID, err := storage.InitMultipartUpload(ctx, bucket, "abort-test")
+		uploadID, err := storage.InitMultipartUpload(ctx, bucket, "abort-test", ObjectMetadata{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -129,13 +147,14 @@ func TestMultipartUpload(t *testing.T) {
 
 	t.Run("ListMultipartUploads", func(t *testing.T) {
 		// Create a few multipart uploads
-		uploadID1, _ := storage.InitMultipartUpload(ctx, bucket, "list-test-1")
-		uploadID2, _ := storage.InitMultipartUpload(ctx, bucket, "list-test-2")
+		uploadID1, _ := storage.InitMultipartUpload(ctx, bucket, "list-test-1", ObjectMetadata{})
+		uploadID2, _ := storage.InitMultipartUpload(ctx, bucket, "list-test-2", ObjectMetadata{})
 
-		uploads, err := storage.ListMultipartUploads(ctx, bucket)
+		listing, err := storage.ListMultipartUploads(ctx, bucket, "", "", "", "", 1000)
 		if err != nil {
-			t.Errorf("ListMultipartUploads failed: %v", err)
+			t.Fatalf("ListMultipartUploads failed: %v", err)
 		}
+		uploads := listing.Uploads
 
 		if len(uploads) < 2 {
 			t.Errorf("Expected at least 2 uploads, got %d", len(uploads))
@@ -162,6 +181,387 @@ func TestMultipartUpload(t *testing.T) {
 	})
 }
 
+func TestListMultipartUploadsPaging(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-multipart-list-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"z", "photos/2", "a", "photos/1", "b", "a"}
+	for _, key := range keys {
+		if _, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := storage.ListMultipartUploads(ctx, bucket, "", "", "", "", 1000)
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	var listed []string
+	for _, upload := range all.Uploads {
+		listed = append(listed, upload.Key)
+	}
+	if strings.Join(listed, ",") != "a,a,b,photos/1,photos/2,z" || all.IsTruncated {
+		t.Fatalf("Expected all uploads sorted by key, got %v", listed)
+	}
+
+	t.Run("Paging", func(t *testing.T) {
+		var paged []MultipartUpload
+		keyMarker, uploadIDMarker := "", ""
+		for page := 0; page < len(keys)+1; page++ {
+			result, err := storage.ListMultipartUploads(ctx, bucket, "", "", keyMarker, uploadIDMarker, 1)
+			if err != nil {
+				t.Fatalf("ListMultipartUploads failed: %v", err)
+			}
+			paged = append(paged, result.Uploads...)
+			if !result.IsTruncated {
+				break
+			}
+			keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+		}
+
+		if len(paged) != len(all.Uploads) {
+			t.Fatalf("Expected %d uploads across pages, got %d", len(all.Uploads), len(paged))
+		}
+		for i := range paged {
+			if paged[i].UploadID != all.Uploads[i].UploadID {
+				t.Errorf("Page %d: expected upload %s, got %s", i, all.Uploads[i].UploadID, paged[i].UploadID)
+			}
+		}
+	})
+
+	t.Run("KeyMarkerWithoutUploadIDMarker", func(t *testing.T) {
+		result, err := storage.ListMultipartUploads(ctx, bucket, "", "", "a", "", 1000)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(result.Uploads) != 4 || result.Uploads[0].Key != "b" {
+			t.Errorf("Expected the uploads after key a, got %+v", result.Uploads)
+		}
+	})
+
+	t.Run("PrefixAndDelimiter", func(t *testing.T) {
+		result, err := storage.ListMultipartUploads(ctx, bucket, "", "/", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(result.Uploads) != 4 || len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0] != "photos/" {
+			t.Errorf("Expected 4 uploads and the photos/ prefix, got %+v", result)
+		}
+
+		result, err = storage.ListMultipartUploads(ctx, bucket, "photos/", "/", "", "", 1000)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(result.Uploads) != 2 || len(result.CommonPrefixes) != 0 {
+			t.Errorf("Expected the 2 uploads under photos/, got %+v", result)
+		}
+	})
+
+	t.Run("CommonPrefixCountsTowardsMax", func(t *testing.T) {
+		result, err := storage.ListMultipartUploads(ctx, bucket, "", "/", "b", "", 1)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(result.CommonPrefixes) != 1 || !result.IsTruncated || result.NextKeyMarker != "photos/" {
+			t.Fatalf("Expected a truncated page holding photos/, got %+v", result)
+		}
+
+		result, err = storage.ListMultipartUploads(ctx, bucket, "", "/", result.NextKeyMarker, result.NextUploadIDMarker, 1)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads failed: %v", err)
+		}
+		if len(result.Uploads) != 1 || result.Uploads[0].Key != "z" || result.IsTruncated {
+			t.Errorf("Expected only z on the last page, got %+v", result)
+		}
+	})
+}
+
+func TestListMultipartUploadsMarkerGone(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-multipart-marker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		uploadID, err := storage.InitMultipartUpload(ctx, bucket, "key", ObjectMetadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, uploadID)
+	}
+	// Initiate the upload with the greater ID first, so that ID order and
+	// listing order disagree.
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	initiated := time.Now().UTC().Add(-time.Hour)
+	for i, id := range ids {
+		record := uploadRecord{Bucket: bucket, Key: "key", Initiated: initiated.Add(time.Duration(i) * time.Minute)}
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(storage.multipartDir(bucket, id), "upload.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := storage.ListMultipartUploads(ctx, bucket, "", "", "", "", 1)
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	if len(first.Uploads) != 1 || first.Uploads[0].UploadID != ids[0] || !first.IsTruncated {
+		t.Fatalf("Expected a truncated page holding %s, got %+v", ids[0], first)
+	}
+
+	if err := storage.AbortMultipartUpload(ctx, bucket, "key", ids[0]); err != nil {
+		t.Fatalf("AbortMultipartUpload failed: %v", err)
+	}
+
+	next, err := storage.ListMultipartUploads(ctx, bucket, "", "", first.NextKeyMarker, first.NextUploadIDMarker, 1)
+	if err != nil {
+		t.Fatalf("ListMultipartUploads failed: %v", err)
+	}
+	if len(next.Uploads) != 1 || next.Uploads[0].UploadID != ids[1] || next.IsTruncated {
+		t.Errorf("Expected only %s on the last page, got %+v", ids[1], next)
+	}
+}
+
+func TestMultipartUploadBinding(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-multipart-binding-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, bucket := range []string{"bucket-a", "bucket-b"} {
+		if err := storage.CreateBucket(ctx, bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("UniqueIDs", func(t *testing.T) {
+		const n = 50
+		ids := make(chan string, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				uploadID, err := storage.InitMultipartUpload(ctx, "bucket-a", "same-key", ObjectMetadata{})
+				if err != nil {
+					t.Errorf("InitMultipartUpload failed: %v", err)
+					return
+				}
+				ids <- uploadID
+			}()
+		}
+		wg.Wait()
+		close(ids)
+
+		seen := make(map[string]bool)
+		for id := range ids {
+			if seen[id] {
+				t.Errorf("Duplicate upload ID %q", id)
+			}
+			if len(id) < 32 {
+				t.Errorf("Upload ID %q is too short to be unguessable", id)
+			}
+			seen[id] = true
+		}
+		if len(seen) != n {
+			t.Errorf("Expected %d upload IDs, got %d", n, len(seen))
+		}
+	})
+
+	uploadID, err := storage.InitMultipartUpload(ctx, "bucket-a", "object", ObjectMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another bucket with an upload directory of the same name must not be
+	// able to address the upload either
+	if err := os.MkdirAll(storage.multipartDir("bucket-b", uploadID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	forged := `{"bucket": "bucket-a", "key": "object", "initiated": "` + time.Now().Format(time.RFC3339) + `"}`
+	if err := os.WriteFile(filepath.Join(storage.multipartDir("bucket-b", uploadID), "upload.json"), []byte(forged), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches := []struct {
+		name   string
+		bucket string
+		key    string
+	}{
+		{"OtherKey", "bucket-a", "other"},
+		{"OtherBucket", "bucket-b", "object"},
+	}
+	for _, tt := range mismatches {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := storage.UploadPart(ctx, tt.bucket, tt.key, uploadID, 1, strings.NewReader("data"), 4); err != ErrNoSuchUpload {
+				t.Errorf("UploadPart: expected ErrNoSuchUpload, got %v", err)
+			}
+			if _, err := storage.ListParts(ctx, tt.bucket, tt.key, uploadID, 0, 1000); err != ErrNoSuchUpload {
+				t.Errorf("ListParts: expected ErrNoSuchUpload, got %v", err)
+			}
+			if _, err := storage.CompleteMultipartUpload(ctx, tt.bucket, tt.key, uploadID, []Part{{PartNumber: 1, ETag: "x"}}); err != ErrNoSuchUpload {
+				t.Errorf("CompleteMultipartUpload: expected ErrNoSuchUpload, got %v", err)
+			}
+			if err := storage.AbortMultipartUpload(ctx, tt.bucket, tt.key, uploadID); err != ErrNoSuchUpload {
+				t.Errorf("AbortMultipartUpload: expected ErrNoSuchUpload, got %v", err)
+			}
+		})
+	}
+
+	t.Run("KeyWithNewlines", func(t *testing.T) {
+		key := "line\nbucket=bucket-b\nkey=other"
+		uploadID, err := storage.InitMultipartUpload(ctx, "bucket-a", key, ObjectMetadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.UploadPart(ctx, "bucket-b", "other", uploadID, 1, strings.NewReader("data"), 4); err != ErrNoSuchUpload {
+			t.Errorf("Expected the key not to rebind the upload, got %v", err)
+		}
+		if _, err := storage.UploadPart(ctx, "bucket-a", key, uploadID, 1, strings.NewReader("data"), 4); err != nil {
+			t.Errorf("UploadPart failed: %v", err)
+		}
+		result, err := storage.ListMultipartUploads(ctx, "bucket-a", "line", "", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Uploads) != 1 || result.Uploads[0].Key != key {
+			t.Errorf("Expected the upload with its full key, got %+v", result.Uploads)
+		}
+		storage.AbortMultipartUpload(ctx, "bucket-a", key, uploadID)
+	})
+
+	// The upload is untouched by the rejected calls
+	if _, err := storage.UploadPart(ctx, "bucket-a", "object", uploadID, 1, strings.NewReader("data"), 4); err != nil {
+		t.Errorf("UploadPart failed: %v", err)
+	}
+	if err := storage.AbortMultipartUpload(ctx, "bucket-a", "object", uploadID); err != nil {
+		t.Errorf("AbortMultipartUpload failed: %v", err)
+	}
+}
+
+func TestCompleteMultipartValidation(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-multipart-validation-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.minPartSize = 4
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	key := "validated-object"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadID, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partData := []string{"aaaa", "bb", "cccc"}
+	etags := make([]string, len(partData))
+	for i, data := range partData {
+		etags[i], err = storage.UploadPart(ctx, bucket, key, uploadID, i+1, strings.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("InvalidPartNumber", func(t *testing.T) {
+		for _, partNumber := range []int{0, 10001} {
+			if _, err := storage.UploadPart(ctx, bucket, key, uploadID, partNumber, strings.NewReader("x"), 1); err != ErrInvalidPartNumber {
+				t.Errorf("Part %d: expected ErrInvalidPartNumber, got %v", partNumber, err)
+			}
+		}
+	})
+
+	tests := []struct {
+		name    string
+		parts   []Part
+		wantErr error
+	}{
+		{"NoParts", nil, ErrInvalidPart},
+		{"ETagMismatch", []Part{{1, etags[1]}}, ErrInvalidPart},
+		{"MissingPart", []Part{{1, etags[0]}, {4, etags[0]}}, ErrInvalidPart},
+		{"OutOfOrder", []Part{{3, etags[2]}, {1, etags[0]}}, ErrInvalidPartOrder},
+		{"Duplicate", []Part{{1, etags[0]}, {1, etags[0]}}, ErrInvalidPartOrder},
+		{"SmallMiddlePart", []Part{{1, etags[0]}, {2, etags[1]}, {3, etags[2]}}, ErrEntityTooSmall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := storage.CompleteMultipartUpload(ctx, bucket, key, uploadID, tt.parts); err != tt.wantErr {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("SmallLastPart", func(t *testing.T) {
+		// Quoted ETags are accepted and the last part may be below the minimum
+		parts := []Part{{1, "\"" + etags[0] + "\""}, {2, etags[1]}}
+
+		info, err := storage.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts)
+		if err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
+
+		// md5(md5("aaaa") || md5("bb"))-2
+		first, _ := hex.DecodeString(etags[0])
+		second, _ := hex.DecodeString(etags[1])
+		composite := md5.Sum(append(first, second...))
+		expected := hex.EncodeToString(composite[:]) + "-2"
+		if info.ETag != expected {
+			t.Errorf("Expected ETag %s, got %s", expected, info.ETag)
+		}
+		if info.Size != 6 {
+			t.Errorf("Expected size 6, got %d", info.Size)
+		}
+	})
+}
+
 func TestRangeRequests(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "porter-range-test")
 	if err != nil {
@@ -185,14 +585,14 @@ func TestRangeRequests(t *testing.T) {
 	}
 
 	testContent := "0123456789abcdefghijklmnopqrstuvwxyz"
-	err = storage.PutObject(ctx, bucket, key, strings.NewReader(testContent), int64(len(testContent)), "text/plain")
+	_, err = storage.PutObject(ctx, bucket, key, strings.NewReader(testContent), int64(len(testContent)), ObjectMetadata{ContentType: "text/plain"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Run("ValidRangeRequest", func(t *testing.T) {
 		// Request bytes 5-9 (should be "56789")
-		reader, info, err := storage.GetObject(ctx, bucket, key, "bytes=5-9")
+		reader, info, err := storage.GetObject(ctx, bucket, key, "", "bytes=5-9")
 		if err != nil {
 			t.Errorf("Range request failed: %v", err)
 		}
@@ -216,7 +616,7 @@ func TestRangeRequests(t *testing.T) {
 
 	t.Run("RangeFromStart", func(t *testing.T) {
 		// Request bytes 0-4 (should be "01234")
-		reader, info, err := storage.GetObject(ctx, bucket, key, "bytes=0-4")
+		reader, info, err := storage.GetObject(ctx, bucket, key, "", "bytes=0-4")
 		if err != nil {
 			t.Errorf("Range request failed: %v", err)
 		}
@@ -233,7 +633,7 @@ func TestRangeRequests(t *testing.T) {
 
 	t.Run("RangeToEnd", func(t *testing.T) {
 		// Request bytes 30- (should be "uvwxyz")
-		reader, info, err := storage.GetObject(ctx, bucket, key, "bytes=30-")
+		reader, info, err := storage.GetObject(ctx, bucket, key, "", "bytes=30-")
 		if err != nil {
 			t.Errorf("Range request failed: %v", err)
 		}
@@ -249,7 +649,7 @@ func TestRangeRequests(t *testing.T) {
 	})
 
 	t.Run("InvalidRangeFormat", func(t *testing.T) {
-		_, _, err := storage.GetObject(ctx, bucket, key, "invalid-range")
+		_, _, err := storage.GetObject(ctx, bucket, key, "", "invalid-range")
 		if err == nil {
 			t.Error("Expected error for invalid range format")
 		}
@@ -257,9 +657,348 @@ func TestRangeRequests(t *testing.T) {
 
 	t.Run("InvalidRangeValues", func(t *testing.T) {
 		// Request bytes beyond file size
-		_, _, err := storage.GetObject(ctx, bucket, key, "bytes=100-200")
+		_, _, err := storage.GetObject(ctx, bucket, key, "", "bytes=100-200")
 		if err == nil {
 			t.Error("Expected error for range beyond file size")
 		}
 	})
 }
+
+func TestConcurrentPartUploads(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-concurrent-parts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	key := "retried.bin"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	uploadID, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries of the same part race each other with different data of the
+	// same size
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := strings.Repeat(string(rune('a'+i)), 64)
+			if _, err := storage.UploadPart(ctx, bucket, key, uploadID, 1, strings.NewReader(data), int64(len(data))); err != nil {
+				t.Errorf("UploadPart failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	meta, err := readPartMeta(storage.multipartDir(bucket, uploadID), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(partPath(storage.multipartDir(bucket, uploadID), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data)
+	if meta.ETag != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Part metadata ETag %s does not match its data", meta.ETag)
+	}
+
+	info, err := storage.CompleteMultipartUpload(ctx, bucket, key, uploadID, []Part{{PartNumber: 1, ETag: meta.ETag}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readObjectVersion(t, storage, bucket, key, ""); got != string(data) || info.Size != 64 {
+		t.Errorf("Expected the object to hold the committed part, got %q", got)
+	}
+}
+
+func TestListParts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-list-parts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	key := "resumable.bin"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadID, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload out of order with a gap, as an interrupted uploader would
+	etags := make(map[int]string)
+	for _, n := range []int{3, 1, 12} {
+		data := strings.Repeat("x", n)
+		etag, err := storage.UploadPart(ctx, bucket, key, uploadID, n, strings.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags[n] = etag
+	}
+
+	t.Run("AllParts", func(t *testing.T) {
+		result, err := storage.ListParts(ctx, bucket, key, uploadID, 0, 1000)
+		if err != nil {
+			t.Fatalf("ListParts failed: %v", err)
+		}
+		if result.IsTruncated {
+			t.Error("Expected an untruncated listing")
+		}
+
+		want := []int{1, 3, 12}
+		if len(result.Parts) != len(want) {
+			t.Fatalf("Expected %d parts, got %d", len(want), len(result.Parts))
+		}
+		for i, part := range result.Parts {
+			if part.PartNumber != want[i] {
+				t.Errorf("Expected part %d, got %d", want[i], part.PartNumber)
+			}
+			if part.Size != int64(part.PartNumber) || part.ETag != etags[part.PartNumber] {
+				t.Errorf("Unexpected size or ETag for part %d: %+v", part.PartNumber, part)
+			}
+			if part.LastModified.IsZero() {
+				t.Errorf("Expected LastModified for part %d", part.PartNumber)
+			}
+		}
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		first, err := storage.ListParts(ctx, bucket, key, uploadID, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first.Parts) != 2 || !first.IsTruncated || first.NextPartNumberMarker != 3 {
+			t.Fatalf("Expected parts 1 and 3 with marker 3, got %+v", first)
+		}
+
+		second, err := storage.ListParts(ctx, bucket, key, uploadID, first.NextPartNumberMarker, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(second.Parts) != 1 || second.Parts[0].PartNumber != 12 || second.IsTruncated {
+			t.Errorf("Expected only part 12, got %+v", second)
+		}
+	})
+
+	t.Run("EmptyPage", func(t *testing.T) {
+		for _, maxParts := range []int{0, -1} {
+			result, err := storage.ListParts(ctx, bucket, key, uploadID, 0, maxParts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Parts) != 0 || result.IsTruncated {
+				t.Errorf("Expected an empty page for max parts %d, got %+v", maxParts, result)
+			}
+		}
+	})
+
+	t.Run("NoSuchUpload", func(t *testing.T) {
+		if _, err := storage.ListParts(ctx, bucket, key, "999", 0, 1000); err != ErrNoSuchUpload {
+			t.Errorf("Expected ErrNoSuchUpload, got %v", err)
+		}
+	})
+}
+
+func TestReapMultipartUploads(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-reap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	startUpload := func(key, data string) string {
+		uploadID, err := storage.InitMultipartUpload(ctx, bucket, key, ObjectMetadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.UploadPart(ctx, bucket, key, uploadID, 1, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+		return uploadID
+	}
+	uploadExists := func(uploadID string) bool {
+		_, err := os.Stat(storage.multipartDir(bucket, uploadID))
+		return err == nil
+	}
+
+	t.Run("NothingExpired", func(t *testing.T) {
+		uploadID := startUpload("fresh.bin", "fresh")
+		defer storage.AbortMultipartUpload(ctx, bucket, "fresh.bin", uploadID)
+
+		result, err := storage.ReapMultipartUploads(ctx, time.Now(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Uploads != 0 || !uploadExists(uploadID) {
+			t.Errorf("Expected the fresh upload to be kept, got %+v", result)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		uploadID := startUpload("stale.bin", "stale data")
+
+		result, err := storage.ReapMultipartUploads(ctx, time.Now().Add(2*time.Hour), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Uploads != 1 || uploadExists(uploadID) {
+			t.Errorf("Expected the stale upload to be aborted, got %+v", result)
+		}
+		// The part itself is 10 bytes; metadata files add to it
+		if result.Bytes < 10 {
+			t.Errorf("Expected at least 10 reclaimed bytes, got %d", result.Bytes)
+		}
+	})
+
+	t.Run("PartDuringReap", func(t *testing.T) {
+		uploadID := startUpload("reaped.bin", "reaped")
+
+		// The part is still streaming when the upload is reaped
+		body, writer := io.Pipe()
+		done := make(chan error)
+		go func() {
+			_, err := storage.UploadPart(ctx, bucket, "reaped.bin", uploadID, 2, body, 4)
+			done <- err
+		}()
+		writer.Write([]byte("la"))
+		if _, err := storage.ReapMultipartUploads(ctx, time.Now().Add(2*time.Hour), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte("te"))
+		writer.Close()
+
+		if err := <-done; err != ErrNoSuchUpload {
+			t.Errorf("Expected ErrNoSuchUpload, got %v", err)
+		}
+		if uploadExists(uploadID) {
+			t.Error("Expected the late part not to recreate the reaped upload")
+		}
+	})
+
+	t.Run("ZeroExpiryKeepsUploads", func(t *testing.T) {
+		uploadID := startUpload("kept.bin", "kept")
+		defer storage.AbortMultipartUpload(ctx, bucket, "kept.bin", uploadID)
+
+		result, err := storage.ReapMultipartUploads(ctx, time.Now().Add(365*24*time.Hour), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Uploads != 0 || !uploadExists(uploadID) {
+			t.Errorf("Expected no uploads to be aborted without expiry or rules, got %+v", result)
+		}
+	})
+
+	t.Run("LifecycleRule", func(t *testing.T) {
+		err := storage.PutBucketLifecycle(ctx, bucket, []LifecycleRule{
+			{ID: "tmp", Prefix: "tmp/", Enabled: true, AbortIncompleteUploadDays: 1},
+			{ID: "disabled", Prefix: "", Enabled: false, AbortIncompleteUploadDays: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.DeleteBucketLifecycle(ctx, bucket)
+
+		matching := startUpload("tmp/scratch.bin", "scratch")
+		other := startUpload("keep/data.bin", "data")
+		defer storage.AbortMultipartUpload(ctx, bucket, "keep/data.bin", other)
+
+		// The rule is shorter than the global expiry, so it takes effect first
+		result, err := storage.ReapMultipartUploads(ctx, time.Now().Add(25*time.Hour), 7*24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Uploads != 1 || uploadExists(matching) || !uploadExists(other) {
+			t.Errorf("Expected only the upload under tmp/ to be aborted, got %+v", result)
+		}
+	})
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-lifecycle-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "test-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.GetBucketLifecycle(ctx, bucket); err != ErrNoSuchLifecycleConfiguration {
+		t.Errorf("Expected ErrNoSuchLifecycleConfiguration, got %v", err)
+	}
+
+	invalid := []LifecycleRule{{Enabled: true, AbortIncompleteUploadDays: 0}}
+	if err := storage.PutBucketLifecycle(ctx, bucket, invalid); err != ErrInvalidLifecycleRule {
+		t.Errorf("Expected ErrInvalidLifecycleRule, got %v", err)
+	}
+
+	rules := []LifecycleRule{{ID: "abort", Prefix: "logs/", Enabled: true, AbortIncompleteUploadDays: 2}}
+	if err := storage.PutBucketLifecycle(ctx, bucket, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lifecycle and versioning share the bucket config and must not clobber each other
+	if err := storage.PutBucketVersioning(ctx, bucket, VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.GetBucketLifecycle(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != rules[0] {
+		t.Errorf("Expected %+v, got %+v", rules, got)
+	}
+
+	if err := storage.DeleteBucketLifecycle(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.GetBucketLifecycle(ctx, bucket); err != ErrNoSuchLifecycleConfiguration {
+		t.Errorf("Expected ErrNoSuchLifecycleConfiguration after delete, got %v", err)
+	}
+	if status, _ := storage.GetBucketVersioning(ctx, bucket); status != VersioningEnabled {
+		t.Errorf("Expected versioning to stay enabled, got '%s'", status)
+	}
+}
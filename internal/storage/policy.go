@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+)
+
+func (l *LocalStorage) PutBucketPolicy(ctx context.Context, bucket string, policy []byte) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+	if !json.Valid(policy) {
+		return ErrMalformedPolicy
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Policy = json.RawMessage(policy)
+
+	return l.saveBucketConfig(bucket, cfg)
+}
+
+func (l *LocalStorage) GetBucketPolicy(ctx context.Context, bucket string) ([]byte, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Policy) == 0 {
+		return nil, ErrNoSuchBucketPolicy
+	}
+
+	return cfg.Policy, nil
+}
+
+func (l *LocalStorage) DeleteBucketPolicy(ctx context.Context, bucket string) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := l.loadBucketConfig(bucket)
+	if err != nil {
+		return err
+	}
+	cfg.Policy = nil
+
+	return l.saveBucketConfig(bucket, cfg)
+}
@@ -7,14 +7,91 @@ import (
 	"time"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	ErrInvalidRange   = errors.New("invalid range")
+	ErrIncompleteBody = errors.New("request body shorter than declared size")
+	ErrInvalidDigest  = errors.New("invalid Content-MD5")
+	ErrBadDigest      = errors.New("Content-MD5 does not match the received data")
+
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	ErrInvalidObjectName = errors.New("invalid object key")
+	ErrKeyTooLong        = errors.New("object key is too long")
+	ErrNoSuchUpload      = errors.New("multipart upload not found")
+
+	ErrNoSuchVersion           = errors.New("object version not found")
+	ErrInvalidVersionID        = errors.New("invalid version ID")
+	ErrDeleteMarker            = errors.New("object version is a delete marker")
+	ErrInvalidVersioningStatus = errors.New("invalid versioning status")
+
+	ErrPreconditionFailed = errors.New("copy source precondition failed")
+
+	ErrNoSuchLifecycleConfiguration = errors.New("bucket has no lifecycle configuration")
+	ErrInvalidLifecycleRule         = errors.New("invalid lifecycle rule")
+
+	ErrNoSuchBucketPolicy = errors.New("bucket has no policy")
+	ErrMalformedPolicy    = errors.New("bucket policy is not valid JSON")
+
+	ErrInvalidPartNumber = errors.New("part number must be between 1 and 10000")
+	ErrInvalidPart       = errors.New("part not found or ETag mismatch")
+	ErrInvalidPartOrder  = errors.New("parts are not in ascending order")
+	ErrEntityTooSmall    = errors.New("part is smaller than the minimum part size")
+)
 
 type ObjectInfo struct {
-	Key          string
-	Size         int64
-	LastModified time.Time
-	ETag         string
-	ContentType  string
+	Key                string
+	Size               int64
+	LastModified       time.Time
+	ETag               string
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	CacheControl       string
+	UserMetadata       map[string]string
+	Checksums          map[string]string
+
+	// VersionID is empty for the null version. DeleteMarker is set when the
+	// version describes a delete marker rather than object data.
+	VersionID    string
+	DeleteMarker bool
+}
+
+// ObjectMetadata is the client-supplied metadata stored with an object.
+// UserMetadata keys are lower-case and exclude the x-amz-meta- prefix;
+// Checksums are keyed by algorithm (MD5, CRC32, CRC32C, SHA1, SHA256) and
+// hold the base64 digests as sent by the client.
+type ObjectMetadata struct {
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	CacheControl       string
+	UserMetadata       map[string]string
+	Checksums          map[string]string
+}
+
+// CopySource identifies the object a copy reads from. Range, in the
+// "bytes=start-end" form, is only used when copying into a part.
+type CopySource struct {
+	Bucket     string
+	Key        string
+	VersionID  string
+	Range      string
+	Conditions CopyConditions
+}
+
+// ObjectIdentifier names an object, or one version of it, in a batch delete.
+type ObjectIdentifier struct {
+	Key       string
+	VersionID string
+}
+
+// DeleteResult is the outcome of deleting one ObjectIdentifier. Info is
+// what DeleteObject would have returned and is nil when Err is set.
+type DeleteResult struct {
+	Info *ObjectInfo
+	Err  error
 }
 
 type Storage interface {
@@ -22,17 +99,72 @@ type Storage interface {
 	DeleteBucket(ctx context.Context, bucket string) error
 	ListBuckets(ctx context.Context) ([]string, error)
 
-	PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, contentType string) error
-	GetObject(ctx context.Context, bucket, key string, rangeHeader string) (io.ReadCloser, *ObjectInfo, error)
-	DeleteObject(ctx context.Context, bucket, key string) error
-	HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error)
-	ListObjects(ctx context.Context, bucket, prefix, delimiter string, maxKeys int) ([]ObjectInfo, bool, error)
+	PutBucketVersioning(ctx context.Context, bucket, status string) error
+	GetBucketVersioning(ctx context.Context, bucket string) (string, error)
+	PutBucketLifecycle(ctx context.Context, bucket string, rules []LifecycleRule) error
+	GetBucketLifecycle(ctx context.Context, bucket string) ([]LifecycleRule, error)
+	DeleteBucketLifecycle(ctx context.Context, bucket string) error
+	// Policies are stored as the JSON document the client sent; callers
+	// validate them before PutBucketPolicy.
+	PutBucketPolicy(ctx context.Context, bucket string, policy []byte) error
+	GetBucketPolicy(ctx context.Context, bucket string) ([]byte, error)
+	DeleteBucketPolicy(ctx context.Context, bucket string) error
 
-	InitMultipartUpload(ctx context.Context, bucket, key string) (string, error)
+	// An empty versionID addresses the current version. DeleteObject
+	// returns the version it removed or the delete marker it created.
+	// Reading a delete marker fails with ErrNotFound, or ErrDeleteMarker
+	// when it is addressed by version ID, and returns the marker's info
+	// alongside the error.
+	PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, meta ObjectMetadata) (*ObjectInfo, error)
+	GetObject(ctx context.Context, bucket, key, versionID, rangeHeader string) (io.ReadCloser, *ObjectInfo, error)
+	DeleteObject(ctx context.Context, bucket, key, versionID string) (*ObjectInfo, error)
+	DeleteObjects(ctx context.Context, bucket string, objects []ObjectIdentifier) ([]DeleteResult, error)
+	HeadObject(ctx context.Context, bucket, key, versionID string) (*ObjectInfo, error)
+	ListObjects(ctx context.Context, bucket, prefix, delimiter, startAfter string, maxKeys int) (*ListObjectsResult, error)
+	ListObjectVersions(ctx context.Context, bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*ListObjectVersionsResult, error)
+	CopyObject(ctx context.Context, src CopySource, bucket, key string, meta *ObjectMetadata) (*ObjectInfo, error)
+
+	InitMultipartUpload(ctx context.Context, bucket, key string, meta ObjectMetadata) (string, error)
 	UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
-	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error
+	UploadPartCopy(ctx context.Context, src CopySource, bucket, key, uploadID string, partNumber int) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) (*ObjectInfo, error)
 	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
-	ListMultipartUploads(ctx context.Context, bucket string) ([]MultipartUpload, error)
+	ListParts(ctx context.Context, bucket, key, uploadID string, partNumberMarker, maxParts int) (*ListPartsResult, error)
+	ListMultipartUploads(ctx context.Context, bucket, prefix, delimiter, keyMarker, uploadIDMarker string, maxUploads int) (*ListMultipartUploadsResult, error)
+
+	// ReapMultipartUploads aborts the uploads initiated more than expiry
+	// before now, or earlier than an AbortIncompleteMultipartUpload
+	// lifecycle rule of their bucket allows. A zero expiry leaves only the
+	// lifecycle rules in effect.
+	ReapMultipartUploads(ctx context.Context, now time.Time, expiry time.Duration) (*ReapResult, error)
+}
+
+// ListObjectsResult is one page of keys in lexicographic order after the
+// startAfter cursor. Keys that contain the delimiter after the prefix are
+// rolled up into CommonPrefixes; both objects and prefixes count towards
+// maxKeys. NextMarker is the last key or prefix returned when the page is
+// truncated and is the cursor for the following page.
+type ListObjectsResult struct {
+	Objects        []ObjectInfo
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextMarker     string
+}
+
+// ListObjectVersionsResult is one page of object versions ordered by key
+// and then newest first. The markers use the external version ID form, so
+// the null version is "null".
+type ListObjectVersionsResult struct {
+	Versions            []ObjectVersion
+	CommonPrefixes      []string
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
+}
+
+type ObjectVersion struct {
+	ObjectInfo
+	IsLatest bool
 }
 
 type Part struct {
@@ -40,8 +172,45 @@ type Part struct {
 	ETag       string
 }
 
+// PartInfo describes an uploaded part of a multipart upload.
+type PartInfo struct {
+	PartNumber   int
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ListPartsResult is one page of the parts of a multipart upload in part
+// number order after the partNumberMarker cursor. NextPartNumberMarker is
+// the last part returned and is the cursor for the following page.
+type ListPartsResult struct {
+	Parts                []PartInfo
+	IsTruncated          bool
+	NextPartNumberMarker int
+}
+
+// ListMultipartUploadsResult is one page of in-progress uploads ordered by
+// key and then initiation time. Keys that contain the delimiter after the
+// prefix are rolled up into CommonPrefixes; both uploads and prefixes count
+// towards maxUploads.
+type ListMultipartUploadsResult struct {
+	Uploads            []MultipartUpload
+	CommonPrefixes     []string
+	IsTruncated        bool
+	NextKeyMarker      string
+	NextUploadIDMarker string
+}
+
+// ReapResult counts the uploads a ReapMultipartUploads pass aborted and
+// the bytes of parts it removed with them.
+type ReapResult struct {
+	Uploads int
+	Bytes   int64
+}
+
 type MultipartUpload struct {
 	UploadID  string
+	Bucket    string
 	Key       string
 	Initiated time.Time
 }
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Writes are staged in .tmp under the root path so they live on the same
+// filesystem as their destination and can be renamed into place atomically.
+func (l *LocalStorage) tempDir() string {
+	return filepath.Join(l.rootPath, ".tmp")
+}
+
+// stagedFile is a temp file that becomes visible at its destination only
+// once Commit succeeds. Discard removes it and is safe to call after Commit.
+type stagedFile struct {
+	file      *os.File
+	hasher    hash.Hash
+	written   int64
+	committed bool
+}
+
+func (l *LocalStorage) createStagedFile() (*stagedFile, error) {
+	if err := os.MkdirAll(l.tempDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(l.tempDir(), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &stagedFile{file: file, hasher: md5.New()}, nil
+}
+
+// ReadFrom streams reader into the staged file while hashing it.
+func (s *stagedFile) ReadFrom(reader io.Reader) (int64, error) {
+	n, err := io.Copy(io.MultiWriter(s.file, s.hasher), reader)
+	s.written += n
+	return n, err
+}
+
+func (s *stagedFile) Write(p []byte) (int, error) {
+	n, err := s.file.Write(p)
+	s.hasher.Write(p[:n])
+	s.written += int64(n)
+	return n, err
+}
+
+// Stat returns the staged file's info, whose modification time Commit
+// carries over to the destination.
+func (s *stagedFile) Stat() (os.FileInfo, error) {
+	return s.file.Stat()
+}
+
+// MD5 returns the raw MD5 digest of everything written so far.
+func (s *stagedFile) MD5() []byte {
+	return s.hasher.Sum(nil)
+}
+
+// Verify checks the staged content against the declared size (ignored when
+// negative) and base64 Content-MD5 (ignored when empty).
+func (s *stagedFile) Verify(size int64, contentMD5 string) error {
+	if size >= 0 && s.written != size {
+		return ErrIncompleteBody
+	}
+
+	if contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return ErrInvalidDigest
+		}
+		if string(expected) != string(s.MD5()) {
+			return ErrBadDigest
+		}
+	}
+
+	return nil
+}
+
+// Commit fsyncs the staged data and renames it to path.
+func (s *stagedFile) Commit(path string) error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(s.file.Name(), path); err != nil {
+		return err
+	}
+	s.committed = true
+
+	syncDir(dir)
+	return nil
+}
+
+func (s *stagedFile) Discard() {
+	if s.committed {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// syncDir persists a rename on filesystems that need the parent directory
+// flushed. Failures are ignored since not every platform supports it.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// writeFileAtomic replaces path with data via a staged temp file.
+func (l *LocalStorage) writeFileAtomic(path string, data []byte) error {
+	staged, err := l.createStagedFile()
+	if err != nil {
+		return err
+	}
+	defer staged.Discard()
+
+	if _, err := staged.Write(data); err != nil {
+		return err
+	}
+
+	return staged.Commit(path)
+}
+
+// cleanupTempFiles removes writes orphaned by a crash or restart.
+func (l *LocalStorage) cleanupTempFiles() error {
+	entries, err := os.ReadDir(l.tempDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(l.tempDir(), entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// MaxKeyLength is the S3 limit on object key length in bytes.
+const MaxKeyLength = 1024
+
+// ValidateBucketName applies the S3 bucket naming rules. Because names may
+// only contain lower-case letters, digits, dots and hyphens and must start
+// with a letter or digit, internal directories such as .multipart and path
+// segments such as .. can never be addressed as buckets.
+func ValidateBucketName(bucket string) error {
+	if len(bucket) < 3 || len(bucket) > 63 {
+		return ErrInvalidBucketName
+	}
+
+	for i := 0; i < len(bucket); i++ {
+		c := bucket[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '.' || c == '-':
+			if i == 0 || i == len(bucket)-1 {
+				return ErrInvalidBucketName
+			}
+		default:
+			return ErrInvalidBucketName
+		}
+	}
+
+	if strings.Contains(bucket, "..") || strings.Contains(bucket, ".-") || strings.Contains(bucket, "-.") {
+		return ErrInvalidBucketName
+	}
+	if strings.HasPrefix(bucket, "xn--") || strings.HasSuffix(bucket, "-s3alias") {
+		return ErrInvalidBucketName
+	}
+	if net.ParseIP(bucket) != nil {
+		return ErrInvalidBucketName
+	}
+
+	return nil
+}
+
+// ValidateObjectKey rejects keys that cannot be mapped safely onto the
+// bucket directory: over-long keys, NUL bytes, absolute paths, "." or ".."
+// segments that would resolve outside of, or alias, another key, and empty
+// segments, including the one after a trailing slash, which the filesystem
+// would drop so that "folder/" aliased "folder".
+func ValidateObjectKey(key string) error {
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLong
+	}
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.ContainsRune(key, 0) {
+		return ErrInvalidObjectName
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidObjectName
+		}
+	}
+
+	return nil
+}
+
+// checkBucket validates the bucket name and that the bucket exists.
+func (l *LocalStorage) checkBucket(bucket string) error {
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(l.bucketPath(bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBucketNotFound
+		}
+		return err
+	}
+	if !stat.IsDir() {
+		return ErrBucketNotFound
+	}
+
+	return nil
+}
+
+// checkObject validates both halves of an object address and that the
+// bucket exists.
+func (l *LocalStorage) checkObject(bucket, key string) error {
+	if err := l.checkBucket(bucket); err != nil {
+		return err
+	}
+	return ValidateObjectKey(key)
+}
+
+// validateUploadID ensures an upload ID names a single directory entry
+// beneath .multipart/<bucket>.
+func validateUploadID(uploadID string) error {
+	if uploadID == "" || uploadID == "." || uploadID == ".." ||
+		strings.ContainsAny(uploadID, "/\\") || strings.ContainsRune(uploadID, 0) {
+		return ErrNoSuchUpload
+	}
+	return nil
+}
+
+// validateVersionID ensures a version ID names a single entry in the
+// version store.
+func validateVersionID(versionID string) error {
+	if versionID == "" || versionID == "." || versionID == ".." ||
+		strings.ContainsAny(versionID, "/\\") || strings.ContainsRune(versionID, 0) ||
+		strings.HasSuffix(versionID, ".json") {
+		return ErrInvalidVersionID
+	}
+	return nil
+}
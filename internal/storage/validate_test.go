@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	valid := []string{"test-bucket", "abc", "my.bucket.name", "bucket-123"}
+	for _, name := range valid {
+		if err := ValidateBucketName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", name, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"ab",
+		strings.Repeat("a", 64),
+		"..",
+		".multipart",
+		".meta",
+		"Upper",
+		"under_score",
+		"-leading",
+		"trailing-",
+		"double..dot",
+		"dot.-hyphen",
+		"192.168.1.1",
+		"xn--bucket",
+		"a/b",
+	}
+	for _, name := range invalid {
+		if err := ValidateBucketName(name); err != ErrInvalidBucketName {
+			t.Errorf("Expected %q to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateObjectKey(t *testing.T) {
+	valid := []string{"file.txt", "a/b/c.txt", "dots..in..name", ".hidden"}
+	for _, key := range valid {
+		if err := ValidateObjectKey(key); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", key, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"../../etc/passwd",
+		"a/../../b",
+		"a/./b",
+		"..",
+		"/absolute",
+		"a//b",
+		"folder/",
+		"nul\x00byte",
+	}
+	for _, key := range invalid {
+		if err := ValidateObjectKey(key); err != ErrInvalidObjectName {
+			t.Errorf("Expected %q to be rejected, got %v", key, err)
+		}
+	}
+
+	if err := ValidateObjectKey(strings.Repeat("a", MaxKeyLength+1)); err != ErrKeyTooLong {
+		t.Errorf("Expected ErrKeyTooLong, got %v", err)
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-traversal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	root := filepath.Join(tmpDir, "root")
+	storage, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := storage.CreateBucket(ctx, "test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.PutObject(ctx, "test-bucket", "../../escaped.txt", strings.NewReader("x"), 1, ObjectMetadata{})
+	if err != ErrInvalidObjectName {
+		t.Errorf("Expected ErrInvalidObjectName, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("Object was written outside the root path")
+	}
+
+	if err := storage.CreateBucket(ctx, ".."); err != ErrInvalidBucketName {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+
+	if _, err := storage.ListObjects(ctx, ".multipart", "", "", "", 1000); err != ErrInvalidBucketName {
+		t.Errorf("Expected internal directory to be rejected as a bucket, got %v", err)
+	}
+
+	if _, err := storage.UploadPart(ctx, "test-bucket", "key", "../../test-bucket", 1, strings.NewReader("x"), 1); err != ErrNoSuchUpload {
+		t.Errorf("Expected ErrNoSuchUpload for traversing upload ID, got %v", err)
+	}
+}
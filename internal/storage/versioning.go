@@ -0,0 +1,693 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NullVersionID is the version ID S3 reports for objects written while
+// versioning was never enabled or was suspended. Such versions are stored
+// with an empty VersionID.
+const NullVersionID = "null"
+
+// Noncurrent versions live under .versions/<bucket>/ in a tree mirroring
+// the keys, so they can be listed in key order like the objects themselves:
+// the versions of a/b/c are in ka/kb/vc/ as <versionID> (data) and
+// <versionID>.json (versionMeta). The prefixes keep the directory of a key's
+// versions apart from the directory of the keys nested below it. Delete
+// markers have no data file. The current version stays at the object path
+// so reads and listings of a versioned bucket cost the same as an
+// unversioned one.
+type versionMeta struct {
+	objectMeta
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+}
+
+func (v *versionMeta) info() *ObjectInfo {
+	info := &ObjectInfo{
+		Key:          v.Key,
+		Size:         v.Size,
+		LastModified: v.LastModified,
+		DeleteMarker: v.DeleteMarker,
+	}
+	v.applyTo(info)
+	return info
+}
+
+func newVersionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// versionFileID maps the empty null version ID to its external form.
+func versionFileID(versionID string) string {
+	if versionID == "" {
+		return NullVersionID
+	}
+	return versionID
+}
+
+// normalizeVersionID maps a client-supplied version ID to its stored form.
+func normalizeVersionID(versionID string) string {
+	if versionID == NullVersionID {
+		return ""
+	}
+	return versionID
+}
+
+func (l *LocalStorage) versionsRoot(bucket string) string {
+	return filepath.Join(l.rootPath, ".versions", bucket)
+}
+
+// Name prefixes of the directories in the version tree.
+const (
+	keyDirPrefix     = "k"
+	versionDirPrefix = "v"
+)
+
+func (l *LocalStorage) versionsDir(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	path := []string{l.versionsRoot(bucket)}
+	for i, segment := range segments {
+		if i < len(segments)-1 {
+			path = append(path, keyDirPrefix+segment)
+		} else {
+			path = append(path, versionDirPrefix+segment)
+		}
+	}
+	return filepath.Join(path...)
+}
+
+func (l *LocalStorage) versionPath(bucket, key, versionID string) string {
+	return filepath.Join(l.versionsDir(bucket, key), versionFileID(versionID))
+}
+
+// migrateVersionDirs moves the noncurrent versions that earlier releases
+// kept in .versions/<bucket>/<sha256(key)>/ into the version tree. Those
+// directory names are hex, so they cannot be mistaken for prefixed names.
+func (l *LocalStorage) migrateVersionDirs() error {
+	buckets, err := os.ReadDir(filepath.Join(l.rootPath, ".versions"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, bucketEntry := range buckets {
+		if !bucketEntry.IsDir() {
+			continue
+		}
+		bucket := bucketEntry.Name()
+
+		entries, err := os.ReadDir(l.versionsRoot(bucket))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, err := hex.DecodeString(entry.Name()); err != nil || !entry.IsDir() || len(entry.Name()) != 64 {
+				continue
+			}
+
+			dir := filepath.Join(l.versionsRoot(bucket), entry.Name())
+			versions, err := readVersionDir(dir)
+			if err != nil {
+				return err
+			}
+			if len(versions) == 0 {
+				os.Remove(dir)
+				continue
+			}
+
+			target := l.versionsDir(bucket, versions[0].Key)
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(dir, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readVersionMeta(path string) (*versionMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta versionMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// readVersionDir returns the noncurrent versions stored in dir, newest first.
+func readVersionDir(dir string) ([]*versionMeta, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []*versionMeta
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		meta, err := readVersionMeta(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		versions = append(versions, meta)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	return versions, nil
+}
+
+// currentObject returns the stored metadata and size of the current version
+// of key, or nil if the key has no current version.
+func (l *LocalStorage) currentObject(bucket, key string) (*versionMeta, error) {
+	stat, err := os.Stat(l.objectPath(bucket, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, nil
+	}
+
+	// Most callers hold l.mu, so metadata is not backfilled here
+	meta, err := l.loadObjectMeta(bucket, key, stat, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load object metadata: %v", err)
+	}
+
+	return &versionMeta{
+		objectMeta:   *meta,
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+// currentDeleteMarker returns the info of the delete marker hiding key, or
+// nil if key has no versions or its newest version is not a delete marker.
+// It is only meaningful once the current version was found missing.
+func (l *LocalStorage) currentDeleteMarker(bucket, key string) *ObjectInfo {
+	versions, err := readVersionDir(l.versionsDir(bucket, key))
+	if err != nil || len(versions) == 0 || !versions[0].DeleteMarker {
+		return nil
+	}
+	return versions[0].info()
+}
+
+// archiveCurrent copies the current version of key into the version store
+// before it is replaced or deleted. Unversioned buckets keep no history, and
+// while versioning is suspended the null version is simply replaced.
+func (l *LocalStorage) archiveCurrent(bucket, key, status string) error {
+	if status == "" {
+		return nil
+	}
+
+	if status == VersioningSuspended {
+		if err := l.removeVersion(bucket, key, ""); err != nil {
+			return err
+		}
+	}
+
+	current, err := l.currentObject(bucket, key)
+	if err != nil || current == nil {
+		return err
+	}
+	if status == VersioningSuspended && current.VersionID == "" {
+		return nil
+	}
+
+	versionPath := l.versionPath(bucket, key, current.VersionID)
+	if err := os.MkdirAll(filepath.Dir(versionPath), 0755); err != nil {
+		return err
+	}
+
+	// The current file is only ever replaced by rename, so a hard link is a
+	// stable snapshot of this version
+	os.Remove(versionPath)
+	if err := os.Link(l.objectPath(bucket, key), versionPath); err != nil {
+		if err := l.copyFile(l.objectPath(bucket, key), versionPath); err != nil {
+			return err
+		}
+	}
+
+	return l.writeMetaFile(versionPath+".json", current)
+}
+
+func (l *LocalStorage) copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	staged, err := l.createStagedFile()
+	if err != nil {
+		return err
+	}
+	defer staged.Discard()
+
+	if _, err := io.Copy(staged, in); err != nil {
+		return err
+	}
+
+	return staged.Commit(dst)
+}
+
+// removeVersion deletes a noncurrent version. Missing versions are ignored.
+func (l *LocalStorage) removeVersion(bucket, key, versionID string) error {
+	versionPath := l.versionPath(bucket, key, versionID)
+	for _, path := range []string{versionPath, versionPath + ".json"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Drop the key's directories once its last version is gone
+	removeEmptyParents(versionPath, l.versionsRoot(bucket))
+	return nil
+}
+
+// removeCurrent deletes the current version of key and its metadata.
+func (l *LocalStorage) removeCurrent(bucket, key string) error {
+	objectPath := l.objectPath(bucket, key)
+	if stat, err := os.Stat(objectPath); err != nil || stat.IsDir() {
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(objectPath); err != nil {
+		return err
+	}
+	removeEmptyParents(objectPath, l.bucketPath(bucket))
+
+	return l.deleteObjectMeta(bucket, key)
+}
+
+// promoteLatest restores the newest noncurrent version of key as the
+// current version after the current one was permanently deleted. Nothing is
+// restored when the newest version is a delete marker.
+func (l *LocalStorage) promoteLatest(bucket, key string) error {
+	current, err := l.currentObject(bucket, key)
+	if err != nil || current != nil {
+		return err
+	}
+
+	versions, err := readVersionDir(l.versionsDir(bucket, key))
+	if err != nil || len(versions) == 0 || versions[0].DeleteMarker {
+		return err
+	}
+	latest := versions[0]
+
+	objectPath := l.objectPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(l.versionPath(bucket, key, latest.VersionID), objectPath); err != nil {
+		return err
+	}
+	os.Chtimes(objectPath, latest.LastModified, latest.LastModified)
+
+	if err := l.writeObjectMeta(bucket, key, &latest.objectMeta); err != nil {
+		return err
+	}
+
+	return l.removeVersion(bucket, key, latest.VersionID)
+}
+
+// lookupVersion returns the path holding the data of a specific version of
+// key together with its info.
+func (l *LocalStorage) lookupVersion(bucket, key, versionID string) (string, *ObjectInfo, error) {
+	versionID = normalizeVersionID(versionID)
+
+	current, err := l.currentObject(bucket, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if current != nil && current.VersionID == versionID {
+		return l.objectPath(bucket, key), current.info(), nil
+	}
+
+	versionPath := l.versionPath(bucket, key, versionID)
+	meta, err := readVersionMeta(versionPath + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, ErrNoSuchVersion
+		}
+		return "", nil, err
+	}
+	if meta.DeleteMarker {
+		return "", meta.info(), ErrDeleteMarker
+	}
+
+	return versionPath, meta.info(), nil
+}
+
+// deleteObjectVersion permanently removes one version of key. Deleting the
+// current version, or the delete marker hiding the object, makes the next
+// newest version current again.
+func (l *LocalStorage) deleteObjectVersion(bucket, key, versionID string) (*ObjectInfo, error) {
+	versionID = normalizeVersionID(versionID)
+	result := &ObjectInfo{Key: key, VersionID: versionID}
+
+	current, err := l.currentObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && current.VersionID == versionID {
+		if err := l.removeCurrent(bucket, key); err != nil {
+			return nil, err
+		}
+	} else {
+		meta, err := readVersionMeta(l.versionPath(bucket, key, versionID) + ".json")
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Deleting a missing version succeeds, as in S3
+				return result, nil
+			}
+			return nil, err
+		}
+		result.DeleteMarker = meta.DeleteMarker
+		if err := l.removeVersion(bucket, key, versionID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.promoteLatest(bucket, key); err != nil {
+		return nil, fmt.Errorf("failed to restore previous version: %v", err)
+	}
+
+	return result, nil
+}
+
+// addDeleteMarker hides key behind a new delete marker, keeping its current
+// version as a noncurrent one.
+func (l *LocalStorage) addDeleteMarker(bucket, key, status string) (*ObjectInfo, error) {
+	if err := l.archiveCurrent(bucket, key, status); err != nil {
+		return nil, fmt.Errorf("failed to archive current version: %v", err)
+	}
+
+	marker := &versionMeta{
+		Key:          key,
+		LastModified: time.Now(),
+		DeleteMarker: true,
+	}
+	if status == VersioningEnabled {
+		marker.VersionID = newVersionID()
+	}
+
+	versionPath := l.versionPath(bucket, key, marker.VersionID)
+	if err := l.writeMetaFile(versionPath+".json", marker); err != nil {
+		return nil, err
+	}
+
+	if err := l.removeCurrent(bucket, key); err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{Key: key, VersionID: marker.VersionID, DeleteMarker: true}, nil
+}
+
+func (l *LocalStorage) ListObjectVersions(ctx context.Context, bucket, prefix, delimiter, keyMarker, versionIDMarker string, maxKeys int) (*ListObjectVersionsResult, error) {
+	if err := l.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	lister := &versionLister{
+		storage:         l,
+		bucket:          bucket,
+		keys:            objectLister{prefix: prefix, delimiter: delimiter},
+		keyMarker:       keyMarker,
+		versionIDMarker: versionIDMarker,
+		maxKeys:         maxKeys,
+	}
+	if err := lister.walk(l.bucketPath(bucket), l.versionsRoot(bucket), ""); err != nil && err != errStopWalk {
+		return nil, err
+	}
+
+	result := &lister.result
+	if !result.IsTruncated {
+		result.NextKeyMarker = ""
+		result.NextVersionIDMarker = ""
+	}
+
+	return result, nil
+}
+
+// versionTreeEntry is a name in a directory of the bucket merged with the
+// same name in the matching directory of the version tree. Names of nested
+// key directories sort as "name/", as in readSortedDir.
+type versionTreeEntry struct {
+	sortKey string
+	// object is the current version's data file, or the directory of
+	// nested objects
+	object os.DirEntry
+	// versions is the key's version directory, or the version tree
+	// directory of nested keys
+	versions string
+}
+
+// readVersionTree returns the merged contents of objectDir and versionDir,
+// either of which may be missing, in key order.
+func readVersionTree(objectDir, versionDir string) ([]versionTreeEntry, error) {
+	byKey := make(map[string]*versionTreeEntry)
+	entry := func(sortKey string) *versionTreeEntry {
+		if byKey[sortKey] == nil {
+			byKey[sortKey] = &versionTreeEntry{sortKey: sortKey}
+		}
+		return byKey[sortKey]
+	}
+
+	objects, err := os.ReadDir(objectDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, object := range objects {
+		sortKey := object.Name()
+		if object.IsDir() {
+			sortKey += "/"
+		}
+		entry(sortKey).object = object
+	}
+
+	dirs, err := os.ReadDir(versionDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		name := dir.Name()
+		if !dir.IsDir() {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, keyDirPrefix):
+			entry(strings.TrimPrefix(name, keyDirPrefix) + "/").versions = filepath.Join(versionDir, name)
+		case strings.HasPrefix(name, versionDirPrefix):
+			entry(strings.TrimPrefix(name, versionDirPrefix)).versions = filepath.Join(versionDir, name)
+		}
+	}
+
+	entries := make([]versionTreeEntry, 0, len(byKey))
+	for _, e := range byKey {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sortKey < entries[j].sortKey
+	})
+
+	return entries, nil
+}
+
+// versionLister accumulates one page of a ListObjectVersions call by walking
+// the bucket and its version tree side by side, stopping once the page is
+// full.
+type versionLister struct {
+	storage         *LocalStorage
+	bucket          string
+	keys            objectLister
+	keyMarker       string
+	versionIDMarker string
+	maxKeys         int
+	result          ListObjectVersionsResult
+	count           int
+}
+
+func (v *versionLister) addPrefix(prefix string) error {
+	// A marker inside the prefix means it was returned already
+	if prefix == v.keys.lastPrefix || strings.HasPrefix(v.keyMarker, prefix) {
+		return nil
+	}
+	if v.count >= v.maxKeys {
+		v.result.IsTruncated = true
+		return errStopWalk
+	}
+	v.result.CommonPrefixes = append(v.result.CommonPrefixes, prefix)
+	v.result.NextKeyMarker = prefix
+	v.result.NextVersionIDMarker = ""
+	v.keys.lastPrefix = prefix
+	v.count++
+	return nil
+}
+
+func (v *versionLister) addKey(key string, entry versionTreeEntry) error {
+	if prefix, ok := v.keys.commonPrefix(key); ok {
+		return v.addPrefix(prefix)
+	}
+	if key == v.keyMarker && v.versionIDMarker == "" {
+		return nil
+	}
+
+	var versions []ObjectVersion
+	if entry.object != nil {
+		stat, err := entry.object.Info()
+		if err == nil {
+			info, err := v.storage.objectInfo(v.bucket, key, stat)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, ObjectVersion{ObjectInfo: *info, IsLatest: true})
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if entry.versions != "" {
+		noncurrent, err := readVersionDir(entry.versions)
+		if err != nil {
+			return err
+		}
+		// Without a current version the newest noncurrent one is latest
+		hasCurrent := len(versions) > 0
+		for i, version := range noncurrent {
+			versions = append(versions, ObjectVersion{
+				ObjectInfo: *version.info(),
+				IsLatest:   i == 0 && !hasCurrent,
+			})
+		}
+	}
+
+	if key == v.keyMarker {
+		// Resume after the marker version; if it has since been deleted
+		// there is no telling which versions were returned, so move on
+		resumed := false
+		for i, version := range versions {
+			if versionFileID(version.VersionID) == v.versionIDMarker {
+				versions = versions[i+1:]
+				resumed = true
+				break
+			}
+		}
+		if !resumed {
+			return nil
+		}
+	}
+
+	for _, version := range versions {
+		if v.count >= v.maxKeys {
+			v.result.IsTruncated = true
+			return errStopWalk
+		}
+		v.result.Versions = append(v.result.Versions, version)
+		v.result.NextKeyMarker = key
+		v.result.NextVersionIDMarker = versionFileID(version.VersionID)
+		v.count++
+	}
+	return nil
+}
+
+// walk visits objectDir and versionDir, whose contents have keys starting
+// with keyPrefix.
+func (v *versionLister) walk(objectDir, versionDir, keyPrefix string) error {
+	entries, err := readVersionTree(objectDir, versionDir)
+	if err != nil {
+		return err
+	}
+
+	prefix := v.keys.prefix
+	for _, entry := range entries {
+		key := keyPrefix + entry.sortKey
+
+		if !strings.HasSuffix(entry.sortKey, "/") {
+			if strings.HasPrefix(key, prefix) && key >= v.keyMarker {
+				if err := v.addKey(key, entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Skip subtrees that cannot contain keys matching the prefix or
+		// whose keys all sort before the marker
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			continue
+		}
+		if key < v.keyMarker && !strings.HasPrefix(v.keyMarker, key) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.sortKey, "/")
+		subObjects := filepath.Join(objectDir, name)
+		subVersions := entry.versions
+		if subVersions == "" {
+			subVersions = filepath.Join(versionDir, keyDirPrefix+name)
+		}
+
+		// When every key below this directory rolls up into the same common
+		// prefix there is no need to descend beyond finding one version
+		if strings.HasPrefix(key, prefix) && key >= v.keyMarker {
+			if commonPrefix, ok := v.keys.commonPrefix(key); ok && len(commonPrefix) <= len(key) {
+				if hasObjects(subObjects) || hasObjects(subVersions) {
+					if err := v.addPrefix(commonPrefix); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+
+		if err := v.walk(subObjects, subVersions, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
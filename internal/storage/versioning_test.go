@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readObjectVersion(t *testing.T, s *LocalStorage, bucket, key, versionID string) string {
+	t.Helper()
+	reader, _, err := s.GetObject(context.Background(), bucket, key, versionID, "")
+	if err != nil {
+		t.Fatalf("GetObject %s@%s failed: %v", key, versionID, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestObjectVersioning(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-versioning-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "versioned-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key, content string) *ObjectInfo {
+		t.Helper()
+		info, err := storage.PutObject(ctx, bucket, key, strings.NewReader(content), int64(len(content)), ObjectMetadata{})
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		return info
+	}
+
+	// Written before versioning was enabled, so this becomes the null version
+	put("doc.txt", "v0")
+
+	t.Run("Configuration", func(t *testing.T) {
+		status, err := storage.GetBucketVersioning(ctx, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != "" {
+			t.Errorf("Expected no versioning status, got '%s'", status)
+		}
+
+		if err := storage.PutBucketVersioning(ctx, bucket, "Disabled"); err != ErrInvalidVersioningStatus {
+			t.Errorf("Expected ErrInvalidVersioningStatus, got %v", err)
+		}
+		if err := storage.PutBucketVersioning(ctx, bucket, VersioningEnabled); err != nil {
+			t.Fatal(err)
+		}
+
+		status, _ = storage.GetBucketVersioning(ctx, bucket)
+		if status != VersioningEnabled {
+			t.Errorf("Expected status Enabled, got '%s'", status)
+		}
+	})
+
+	var v1, v2 *ObjectInfo
+
+	t.Run("OverwriteKeepsPreviousVersions", func(t *testing.T) {
+		v1 = put("doc.txt", "v1")
+		v2 = put("doc.txt", "v2")
+		if v1.VersionID == "" || v2.VersionID == "" || v1.VersionID == v2.VersionID {
+			t.Fatalf("Expected distinct version IDs, got '%s' and '%s'", v1.VersionID, v2.VersionID)
+		}
+
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", ""); got != "v2" {
+			t.Errorf("Expected current content 'v2', got '%s'", got)
+		}
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", v1.VersionID); got != "v1" {
+			t.Errorf("Expected content 'v1', got '%s'", got)
+		}
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", NullVersionID); got != "v0" {
+			t.Errorf("Expected null version content 'v0', got '%s'", got)
+		}
+
+		info, err := storage.HeadObject(ctx, bucket, "doc.txt", v2.VersionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.VersionID != v2.VersionID {
+			t.Errorf("Expected version '%s', got '%s'", v2.VersionID, info.VersionID)
+		}
+
+		if _, err := storage.HeadObject(ctx, bucket, "doc.txt", "0123456789abcdef"); err != ErrNoSuchVersion {
+			t.Errorf("Expected ErrNoSuchVersion, got %v", err)
+		}
+		if _, err := storage.HeadObject(ctx, bucket, "doc.txt", "../doc.txt"); err != ErrInvalidVersionID {
+			t.Errorf("Expected ErrInvalidVersionID, got %v", err)
+		}
+	})
+
+	var marker *ObjectInfo
+
+	t.Run("DeleteCreatesMarker", func(t *testing.T) {
+		marker, err = storage.DeleteObject(ctx, bucket, "doc.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !marker.DeleteMarker || marker.VersionID == "" {
+			t.Errorf("Expected a versioned delete marker, got %+v", marker)
+		}
+
+		info, err := storage.HeadObject(ctx, bucket, "doc.txt", "")
+		if err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if info == nil || !info.DeleteMarker || info.VersionID != marker.VersionID {
+			t.Errorf("Expected the delete marker with the error, got %+v", info)
+		}
+		_, info, err = storage.GetObject(ctx, bucket, "doc.txt", marker.VersionID, "")
+		if err != ErrDeleteMarker {
+			t.Errorf("Expected ErrDeleteMarker, got %v", err)
+		}
+		if info == nil || !info.DeleteMarker || info.VersionID != marker.VersionID {
+			t.Errorf("Expected the delete marker with the error, got %+v", info)
+		}
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", v2.VersionID); got != "v2" {
+			t.Errorf("Expected content 'v2', got '%s'", got)
+		}
+
+		listing, _ := storage.ListObjects(ctx, bucket, "", "", "", 1000)
+		if len(listing.Objects) != 0 {
+			t.Errorf("Expected deleted object to be hidden from listings, got %d objects", len(listing.Objects))
+		}
+		if err := storage.DeleteBucket(ctx, bucket); err != ErrBucketNotEmpty {
+			t.Errorf("Expected ErrBucketNotEmpty while versions remain, got %v", err)
+		}
+	})
+
+	t.Run("ListObjectVersions", func(t *testing.T) {
+		result, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{marker.VersionID, v2.VersionID, v1.VersionID, ""}
+		if len(result.Versions) != len(want) {
+			t.Fatalf("Expected %d versions, got %d", len(want), len(result.Versions))
+		}
+		for i, version := range result.Versions {
+			if version.VersionID != want[i] {
+				t.Errorf("Version %d: expected '%s', got '%s'", i, want[i], version.VersionID)
+			}
+			if version.IsLatest != (i == 0) {
+				t.Errorf("Version %d: unexpected IsLatest %v", i, version.IsLatest)
+			}
+		}
+		if !result.Versions[0].DeleteMarker {
+			t.Error("Expected newest version to be the delete marker")
+		}
+
+		page, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !page.IsTruncated || page.NextKeyMarker != "doc.txt" || page.NextVersionIDMarker != v2.VersionID {
+			t.Fatalf("Unexpected first page: %+v", page)
+		}
+		page, err = storage.ListObjectVersions(ctx, bucket, "", "", page.NextKeyMarker, page.NextVersionIDMarker, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Versions) != 2 || page.Versions[0].VersionID != v1.VersionID || page.IsTruncated {
+			t.Errorf("Unexpected second page: %+v", page)
+		}
+	})
+
+	t.Run("DeleteMarkerRestoresObject", func(t *testing.T) {
+		result, err := storage.DeleteObject(ctx, bucket, "doc.txt", marker.VersionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.DeleteMarker {
+			t.Error("Expected the removed version to be reported as a delete marker")
+		}
+
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", ""); got != "v2" {
+			t.Errorf("Expected restored content 'v2', got '%s'", got)
+		}
+	})
+
+	t.Run("DeleteCurrentVersionPromotesPrevious", func(t *testing.T) {
+		if _, err := storage.DeleteObject(ctx, bucket, "doc.txt", v2.VersionID); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.HeadObject(ctx, bucket, "doc.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.VersionID != v1.VersionID {
+			t.Errorf("Expected version '%s' to become current, got '%s'", v1.VersionID, info.VersionID)
+		}
+		if _, err := storage.HeadObject(ctx, bucket, "doc.txt", v2.VersionID); err != ErrNoSuchVersion {
+			t.Errorf("Expected ErrNoSuchVersion, got %v", err)
+		}
+	})
+
+	t.Run("SuspendedReplacesNullVersion", func(t *testing.T) {
+		if err := storage.PutBucketVersioning(ctx, bucket, VersioningSuspended); err != nil {
+			t.Fatal(err)
+		}
+
+		info := put("doc.txt", "null-1")
+		if info.VersionID != "" {
+			t.Errorf("Expected null version, got '%s'", info.VersionID)
+		}
+		put("doc.txt", "null-2")
+
+		result, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// null-2 replaced null-1, which had replaced the archived v0
+		var ids []string
+		for _, version := range result.Versions {
+			ids = append(ids, versionFileID(version.VersionID))
+		}
+		if strings.Join(ids, ",") != "null,"+v1.VersionID {
+			t.Errorf("Unexpected versions after suspended writes: %v", ids)
+		}
+		if got := readObjectVersion(t, storage, bucket, "doc.txt", NullVersionID); got != "null-2" {
+			t.Errorf("Expected null version content 'null-2', got '%s'", got)
+		}
+	})
+
+	t.Run("DeleteAllVersionsEmptiesBucket", func(t *testing.T) {
+		result, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, version := range result.Versions {
+			if _, err := storage.DeleteObject(ctx, bucket, version.Key, versionFileID(version.VersionID)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := storage.DeleteBucket(ctx, bucket); err != nil {
+			t.Errorf("DeleteBucket failed: %v", err)
+		}
+	})
+}
+
+func TestListObjectVersionsPaging(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-version-listing-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	bucket := "versioned-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PutBucketVersioning(ctx, bucket, VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key string) *ObjectInfo {
+		t.Helper()
+		info, err := storage.PutObject(ctx, bucket, key, strings.NewReader(key), int64(len(key)), ObjectMetadata{})
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		return info
+	}
+	put("a/c")
+	put("a/c")
+	put("a-b")
+	put("b")
+	put("b")
+	put("deleted/x")
+	if _, err := storage.DeleteObject(ctx, bucket, "deleted/x", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	keysOf := func(result *ListObjectVersionsResult) string {
+		var keys []string
+		for _, version := range result.Versions {
+			keys = append(keys, version.Key)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	t.Run("KeyOrder", func(t *testing.T) {
+		result, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Keys only left as noncurrent versions are listed too
+		if got := keysOf(result); got != "a-b,a/c,a/c,b,b,deleted/x,deleted/x" {
+			t.Errorf("Unexpected versions %s", got)
+		}
+	})
+
+	t.Run("Delimiter", func(t *testing.T) {
+		result, err := storage.ListObjectVersions(ctx, bucket, "", "/", "", "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keysOf(result); got != "a-b,b,b" {
+			t.Errorf("Unexpected versions %s", got)
+		}
+		if strings.Join(result.CommonPrefixes, ",") != "a/,deleted/" {
+			t.Errorf("Unexpected common prefixes %v", result.CommonPrefixes)
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		var keys []string
+		keyMarker, versionIDMarker := "", ""
+		for {
+			page, err := storage.ListObjectVersions(ctx, bucket, "", "", keyMarker, versionIDMarker, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, keysOf(page))
+			if !page.IsTruncated {
+				break
+			}
+			keyMarker, versionIDMarker = page.NextKeyMarker, page.NextVersionIDMarker
+		}
+		if got := strings.Join(keys, ","); got != "a-b,a/c,a/c,b,b,deleted/x,deleted/x" {
+			t.Errorf("Unexpected versions %s", got)
+		}
+	})
+
+	t.Run("DeletedMarkerVersion", func(t *testing.T) {
+		page, err := storage.ListObjectVersions(ctx, bucket, "b", "", "", "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.DeleteObject(ctx, bucket, "b", page.NextVersionIDMarker); err != nil {
+			t.Fatal(err)
+		}
+
+		// The rest of b's versions cannot be told apart from those already
+		// returned, so the listing resumes after b
+		page, err = storage.ListObjectVersions(ctx, bucket, "", "", page.NextKeyMarker, page.NextVersionIDMarker, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keysOf(page); got != "deleted/x,deleted/x" {
+			t.Errorf("Unexpected versions %s", got)
+		}
+	})
+}
+
+func TestVersionDirMigration(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "porter-version-migration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	bucket := "versioned-bucket"
+	if err := storage.CreateBucket(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	// Versions as earlier releases stored them
+	sum := sha256.Sum256([]byte("dir/doc.txt"))
+	legacyDir := filepath.Join(tmpDir, ".versions", bucket, hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyDir, "v1"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta := `{"etag": "x", "version_id": "v1", "key": "dir/doc.txt", "size": 3, "last_modified": "2024-01-01T00:00:00Z"}`
+	if err := os.WriteFile(filepath.Join(legacyDir, "v1.json"), []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readObjectVersion(t, storage, bucket, "dir/doc.txt", "v1"); got != "old" {
+		t.Errorf("Expected migrated version content 'old', got '%s'", got)
+	}
+	result, err := storage.ListObjectVersions(ctx, bucket, "", "", "", "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Versions) != 1 || result.Versions[0].Key != "dir/doc.txt" || !result.Versions[0].IsLatest {
+		t.Errorf("Expected the migrated version, got %+v", result.Versions)
+	}
+	if _, err := os.Stat(legacyDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the legacy directory to be moved, got %v", err)
+	}
+}
@@ -100,7 +100,7 @@ fi
 # Test 7: Test authenticated endpoints (should fail without proper auth)
 log "7. Testing authenticated endpoints (should fail without proper auth)..."
 AUTH_RESPONSE=$(curl -s "$BASE_URL_HTTPS/" 2>/dev/null || echo "FAILED")
-if echo "$AUTH_RESPONSE" | grep -q "Unauthorized"; then
+if echo "$AUTH_RESPONSE" | grep -q "AccessDenied"; then
     echo "   ✅ Authentication middleware is working (correctly rejecting unauthenticated requests)"
 else
     warn "   ⚠️  Authentication middleware might not be working as expected"
@@ -109,8 +109,8 @@ fi
 # Test 8: Test with AWS CLI
 log "8. Testing with AWS CLI..."
 AWS_RESPONSE=$(aws --endpoint-url "$BASE_URL_HTTPS" --no-verify-ssl s3 ls 2>&1 || echo "FAILED")
-if echo "$AWS_RESPONSE" | grep -q "401"; then
-    echo "   ✅ AWS CLI authentication working (correctly rejecting with 401)"
+if echo "$AWS_RESPONSE" | grep -q "403"; then
+    echo "   ✅ AWS CLI authentication working (correctly rejecting with 403)"
 else
     warn "   ⚠️  AWS CLI test result: $AWS_RESPONSE"
 fi
@@ -118,8 +118,8 @@ fi
 # Test 9: Test with custom test client
 log "9. Testing with custom test client..."
 CLIENT_RESPONSE=$(go run ./cmd/test-client -endpoint="$BASE_URL_HTTPS" -access-key=porter-test-key -secret-key=porter-test-secret-key-must-be-long-enough -bucket=test-bucket 2>&1 || echo "FAILED")
-if echo "$CLIENT_RESPONSE" | grep -q "401"; then
-    echo "   ✅ Custom test client authentication working (correctly rejecting with 401)"
+if echo "$CLIENT_RESPONSE" | grep -q "403"; then
+    echo "   ✅ Custom test client authentication working (correctly rejecting with 403)"
 else
     warn "   ⚠️  Custom test client result: $CLIENT_RESPONSE"
 fi
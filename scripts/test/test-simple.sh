@@ -48,7 +48,7 @@ fi
 
 # Test 3: Test authenticated endpoint (should fail without auth)
 log "3. Testing authenticated endpoint (should fail)..."
-if curl -s "$BASE_URL/" | grep -q "Unauthorized"; then
+if curl -s "$BASE_URL/" | grep -q "AccessDenied"; then
     echo "   ✅ Authentication is working (correctly rejecting unauthenticated requests)"
 else
     warn "   ⚠️  Authentication might not be working as expected"